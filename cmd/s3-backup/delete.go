@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+// newDeleteCmd creates the `delete` command
+func newDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Deletes a backup and every object belonging to it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			backupName, _ := cmd.Flags().GetString("backup-name")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			force, _ := cmd.Flags().GetBool("force")
+			output, _ := cmd.Flags().GetString("output")
+			if len(backupName) == 0 {
+				return errors.New("the backup name is required")
+			}
+
+			rep, err := executor.NewRepository(
+				os.Getenv("AWS_BUCKET"),
+				os.Getenv("BACKUP_PREFIX"),
+				os.Getenv("COMPRESSION"),
+			)
+			if err != nil {
+				return err
+			}
+
+			// Holding the backup lock keeps the retention policy
+			// from removing the same objects concurrently
+			if !dryRun {
+				lock, _, err := rep.AcquireLock(cmd.Context(), "delete "+backupName, 0)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					_ = lock.Release(cmd.Context())
+				}()
+			}
+
+			result, err := rep.Delete(cmd.Context(), backupName, executor.DeleteOptions{
+				DryRun: dryRun,
+				Force:  force,
+			})
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(result)
+			}
+
+			printDeleteResult(result)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("backup-name", "", "The backup name to delete")
+	cmd.Flags().Bool("dry-run", false, "Only list the objects which would be deleted")
+	cmd.Flags().Bool("force", false,
+		"Delete the backup even when it is the last one or other backups depend on it")
+	cmd.Flags().StringP("output", "o", "text", "The output format, text or json")
+
+	return cmd
+}
+
+func printDeleteResult(result *executor.DeleteResult) {
+	verb := "Deleted"
+	if result.DryRun {
+		verb = "Would delete"
+	}
+
+	fmt.Printf("%s backup %s\n", verb, result.Backup)
+	for _, key := range result.Keys {
+		fmt.Printf("  %s\n", key)
+	}
+	for _, dependent := range result.Dependents {
+		fmt.Printf("Warning: backup %s depends on it and will not be restorable\n", dependent)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+// newDownloadCmd creates the `download` command
+func newDownloadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "download [backup-name]",
+		Short: "Downloads the archive of a backup without restoring it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			backupName, _ := cmd.Flags().GetString("backup-name")
+			if len(args) > 0 {
+				backupName = args[0]
+			}
+			outputPath, _ := cmd.Flags().GetString("output")
+			extract, _ := cmd.Flags().GetBool("extract")
+			if len(backupName) == 0 {
+				return errors.New("the backup name is required")
+			}
+
+			rep, err := executor.NewRepository(
+				os.Getenv("AWS_BUCKET"),
+				os.Getenv("BACKUP_PREFIX"),
+				os.Getenv("COMPRESSION"),
+			)
+			if err != nil {
+				return err
+			}
+			throttling, err := backup.ThrottlingFromEnv()
+			if err != nil {
+				return err
+			}
+			rep.SetThrottling(throttling)
+			rep.SetTiering(backup.TieringFromEnv())
+
+			// The logs go to stderr, so that the archive
+			// can be piped into other tools
+			var output io.Writer = os.Stdout
+			if outputPath != "-" {
+				f, err := os.Create(outputPath)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if closeErr := f.Close(); err == nil {
+						err = closeErr
+					}
+					if err != nil {
+						_ = os.Remove(outputPath)
+					}
+				}()
+				output = f
+			}
+
+			return rep.Download(cmd.Context(), backupName, output, extract)
+		},
+	}
+
+	cmd.Flags().String("backup-name", "", "The backup name to download")
+	cmd.Flags().String("output", "-", "The file the archive is written to, - for the standard output")
+	cmd.Flags().Bool("extract", false, "Write the SQL dump of a logical backup instead of its archive")
+
+	return cmd
+}
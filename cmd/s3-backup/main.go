@@ -14,6 +14,9 @@ func main() {
 	rootCmd.AddCommand(
 		newPluginCmd(),
 		newRestoreCmd(),
+		newStatusCmd(),
+		newDeleteCmd(),
+		newDownloadCmd(),
 	)
 
 	err := rootCmd.Execute()
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"os"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
 	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/pluginhelper"
 	"github.com/cloudnative-pg/cnpg-i/pkg/backup"
 	"github.com/cloudnative-pg/cnpg-i/pkg/lifecycle"
@@ -9,16 +12,26 @@ import (
 	"google.golang.org/grpc"
 
 	backupImpl "github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/health"
 	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/identity"
 	lifecycleImpl "github.com/dougkirkley/cnpg-plugin-s3-backup/internal/lifecycle"
 	operatorImpl "github.com/dougkirkley/cnpg-plugin-s3-backup/internal/operator"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/scheduler"
 )
 
 // newPluginCmd creates the `plugin` command
 func newPluginCmd() *cobra.Command {
-	cmd := pluginhelper.CreateMainCmd(identity.Identity{}, func(server *grpc.Server) error {
+	checker := &health.Checker{}
+
+	// The health server reports the progress of the backups taken both
+	// by the backup service and by the scheduler
+	progress := executor.NewProgressTracker()
+	dependencies := executor.Dependencies{Progress: progress}
+
+	cmd := pluginhelper.CreateMainCmd(identity.Identity{Checker: checker}, func(server *grpc.Server) error {
 		operator.RegisterOperatorServer(server, operatorImpl.Operator{})
-		backup.RegisterBackupServer(server, backupImpl.Server{})
+		backup.RegisterBackupServer(server, backupImpl.Server{Dependencies: dependencies})
 		lifecycle.RegisterOperatorLifecycleServer(server, lifecycleImpl.Lifecycle{})
 		return nil
 	})
@@ -26,5 +39,41 @@ func newPluginCmd() *cobra.Command {
 	cmd.Use = "plugin"
 	cmd.Short = "Runs the cnpg-i plugin server for Cloudnative-PG backups to S3"
 
+	// The health checks and the scheduled backups only make sense in the
+	// sidecar taking the backups, which is the only one setting the
+	// health address
+	cmd.Flags().String("health-address", "", "The address of the health server, disabled when empty")
+	runE := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		address, _ := cmd.Flags().GetString("health-address")
+		if len(address) > 0 {
+			checker.Enable(
+				os.Getenv("AWS_BUCKET"),
+				executor.DefaultWorkingDir,
+				progress,
+				executor.RequiredBinaries()...,
+			)
+			go func() {
+				if err := checker.ListenAndServe(cmd.Context(), address); err != nil {
+					logging.FromContext(cmd.Context()).Error(err, "health server failed")
+				}
+			}()
+
+			backupScheduler, err := scheduler.FromEnv(dependencies)
+			if err != nil {
+				return err
+			}
+			if backupScheduler != nil {
+				go func() {
+					if err := backupScheduler.Run(cmd.Context()); err != nil {
+						logging.FromContext(cmd.Context()).Error(err, "backup scheduler failed")
+					}
+				}()
+			}
+		}
+
+		return runE(cmd, args)
+	}
+
 	return cmd
 }
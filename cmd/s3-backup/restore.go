@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
 	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
 	"github.com/spf13/cobra"
 	"os"
@@ -12,27 +16,117 @@ func newRestoreCmd() *cobra.Command {
 		Use:   "restore",
 		Short: "Restores a Postgres backup to the current Postgres cluster",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			bucket := os.Getenv("AWS_BUCKET")
-			prefix := os.Getenv("BACKUP_PREFIX")
-			backupName, _ := cmd.Flags().GetString("backup-name")
-
-			rep, err := executor.NewRepository(
-				bucket,
-				prefix,
-			)
-			if err != nil {
-				return err
-			}
+			output, _ := cmd.Flags().GetString("output")
 
-			err = rep.Restore(cmd.Context(), backupName)
-			if err != nil {
-				return err
+			report, err := runRestore(cmd)
+			if report != nil {
+				if printErr := printRestoreReport(output, report); printErr != nil {
+					return printErr
+				}
 			}
-			return nil
+			return err
 		},
 	}
 
 	cmd.Flags().String("backup-name", "", "The backup name to restore")
+	cmd.Flags().String("pgdata", "/var/lib/postgresql/data/pgdata",
+		"The data directory where physical backups are combined into")
+	cmd.Flags().String("restore-tier", "",
+		"The retrieval tier of archived backups: Expedited, Standard or Bulk")
+	cmd.Flags().Int32("restore-days", 1,
+		"How many days the restored copy of an archived backup stays readable")
+	cmd.Flags().String("from-file", "",
+		"Restore a local archive or SQL dump instead of a backup of the repository")
+	cmd.Flags().String("host", "",
+		"The host or socket directory of the PostgreSQL logical backups are restored into, "+
+			"the local instance when empty")
+	cmd.Flags().Int("port", 0, "The port of the PostgreSQL logical backups are restored into")
+	cmd.Flags().String("dbname", "", "The database psql connects to when restoring logical backups")
+	cmd.Flags().String("database", "",
+		"Restore only this database, from a backup taken with per-database dumps")
+	cmd.Flags().String("schema", "", "Restore only this schema of the database")
+	cmd.Flags().String("table", "", "Restore only this table of the database")
+	cmd.Flags().String("rename-to", "",
+		"Restore the database under this name, creating it when missing")
+	cmd.Flags().Bool("continue-on-error", false,
+		"Keep restoring after a statement fails instead of stopping at the first error")
+	cmd.Flags().Bool("single-transaction", false,
+		"Restore the global objects and every database in their own transaction, "+
+			"backups taken without per-database dumps are refused")
+	cmd.Flags().StringP("output", "o", "text", "The output format of the restore report, text or json")
+	cmd.MarkFlagsMutuallyExclusive("backup-name", "from-file")
 
 	return cmd
 }
+
+// runRestore restores the backup selected by the flags of the command
+func runRestore(cmd *cobra.Command) (*executor.RestoreReport, error) {
+	backupName, _ := cmd.Flags().GetString("backup-name")
+	pgData, _ := cmd.Flags().GetString("pgdata")
+	fromFile, _ := cmd.Flags().GetString("from-file")
+
+	var options executor.RestoreOptions
+	options.Connection.Host, _ = cmd.Flags().GetString("host")
+	options.Connection.Port, _ = cmd.Flags().GetInt("port")
+	options.Connection.DBName, _ = cmd.Flags().GetString("dbname")
+	options.Selection.Database, _ = cmd.Flags().GetString("database")
+	options.Selection.Schema, _ = cmd.Flags().GetString("schema")
+	options.Selection.Table, _ = cmd.Flags().GetString("table")
+	options.Selection.RenameTo, _ = cmd.Flags().GetString("rename-to")
+	options.ContinueOnError, _ = cmd.Flags().GetBool("continue-on-error")
+	options.SingleTransaction, _ = cmd.Flags().GetBool("single-transaction")
+
+	if len(fromFile) > 0 {
+		return executor.Environment{}.RestoreFile(cmd.Context(), fromFile, pgData, options)
+	}
+
+	rep, err := executor.NewRepository(
+		os.Getenv("AWS_BUCKET"),
+		os.Getenv("BACKUP_PREFIX"),
+		os.Getenv("COMPRESSION"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	throttling, err := backup.ThrottlingFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	rep.SetThrottling(throttling)
+
+	tiering := backup.TieringFromEnv()
+	if tier, _ := cmd.Flags().GetString("restore-tier"); len(tier) > 0 {
+		tiering.RestoreTier = types.Tier(tier)
+	}
+	tiering.RestoreDays, _ = cmd.Flags().GetInt32("restore-days")
+	rep.SetTiering(tiering)
+	rep.SetRestoreOptions(options)
+
+	return rep.Restore(cmd.Context(), backupName, pgData)
+}
+
+func printRestoreReport(output string, report *executor.RestoreReport) error {
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	if report.ErrorCount == 0 {
+		return nil
+	}
+
+	fmt.Printf("Restore of %s raised %d errors\n", report.Backup, report.ErrorCount)
+	for i := range report.Errors {
+		restoreError := &report.Errors[i]
+		fmt.Printf("%s: %s\n", restoreError.Source, restoreError.Error())
+		if len(restoreError.Statement) > 0 {
+			fmt.Printf("  statement: %s\n", restoreError.Statement)
+		}
+	}
+	if omitted := report.ErrorCount - len(report.Errors); omitted > 0 {
+		fmt.Printf("%d more errors not shown\n", omitted)
+	}
+
+	return nil
+}
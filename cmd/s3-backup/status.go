@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/health"
+)
+
+// newStatusCmd creates the `status` command
+func newStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Shows the progress of the backup running in the sidecar",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, _ := cmd.Flags().GetString("address")
+			output, _ := cmd.Flags().GetString("output")
+
+			req, err := http.NewRequestWithContext(cmd.Context(), http.MethodGet, address+health.ProgressPath, nil)
+			if err != nil {
+				return err
+			}
+
+			client := &http.Client{Timeout: 10 * time.Second}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("error while querying the backup progress: %d", resp.StatusCode)
+			}
+
+			var progress executor.Progress
+			if err := json.NewDecoder(resp.Body).Decode(&progress); err != nil {
+				return err
+			}
+
+			if output == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(progress)
+			}
+
+			printProgress(progress)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("address", fmt.Sprintf("http://localhost:%d", health.DefaultPort),
+		"The address of the health server of the sidecar")
+	cmd.Flags().StringP("output", "o", "text", "The output format, text or json")
+
+	return cmd
+}
+
+func printProgress(progress executor.Progress) {
+	fmt.Printf("Backup:     %s\n", progress.Backup)
+	fmt.Printf("Type:       %s\n", progress.Type)
+	fmt.Printf("Phase:      %s\n", progress.Phase)
+	if progress.StartedAt != nil {
+		fmt.Printf("Started:    %s\n", progress.StartedAt.Format(time.RFC3339))
+	}
+	if progress.StoppedAt != nil {
+		fmt.Printf("Stopped:    %s\n", progress.StoppedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("Progress:   %.1f%%\n", progress.Percent)
+	fmt.Printf("Estimated:  %s\n", formatBytes(progress.EstimatedBytes))
+	fmt.Printf("Produced:   %s\n", formatBytes(progress.ProducedBytes))
+	fmt.Printf("Uploaded:   %s of %s\n", formatBytes(progress.UploadedBytes), formatBytes(progress.UploadBytes))
+	fmt.Printf("Throughput: %s/s\n", formatBytes(int64(progress.Throughput)))
+	if len(progress.Error) > 0 {
+		fmt.Printf("Error:      %s\n", progress.Error)
+	}
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -3,11 +3,19 @@ package archiver
 import (
 	"archive/tar"
 	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// isCompressed is true when the archive name denotes a gzipped tarball
+func isCompressed(archive string) bool {
+	return strings.HasSuffix(archive, ".gz")
+}
+
 func CreateArchive(archive string, files []string) error {
 	buf, err := os.Create(archive)
 	if err != nil {
@@ -15,9 +23,13 @@ func CreateArchive(archive string, files []string) error {
 	}
 	defer buf.Close()
 
-	gw := gzip.NewWriter(buf)
-	defer gw.Close()
-	tw := tar.NewWriter(gw)
+	var out io.Writer = buf
+	if isCompressed(archive) {
+		gw := gzip.NewWriter(buf)
+		defer gw.Close()
+		out = gw
+	}
+	tw := tar.NewWriter(out)
 	defer tw.Close()
 
 	for _, file := range files {
@@ -41,9 +53,16 @@ func ExtractArchive(archive string, output string) error {
 	}
 	defer buf.Close()
 
-	gw, err := gzip.NewReader(buf)
-	defer gw.Close()
-	tw := tar.NewReader(gw)
+	var in io.Reader = buf
+	if isCompressed(archive) {
+		gw, err := gzip.NewReader(buf)
+		if err != nil {
+			return err
+		}
+		defer gw.Close()
+		in = gw
+	}
+	tw := tar.NewReader(in)
 
 	for {
 		header, err := tw.Next()
@@ -54,34 +73,94 @@ func ExtractArchive(archive string, output string) error {
 			return err
 		}
 
-		if header.Typeflag == tar.TypeReg {
-			folder := filepath.Join(output, filepath.Dir(header.Name))
-			if err := os.MkdirAll(folder, 0755); err != nil {
-				return err
-			}
+		// Archives can come from local files, whose entries
+		// must not be written outside of the output folder
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("invalid archive entry %q", header.Name)
+		}
 
-			data := make([]byte, header.Size)
-			_, err := tw.Read(data)
-			if err != nil && err != io.EOF {
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filepath.Join(output, header.Name), header.FileInfo().Mode().Perm()); err != nil {
 				return err
 			}
-
-			file := filepath.Join(output, header.Name)
-			fo, err := os.Create(file)
-			if err != nil {
+		case tar.TypeReg:
+			if err := extractFile(tw, header, output); err != nil {
 				return err
 			}
-			defer fo.Close()
+		}
+	}
 
-			if _, err := fo.Write(data); err != nil {
-				return err
-			}
+	return nil
+}
+
+// StreamFile writes the content of the single file stored in an archive
+// into the output, without writing it to disk
+func StreamFile(archive io.Reader, compressed bool, output io.Writer) error {
+	in := archive
+	if compressed {
+		gw, err := gzip.NewReader(archive)
+		if err != nil {
+			return err
+		}
+		defer gw.Close()
+		in = gw
+	}
+	tw := tar.NewReader(in)
+
+	found := false
+	for {
+		header, err := tw.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("the archive contains %q, which is not a regular file", header.Name)
+		}
+		if found {
+			return errors.New("the archive contains more than one file")
+		}
+		found = true
+
+		if _, err := io.Copy(output, tw); err != nil {
+			return err
 		}
 	}
 
+	if !found {
+		return errors.New("the archive is empty")
+	}
+
 	return nil
 }
 
+// extractFile writes the current archive entry into the output folder,
+// preserving its permissions. Data directories of physical backups are
+// large, so the content is streamed instead of being read in memory
+func extractFile(tw *tar.Reader, header *tar.Header, output string) error {
+	folder := filepath.Join(output, filepath.Dir(header.Name))
+	if err := os.MkdirAll(folder, 0o700); err != nil {
+		return err
+	}
+
+	file := filepath.Join(output, header.Name)
+	fo, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+
+	if _, err := io.Copy(fo, tw); err != nil {
+		return err
+	}
+
+	return fo.Close()
+}
+
 func addToArchive(tw *tar.Writer, base string, prefix string, filename string) error {
 	fullname := filepath.Join(base, prefix, filename)
 	info, err := os.Stat(fullname)
@@ -96,6 +175,17 @@ func addToArchive(tw *tar.Writer, base string, prefix string, filename string) e
 	defer file.Close()
 
 	if info.IsDir() {
+		// Empty directories are meaningful in a PostgreSQL data
+		// directory, so they are stored too
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.Join(prefix, filename) + "/"
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
 		files, err := file.Readdir(-1)
 		if err != nil {
 			return err
@@ -2,15 +2,31 @@ package backup
 
 import (
 	"context"
-	"github.com/cloudnative-pg/cnpg-i/pkg/backup"
-	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"fmt"
 	"os"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/pluginhelper"
+	"github.com/cloudnative-pg/cnpg-i/pkg/backup"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
 )
 
 // Server is the implementation of the identity service
 type Server struct {
 	backup.BackupServer
+
+	// Dependencies are used by every backup taken by the server
+	Dependencies executor.Dependencies
 }
 
 // GetCapabilities gets the capabilities of the Backup service
@@ -31,38 +47,373 @@ func (Server) GetCapabilities(
 	}, nil
 }
 
-// Backup take a physical backup using Kopia
-func (Server) Backup(
+// BackupTypeParam is the Backup parameter selecting the kind of backup
+const BackupTypeParam = "backupType"
+
+// Backup takes a backup of the cluster and stores it in S3
+func (server Server) Backup(
 	ctx context.Context,
 	request *backup.BackupRequest,
 ) (*backup.BackupResult, error) {
-	bucket := os.Getenv("AWS_BUCKET")
-	prefix := os.Getenv("BACKUP_PREFIX")
+	backupType, err := executor.ParseBackupType(request.Parameters[BackupTypeParam])
+	if err != nil {
+		return nil, err
+	}
+
+	helper, err := pluginhelper.NewDataBuilder(
+		metadata.PluginName,
+		request.ClusterDefinition,
+	).Build()
+	if err != nil {
+		return nil, err
+	}
+	cluster := helper.GetCluster()
 
-	return PerformBackup(ctx, bucket, prefix)
+	options, err := OptionsFromEnv(executor.LineageOwner{
+		Namespace: cluster.Namespace,
+		Name:      cluster.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	options.BackupType = backupType
+	options.Dependencies = server.Dependencies
+
+	return PerformBackup(ctx, options)
 }
 
-func PerformBackup(ctx context.Context, bucket string, prefix string) (*backup.BackupResult, error) {
-	rep, err := executor.NewRepository(
-		bucket,
-		prefix,
+// OptionsFromEnv builds the options of a backup of the passed cluster
+// from the environment of the sidecar. An empty prefix is the layout of
+// the clusters created before the prefix was defaulted, whose backups
+// are stored at the root of the bucket. The settings are validated by
+// the webhook, but the environment can be changed by hand, so invalid
+// values are refused rather than ignored
+func OptionsFromEnv(cluster executor.LineageOwner) (Options, error) {
+	lockTimeout, err := durationFromEnv("LOCK_TIMEOUT")
+	if err != nil {
+		return Options{}, err
+	}
+	hookTimeout, err := durationFromEnv("HOOK_TIMEOUT")
+	if err != nil {
+		return Options{}, err
+	}
+	replicaDestinations, err := config.ParseReplicaDestinations(os.Getenv("REPLICA_DESTINATIONS"))
+	if err != nil {
+		return Options{}, fmt.Errorf("invalid REPLICA_DESTINATIONS: %w", err)
+	}
+	objectTags, err := config.ParseObjectTags(os.Getenv("OBJECT_TAGS"))
+	if err != nil {
+		return Options{}, fmt.Errorf("invalid OBJECT_TAGS: %w", err)
+	}
+	throttling, err := ThrottlingFromEnv()
+	if err != nil {
+		return Options{}, err
+	}
+	scratchVolumeSize, err := sizeFromEnv("SCRATCH_VOLUME_SIZE")
+	if err != nil {
+		return Options{}, err
+	}
+
+	return Options{
+		Bucket:            os.Getenv("AWS_BUCKET"),
+		Endpoint:          os.Getenv("AWS_ENDPOINT_URL"),
+		Prefix:            os.Getenv("BACKUP_PREFIX"),
+		Compression:       os.Getenv("COMPRESSION"),
+		RetentionPolicy:   os.Getenv("RETENTION_POLICY"),
+		AllowSharedPrefix: os.Getenv("ALLOW_SHARED_PREFIX") == "true",
+		BackupType:        executor.BackupTypeLogical,
+		LockTimeout:       lockTimeout,
+		Hooks: executor.Hooks{
+			PreSQL:      os.Getenv("PRE_BACKUP_SQL"),
+			PostSQL:     os.Getenv("POST_BACKUP_SQL"),
+			PreCommand:  os.Getenv("PRE_BACKUP_COMMAND"),
+			PostCommand: os.Getenv("POST_BACKUP_COMMAND"),
+			Timeout:     hookTimeout,
+		},
+		Throttling: throttling,
+		DumpOptions: executor.DumpOptions{
+			NoRolePasswords:  os.Getenv("NO_ROLE_PASSWORDS") == "true",
+			SkipManagedRoles: os.Getenv("SKIP_MANAGED_ROLES") == "true",
+			Content:          os.Getenv("DUMP_CONTENT"),
+			PerDatabase:      os.Getenv("PER_DATABASE_DUMPS") == "true",
+		},
+		ObjectLock: ObjectLockOptions{
+			Mode:      os.Getenv("OBJECT_LOCK_MODE"),
+			Retention: os.Getenv("LOCK_RETENTION"),
+			LegalHold: os.Getenv("LEGAL_HOLD") == "true",
+		},
+		Tiering:             TieringFromEnv(),
+		TransitionAfter:     os.Getenv("TRANSITION_AFTER"),
+		ReplicaDestinations: replicaDestinations,
+		ObjectTags:          objectTags,
+		ScratchVolumeSize:   scratchVolumeSize,
+		Cluster:             cluster,
+	}, nil
+}
+
+// sizeFromEnv parses the quantity of bytes set in the passed environment
+// variable, zero when it is not set
+func sizeFromEnv(name string) (int64, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+
+	return quantity.Value(), nil
+}
+
+// durationFromEnv parses the duration set in the passed environment
+// variable, zero when it is not set
+func durationFromEnv(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+
+	return duration, nil
+}
+
+// TieringFromEnv reads the storage classes of the backup archives from
+// the environment of the sidecar
+func TieringFromEnv() executor.Tiering {
+	return executor.Tiering{
+		StorageClass:           types.StorageClass(os.Getenv("STORAGE_CLASS")),
+		TransitionStorageClass: types.StorageClass(os.Getenv("TRANSITION_STORAGE_CLASS")),
+		RestoreTier:            types.Tier(os.Getenv("ARCHIVE_RESTORE_TIER")),
+	}
+}
+
+// ObjectLockOptions are the settings of the object lock of the backups
+type ObjectLockOptions struct {
+	// Mode is the retention mode, governance or compliance,
+	// empty when the objects are not retained
+	Mode string
+
+	// Retention is how long the objects are retained, such as "30d"
+	Retention string
+
+	// LegalHold uploads the objects under legal hold
+	LegalHold bool
+}
+
+// objectLock computes the lock of the objects of a backup taken now
+func (options ObjectLockOptions) objectLock(now time.Time) (executor.ObjectLock, error) {
+	lock := executor.ObjectLock{LegalHold: options.LegalHold}
+	if len(options.Mode) == 0 {
+		return lock, nil
+	}
+
+	retainUntil, err := config.RetainUntil(options.Retention, now)
+	if err != nil {
+		return lock, err
+	}
+	lock.RetainUntil = retainUntil
+
+	switch options.Mode {
+	case config.ObjectLockModeGovernance:
+		lock.Mode = types.ObjectLockModeGovernance
+	case config.ObjectLockModeCompliance:
+		lock.Mode = types.ObjectLockModeCompliance
+	default:
+		return lock, fmt.Errorf("invalid object lock mode %q", options.Mode)
+	}
+
+	return lock, nil
+}
+
+// ThrottlingFromEnv reads the limits of the backups and restores from
+// the environment of the sidecar
+func ThrottlingFromEnv() (executor.Throttling, error) {
+	maxUploadBandwidth, err := config.ParseRate(os.Getenv("MAX_UPLOAD_BANDWIDTH"))
+	if err != nil {
+		return executor.Throttling{}, fmt.Errorf("invalid MAX_UPLOAD_BANDWIDTH: %w", err)
+	}
+	maxDumpRate, err := config.ParseRate(os.Getenv("MAX_DUMP_RATE"))
+	if err != nil {
+		return executor.Throttling{}, fmt.Errorf("invalid MAX_DUMP_RATE: %w", err)
+	}
+
+	return executor.Throttling{
+		MaxUploadBandwidth: maxUploadBandwidth,
+		MaxDumpRate:        maxDumpRate,
+		LowPriorityDump:    os.Getenv("LOW_PRIORITY_DUMP") == "true",
+	}, nil
+}
+
+// Options are the settings of a backup
+type Options struct {
+	Bucket            string
+	Endpoint          string
+	Prefix            string
+	Compression       string
+	RetentionPolicy   string
+	AllowSharedPrefix bool
+	BackupType        executor.BackupType
+
+	// LockTimeout is how long to wait for a running backup
+	// of the same cluster to complete
+	LockTimeout time.Duration
+
+	// Hooks are run around the snapshot
+	Hooks executor.Hooks
+
+	// Throttling limits the resources taken by the backup
+	Throttling executor.Throttling
+
+	// DumpOptions select what a logical backup contains
+	DumpOptions executor.DumpOptions
+
+	// ObjectLock makes the objects of the backup immutable
+	ObjectLock ObjectLockOptions
+
+	// Tiering decides the storage classes of the archives
+	Tiering executor.Tiering
+
+	// TransitionAfter is the age after which archives are moved to
+	// the transition storage class, such as "30d"
+	TransitionAfter string
+
+	// ReplicaDestinations are the buckets the backups are copied to
+	ReplicaDestinations []config.ReplicaDestination
+
+	// ObjectTags are added to the objects of the backup
+	ObjectTags map[string]string
+
+	// ScratchVolumeSize is the size limit of the scratch volume
+	// in bytes, zero when it has none
+	ScratchVolumeSize int64
+
+	// Dependencies replace the clients of the object storage and
+	// of the instance manager, by default the ones of the sidecar
+	Dependencies executor.Dependencies
+
+	// Cluster is the cluster being backed up
+	Cluster executor.LineageOwner
+}
+
+// PerformBackup takes a backup and stores it in the bucket, then
+// removes the backups which are expired according to the retention policy
+func PerformBackup(ctx context.Context, options Options) (*backup.BackupResult, error) {
+	contextLogger := logging.FromContext(ctx)
+
+	rep, err := executor.NewRepositoryWithDependencies(
+		options.Bucket,
+		options.Prefix,
+		options.Compression,
+		options.Dependencies,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	exec := executor.NewS3Executor(
+	rep.SetThrottling(options.Throttling)
+	rep.SetDumpOptions(options.DumpOptions)
+	rep.SetTiering(options.Tiering)
+	rep.SetObjectTags(options.ObjectTags)
+	rep.SetScratchLimit(options.ScratchVolumeSize)
+	for _, destination := range options.ReplicaDestinations {
+		replica, err := newReplicaRepository(ctx, destination, options.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("while connecting to replica %s: %w", destination.Name(), err)
+		}
+		rep.AddReplica(destination.Name(), replica, destination.Endpoint == options.Endpoint)
+	}
+
+	objectLock, err := options.ObjectLock.objectLock(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rep.SetObjectLock(objectLock)
+	if err := rep.CheckObjectLock(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := rep.ClaimLineage(ctx, options.Cluster, options.AllowSharedPrefix); err != nil {
+		return nil, err
+	}
+
+	exec := executor.NewExecutor(
 		rep,
+		options.BackupType,
+		options.Hooks,
+		options.Dependencies.Instance,
 	)
 
+	lock, lockCtx, err := rep.AcquireLock(ctx, exec.GetBackupName(), options.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+			contextLogger.Error(err, "while releasing the backup lock")
+		}
+	}()
+
 	startedAt := time.Now()
-	backupInfo, err := exec.Backup(ctx)
+	backupInfo, err := exec.Backup(lockCtx)
 	if err != nil {
+		if cause := context.Cause(lockCtx); cause != nil && ctx.Err() == nil {
+			err = fmt.Errorf("%w: %w", err, cause)
+		}
 		return nil, err
 	}
 
+	if len(options.RetentionPolicy) > 0 {
+		cutoff, err := config.RetentionCutoff(options.RetentionPolicy, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		// The backup has already been taken, a failure while
+		// pruning old backups will be retried by the next one
+		result, err := rep.Prune(ctx, cutoff)
+		if err != nil {
+			contextLogger.Error(err, "while removing expired backups", "removed", result.Removed)
+		} else {
+			contextLogger.Info("Applied retention policy", "policy", options.RetentionPolicy,
+				"removed", result.Removed, "locked", result.Locked)
+		}
+	}
+
+	// Replicas which could not be updated are reported in the catalog,
+	// and will catch up with the next backup
+	if status, err := rep.Replicate(ctx); err != nil {
+		contextLogger.Error(err, "while replicating backups")
+	} else if status != nil {
+		for _, replica := range status.Replicas {
+			contextLogger.Info("Replicated backups", "replica", replica.Name,
+				"replicated", replica.Replicated, "lagSeconds", replica.LagSeconds,
+				"mismatched", replica.Mismatched)
+		}
+	}
+
+	if len(options.TransitionAfter) > 0 {
+		cutoff, err := config.RetentionCutoff(options.TransitionAfter, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		// Like pruning, a failed transition is retried by the next backup
+		if moved, err := rep.Transition(ctx, cutoff); err != nil {
+			contextLogger.Error(err, "while moving backups to a colder storage class", "moved", moved)
+		} else if len(moved) > 0 {
+			contextLogger.Info("Moved backups to a colder storage class",
+				"storageClass", options.Tiering.TransitionStorageClass, "moved", moved)
+		}
+	}
+
 	return &backup.BackupResult{
-		BackupId:          backupInfo.BackupName,
+		BackupId:          exec.GetMetadata().Name,
 		BackupName:        backupInfo.BackupName,
 		StartedAt:         startedAt.Unix(),
 		StoppedAt:         time.Now().Unix(),
@@ -75,3 +426,44 @@ func PerformBackup(ctx context.Context, bucket string, prefix string) (*backup.B
 		Online:            true,
 	}, nil
 }
+
+// newReplicaRepository connects to a replica bucket. Settings missing
+// from the destination, such as the region and the credentials, are
+// taken from the environment like for the repository, but the endpoint
+// is never inherited
+func newReplicaRepository(
+	ctx context.Context,
+	destination config.ReplicaDestination,
+	prefix string,
+) (*executor.Repository, error) {
+	var loadOptions []func(*awsconfig.LoadOptions) error
+	if len(destination.Region) > 0 {
+		loadOptions = append(loadOptions, awsconfig.WithRegion(destination.Region))
+	}
+	if len(destination.AccessKey) > 0 {
+		loadOptions = append(loadOptions, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(destination.AccessKey, destination.SecretKey, "")))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return nil, err
+	}
+	cfg.BaseEndpoint = nil
+	if len(destination.Endpoint) > 0 {
+		cfg.BaseEndpoint = aws.String(destination.Endpoint)
+	}
+
+	if len(destination.Prefix) > 0 {
+		prefix = destination.Prefix
+	}
+
+	return executor.NewRepositoryWithDependencies(destination.Bucket, prefix, "", executor.Dependencies{
+		AWSConfig: &cfg,
+		S3Options: []func(*s3.Options){
+			func(o *s3.Options) {
+				o.UsePathStyle = destination.ForcePathStyle
+			},
+		},
+	})
+}
@@ -0,0 +1,200 @@
+package backup_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/harness"
+)
+
+func newHarness(t *testing.T) *harness.Harness {
+	t.Helper()
+
+	h, err := harness.New("backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+func newRepository(t *testing.T, h *harness.Harness) *executor.Repository {
+	t.Helper()
+
+	rep, err := h.Repository("default", "cluster-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+func TestBackupListRestore(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+
+	if err := h.SetDump("CREATE TABLE orders (id integer);\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := h.Backup(ctx, h.Options("default", "cluster-example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Instance.InBackupMode() {
+		t.Error("PostgreSQL has been left in backup mode")
+	}
+
+	rep := newRepository(t, h)
+	backups, err := rep.ListBackups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != name {
+		t.Fatalf("listed %+v, want backup %s", backups, name)
+	}
+	if backups[0].ClusterName != "cluster-example" || backups[0].Namespace != "default" {
+		t.Errorf("backup of %s/%s, want default/cluster-example", backups[0].Namespace, backups[0].ClusterName)
+	}
+	if backups[0].Type != executor.BackupTypeLogical || len(backups[0].EndWal) == 0 {
+		t.Errorf("unexpected metadata %+v", backups[0])
+	}
+
+	if _, err := rep.Restore(ctx, name, ""); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := h.Replayed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(replayed, "CREATE TABLE orders") {
+		t.Errorf("the dump has not been replayed: %q", replayed)
+	}
+}
+
+func TestBackupLockContention(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+
+	lock, _, err := newRepository(t, h).AcquireLock(ctx, "running-backup", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := h.Options("default", "cluster-example")
+	if _, err := h.Backup(ctx, options); !errors.Is(err, executor.ErrBackupInProgress) {
+		t.Fatalf("backup while another one holds the lock: got %v, want %v", err, executor.ErrBackupInProgress)
+	}
+	if h.Instance.Starts != 0 {
+		t.Errorf("%d backups have been started while the lock was held", h.Instance.Starts)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Backup(ctx, options); err != nil {
+		t.Fatalf("backup after the lock has been released: %v", err)
+	}
+}
+
+func TestBackupRetention(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+	rep := newRepository(t, h)
+	options := h.Options("default", "cluster-example")
+
+	// The backups taken before the retention policy is set
+	// are made older than the policy
+	var expired []string
+	for range 2 {
+		name, err := h.Backup(ctx, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metadata, err := rep.ReadMetadata(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata.StoppedAt = time.Now().AddDate(0, 0, -10)
+		if err := rep.WriteMetadata(ctx, metadata); err != nil {
+			t.Fatal(err)
+		}
+		expired = append(expired, name)
+	}
+
+	options.RetentionPolicy = "7d"
+	kept, err := h.Backup(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := rep.ListBackups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != kept {
+		t.Fatalf("listed %+v, want only backup %s", backups, kept)
+	}
+	for _, key := range h.S3.Keys(h.Bucket) {
+		for _, name := range expired {
+			if strings.Contains(key, name) {
+				t.Errorf("object %s of expired backup %s has not been removed", key, name)
+			}
+		}
+	}
+}
+
+func TestBackupReplication(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+	h.S3.CreateBucket("replica")
+
+	options := h.Options("default", "cluster-example")
+	options.ReplicaDestinations = []config.ReplicaDestination{
+		{
+			Bucket:         "replica",
+			Endpoint:       h.S3.URL(),
+			Region:         "us-east-1",
+			AccessKey:      "harness",
+			SecretKey:      "harness",
+			ForcePathStyle: true,
+		},
+	}
+
+	name, err := h.Backup(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica, err := executor.NewRepositoryWithDependencies("replica", options.Prefix, "gzip", h.Dependencies())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, err := replica.ListBackups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != name {
+		t.Fatalf("replica lists %+v, want backup %s", backups, name)
+	}
+
+	source := h.S3.Get(h.Bucket, backups[0].Archive)
+	copied := h.S3.Get("replica", backups[0].Archive)
+	if source == nil || copied == nil || string(source.Data) != string(copied.Data) {
+		t.Error("the archive of the replica differs from the one of the repository")
+	}
+
+	status, err := newRepository(t, h).ReadReplicationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || len(status.Replicas) != 1 || status.Replicas[0].LastBackup != name {
+		t.Errorf("unexpected replication status %+v", status)
+	}
+}
@@ -0,0 +1,664 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// globalsFile is the pg_dumpall script holding the roles and the
+	// tablespaces of a per-database backup
+	globalsFile = "globals.sql"
+
+	// databaseDumpSuffix is the extension of the custom format
+	// dumps of the databases of a per-database backup
+	databaseDumpSuffix = ".dump"
+
+	// databasesQuery lists the databases dumped by a per-database
+	// backup, the same ones pg_dumpall would dump
+	databasesQuery = "SELECT datname FROM pg_database " +
+		"WHERE datallowconn AND NOT datistemplate ORDER BY datname"
+)
+
+// ErrNotPerDatabase is raised when restoring a single database, schema
+// or table of a backup which has not been taken with per-database dumps
+var ErrNotPerDatabase = errors.New("restoring a database, a schema or a table needs a backup " +
+	"taken with per-database dumps")
+
+// tocDescriptions are the kinds of entries of a pg_restore list made of
+// more than one word, longest first, so that the schema and the name
+// following them can be told apart
+var tocDescriptions = []string{
+	"PUBLICATION TABLES IN SCHEMA",
+	"TEXT SEARCH CONFIGURATION",
+	"TEXT SEARCH DICTIONARY",
+	"MATERIALIZED VIEW DATA",
+	"TEXT SEARCH TEMPLATE",
+	"FOREIGN DATA WRAPPER",
+	"TEXT SEARCH PARSER",
+	"DATABASE PROPERTIES",
+	"PROCEDURAL LANGUAGE",
+	"MATERIALIZED VIEW",
+	"PUBLICATION TABLE",
+	"SEQUENCE OWNED BY",
+	"CHECK CONSTRAINT",
+	"OPERATOR FAMILY",
+	"STATISTICS DATA",
+	"OPERATOR CLASS",
+	"SECURITY LABEL",
+	"ACCESS METHOD",
+	"EVENT TRIGGER",
+	"FK CONSTRAINT",
+	"FOREIGN TABLE",
+	"INDEX ATTACH",
+	"LARGE OBJECT",
+	"ROW SECURITY",
+	"SEQUENCE SET",
+	"TABLE ATTACH",
+	"USER MAPPING",
+	"DEFAULT ACL",
+	"SHELL TYPE",
+	"TABLE DATA",
+}
+
+// Selection restricts a restore to a single database, optionally to one
+// of its schemas or tables. The zero value restores everything
+type Selection struct {
+	// Database is the database to restore
+	Database string
+
+	// Schema restricts the restore to the objects of a schema
+	Schema string
+
+	// Table restricts the restore to a table, with its data, indexes,
+	// constraints, triggers, policies, owned sequences, comments and
+	// privileges, and its schema when the target database lacks it. The
+	// schema of the table is looked up when not set, and must be set
+	// when tables of different schemas share the name
+	Table string
+
+	// RenameTo restores into another database, created when missing,
+	// so that the backup can be inspected beside the original database
+	RenameTo string
+}
+
+// IsEmpty is true when the whole backup is restored
+func (selection Selection) IsEmpty() bool {
+	return selection == Selection{}
+}
+
+// validate checks the selection is consistent
+func (selection Selection) validate() error {
+	if len(selection.Database) == 0 && !selection.IsEmpty() {
+		return errors.New("the database is required to restore a schema, a table or into another database")
+	}
+
+	return nil
+}
+
+// databaseDumpFile is the name of the dump of a database inside a
+// per-database backup. Database names can hold any character, while
+// the file name must stay inside the backup folder
+func databaseDumpFile(database string) string {
+	return url.PathEscape(database) + databaseDumpSuffix
+}
+
+// listDatabases returns the databases dumped by a per-database backup
+func (env Environment) listDatabases(ctx context.Context) ([]string, error) {
+	output, err := env.executeQuery(ctx, databasesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	var databases []string
+	for _, line := range strings.Split(output, "\n") {
+		if len(line) > 0 {
+			databases = append(databases, line)
+		}
+	}
+
+	return databases, nil
+}
+
+// executePerDatabaseBackup dumps the global objects with pg_dumpall and
+// every database with pg_dump, in the custom format, into a folder named
+// after the backup. Returns the names of the databases
+func (env Environment) executePerDatabaseBackup(
+	ctx context.Context,
+	name string,
+	throttling Throttling,
+	dumpOptions DumpOptions,
+) ([]string, error) {
+	logger := logging.FromContext(ctx)
+
+	folder := env.path(name)
+	if err := os.MkdirAll(folder, 0o750); err != nil {
+		return nil, err
+	}
+
+	databases, err := env.listDatabases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while listing the databases: %w", err)
+	}
+
+	// The limiter is shared, so that the rate applies to the whole backup
+	limiter := newLimiter(throttling.MaxDumpRate)
+	globalsArgs := append([]string{
+		"-h",
+		"/controller/run",
+		"--globals-only",
+	}, dumpOptions.globalsArgs()...)
+	if err := dumpInto(ctx, filepath.Join(folder, globalsFile), limiter, dumpOptions.SkipManagedRoles,
+		throttling, env.binary(PGDumpall), globalsArgs...); err != nil {
+		return nil, err
+	}
+
+	for _, database := range databases {
+		logger.Info("Dumping database", "database", database)
+		args := append([]string{
+			"-h",
+			"/controller/run",
+			"-d",
+			database,
+			"-Fc",
+		}, dumpOptions.contentArgs()...)
+		if err := dumpInto(ctx, filepath.Join(folder, databaseDumpFile(database)), limiter, false,
+			throttling, env.binary(PGDump), args...); err != nil {
+			return nil, fmt.Errorf("while dumping %s: %w", database, err)
+		}
+	}
+
+	return databases, nil
+}
+
+// dumpInto streams the output of a dump command into a file through the
+// rate limiter and, when requested, the role filter
+func dumpInto(
+	ctx context.Context,
+	fileName string,
+	limiter *rate.Limiter,
+	skipManagedRoles bool,
+	throttling Throttling,
+	command string,
+	args ...string,
+) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	output := newThrottledWriter(ctx, f, limiter)
+	var filter *globalsFilter
+	if skipManagedRoles {
+		filter = newGlobalsFilter(output)
+		output = filter
+	}
+
+	command, args = dumpCommand(throttling, command, args...)
+	if err := executeCommandWithIO(ctx, nil, output, command, args...); err != nil {
+		return err
+	}
+
+	if filter != nil {
+		if err := filter.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// restoreDatabases restores an extracted per-database backup. The whole
+// backup replays the global objects and then every database, while a
+// selection only restores the selected database, schema or table
+func (env Environment) restoreDatabases(ctx context.Context, report *RestoreReport, folder string, options RestoreOptions) error {
+	logger := logging.FromContext(ctx)
+
+	if !options.Selection.IsEmpty() {
+		return env.restoreDatabase(ctx, report, folder, options.Selection, options)
+	}
+
+	databases, err := readDatabaseDumps(folder)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Restoring global objects")
+	if err := env.executeRestore(ctx, report, filepath.Join(folder, globalsFile), options); err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		logger.Info("Restoring database", "database", database)
+		if err := env.restoreDatabase(ctx, report, folder, Selection{Database: database}, options); err != nil {
+			return fmt.Errorf("while restoring %s: %w", database, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreDatabase restores the selected objects of a database of an
+// extracted per-database backup
+func (env Environment) restoreDatabase(
+	ctx context.Context,
+	report *RestoreReport,
+	folder string,
+	selection Selection,
+	options RestoreOptions,
+) error {
+	dump := filepath.Join(folder, databaseDumpFile(selection.Database))
+	if _, err := os.Stat(dump); errors.Is(err, fs.ErrNotExist) {
+		databases, _ := readDatabaseDumps(folder)
+		return fmt.Errorf("database %s is not in the backup, which contains: %s",
+			selection.Database, strings.Join(databases, ", "))
+	} else if err != nil {
+		return err
+	}
+
+	target := selection.Database
+	if len(selection.RenameTo) > 0 {
+		target = selection.RenameTo
+	}
+
+	return env.restoreDump(ctx, report, dump, target, selection, options)
+}
+
+// restoreDirectoryDump restores a dump of a single database in the
+// directory format. It has no database name, so the target is either the
+// selected database or the one of the connection
+func (env Environment) restoreDirectoryDump(ctx context.Context, report *RestoreReport, dump string, options RestoreOptions) error {
+	selection := options.Selection
+	target := selection.RenameTo
+	if len(target) == 0 {
+		target = selection.Database
+	}
+	if len(target) == 0 {
+		target = options.Connection.DBName
+	}
+	if len(target) == 0 {
+		return errors.New("the database to restore a directory format dump into is required")
+	}
+
+	return env.restoreDump(ctx, report, dump, target, selection, options)
+}
+
+// restoreDump restores the selected objects of a dump, in the custom or
+// the directory format, into the target database, created when missing
+func (env Environment) restoreDump(
+	ctx context.Context,
+	report *RestoreReport,
+	dump string,
+	target string,
+	selection Selection,
+	options RestoreOptions,
+) error {
+	if err := env.ensureDatabase(ctx, options.Connection, target); err != nil {
+		return err
+	}
+
+	args := options.pgRestoreArgs(target)
+	if len(selection.Schema) > 0 || len(selection.Table) > 0 {
+		list, err := env.restoreList(ctx, dump, options.Connection.withDatabase(target), selection)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.Remove(list)
+		}()
+		args = append(args, "-L", list)
+	}
+
+	return runRestoreCommand(ctx, report, filepath.Base(dump), nil, options.ContinueOnError,
+		env.binary(PGRestore), append(args, dump)...)
+}
+
+// readDatabaseDumps lists the databases of an extracted per-database backup
+func readDatabaseDumps(folder string) ([]string, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var databases []string
+	for _, entry := range entries {
+		escaped, found := strings.CutSuffix(entry.Name(), databaseDumpSuffix)
+		if !found {
+			continue
+		}
+		if isDump, err := isDatabaseDump(folder, entry); err != nil {
+			return nil, err
+		} else if !isDump {
+			continue
+		}
+
+		database, err := url.PathUnescape(escaped)
+		if err != nil {
+			return nil, fmt.Errorf("invalid database dump %s: %w", entry.Name(), err)
+		}
+		databases = append(databases, database)
+	}
+	sort.Strings(databases)
+
+	return databases, nil
+}
+
+// ensureDatabase creates the passed database when it doesn't exist
+func (env Environment) ensureDatabase(ctx context.Context, connection Connection, database string) error {
+	maintenance := connection.withDatabase("postgres")
+	output, err := env.query(ctx, maintenance,
+		fmt.Sprintf("SELECT count(*) FROM pg_database WHERE datname = %s", quoteLiteral(database)))
+	if err != nil {
+		return err
+	}
+	if output != "0" {
+		return nil
+	}
+
+	logging.FromContext(ctx).Info("Creating database", "database", database)
+	_, err = env.query(ctx, maintenance, "CREATE DATABASE "+quoteIdentifier(database))
+	return err
+}
+
+// restoreList writes the list of the entries of a dump matching the
+// selection, in the format expected by pg_restore -L. The schema is
+// left out when it already exists in the target database
+func (env Environment) restoreList(ctx context.Context, dump string, target Connection, selection Selection) (string, error) {
+	var output bytes.Buffer
+	if err := executeCommandWithIO(ctx, nil, &output, env.binary(PGRestore), "-l", "-v", dump); err != nil {
+		return "", err
+	}
+	toc, err := parseToc(&output)
+	if err != nil {
+		return "", err
+	}
+
+	if len(selection.Table) > 0 && len(selection.Schema) == 0 {
+		if selection.Schema, err = toc.tableSchema(selection.Database, selection.Table); err != nil {
+			return "", err
+		}
+	}
+
+	exists, err := env.schemaExists(ctx, target, selection.Schema)
+	if err != nil {
+		return "", err
+	}
+
+	var selected []string
+	for _, entry := range toc.selectEntries(selection) {
+		if entry.description == "SCHEMA" && exists {
+			continue
+		}
+		selected = append(selected, entry.line)
+	}
+	if len(selected) == 0 {
+		return "", fmt.Errorf("nothing in database %s matches %s", selection.Database, selection.describe())
+	}
+
+	list, err := os.CreateTemp(env.workingDir(), "restore-*.list")
+	if err != nil {
+		return "", err
+	}
+	defer list.Close()
+
+	if _, err := list.WriteString(strings.Join(selected, "\n") + "\n"); err != nil {
+		return "", err
+	}
+
+	return list.Name(), list.Close()
+}
+
+// schemaExists is true when the target database has the passed schema
+func (env Environment) schemaExists(ctx context.Context, target Connection, schema string) (bool, error) {
+	output, err := env.query(ctx, target,
+		fmt.Sprintf("SELECT count(*) FROM pg_namespace WHERE nspname = %s", quoteLiteral(schema)))
+	if err != nil {
+		return false, fmt.Errorf("while looking for schema %s: %w", schema, err)
+	}
+
+	return output != "0", nil
+}
+
+// describe is the schema and the table of a selection, for error messages
+func (selection Selection) describe() string {
+	var parts []string
+	if len(selection.Schema) > 0 {
+		parts = append(parts, "schema "+selection.Schema)
+	}
+	if len(selection.Table) > 0 {
+		parts = append(parts, "table "+selection.Table)
+	}
+
+	return strings.Join(parts, " and ")
+}
+
+// tocEntry is an entry of the list printed by pg_restore -l, such as
+// "215; 1259 16386 TABLE public orders app"
+type tocEntry struct {
+	id          string
+	description string
+	schema      string
+	name        string
+
+	// dependencies are the ids of the entries this one depends on,
+	// only listed by pg_restore -l -v
+	dependencies []string
+
+	// line is the entry as printed by pg_restore
+	line string
+}
+
+// tableOfContents are the entries of a dump, in their order
+type tableOfContents []tocEntry
+
+// tocDependencies starts the comment following an entry of the verbose
+// list of pg_restore, which holds the ids of its dependencies
+const tocDependencies = ";\tdepends on:"
+
+// parseToc parses the list printed by pg_restore -l -v
+func parseToc(reader io.Reader) (tableOfContents, error) {
+	var toc tableOfContents
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if dependencies, found := strings.CutPrefix(line, tocDependencies); found {
+			if len(toc) > 0 {
+				toc[len(toc)-1].dependencies = strings.Fields(dependencies)
+			}
+			continue
+		}
+
+		if entry, ok := parseTocEntry(line); ok {
+			toc = append(toc, entry)
+		}
+	}
+
+	return toc, scanner.Err()
+}
+
+// tableSchema finds the schema of a table when a single schema has a
+// table with that name
+func (toc tableOfContents) tableSchema(database string, table string) (string, error) {
+	var schemas []string
+	for _, entry := range toc {
+		if entry.description == "TABLE" && entry.name == table {
+			schemas = append(schemas, entry.schema)
+		}
+	}
+
+	switch len(schemas) {
+	case 0:
+		return "", fmt.Errorf("nothing in database %s matches table %s", database, table)
+	case 1:
+		return schemas[0], nil
+	default:
+		return "", fmt.Errorf("table %s of database %s is in schemas %s, the schema is required",
+			table, database, strings.Join(schemas, ", "))
+	}
+}
+
+// tableDependents are the kinds of entries restored with a table when
+// they depend on it, or on one of its dependents, like its indexes, the
+// defaults of its columns and its owned sequences. Foreign keys are
+// left out, since other tables reference it through them
+var tableDependents = []string{
+	"ACL",
+	"CHECK CONSTRAINT",
+	"COMMENT",
+	"CONSTRAINT",
+	"DEFAULT",
+	"INDEX",
+	"INDEX ATTACH",
+	"POLICY",
+	"ROW SECURITY",
+	"RULE",
+	"SECURITY LABEL",
+	"SEQUENCE OWNED BY",
+	"SEQUENCE SET",
+	"STATISTICS",
+	"STATISTICS DATA",
+	"TRIGGER",
+}
+
+// selectEntries returns the entries matching the selection. The entries
+// of a table are completed with the ones depending on them, and with the
+// sequences its columns take their defaults from
+func (toc tableOfContents) selectEntries(selection Selection) []tocEntry {
+	selected := make(map[string]bool, len(toc))
+	for _, entry := range toc {
+		if entry.matches(selection) {
+			selected[entry.id] = true
+		}
+	}
+
+	if len(selection.Table) > 0 {
+		// Every object depends on its schema, which doesn't
+		// make it a dependent of the table
+		objects := make(map[string]bool, len(selected))
+		kinds := make(map[string]string, len(toc))
+		for _, entry := range toc {
+			kinds[entry.id] = entry.description
+			if selected[entry.id] && entry.description != "SCHEMA" {
+				objects[entry.id] = true
+			}
+		}
+
+		for changed := true; changed; {
+			changed = false
+			for _, entry := range toc {
+				if objects[entry.id] || entry.schema != selection.Schema {
+					continue
+				}
+				if slices.Contains(tableDependents, entry.description) &&
+					slices.ContainsFunc(entry.dependencies, func(id string) bool { return objects[id] }) {
+					objects[entry.id] = true
+					changed = true
+				}
+			}
+
+			// Serial columns take their default from a sequence
+			// which doesn't depend on the table
+			for _, entry := range toc {
+				if !objects[entry.id] || (entry.description != "DEFAULT" && entry.description != "SEQUENCE OWNED BY") {
+					continue
+				}
+				for _, id := range entry.dependencies {
+					if kinds[id] == "SEQUENCE" && !objects[id] {
+						objects[id] = true
+						changed = true
+					}
+				}
+			}
+		}
+		maps.Copy(selected, objects)
+	}
+
+	var result []tocEntry
+	for _, entry := range toc {
+		if selected[entry.id] {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// parseTocEntry parses a line of a pg_restore list, returning false for
+// the comments and the lines which are not entries
+func parseTocEntry(line string) (tocEntry, bool) {
+	if strings.HasPrefix(line, ";") {
+		return tocEntry{}, false
+	}
+
+	id, rest, found := strings.Cut(line, "; ")
+	if !found {
+		return tocEntry{}, false
+	}
+
+	// The table and object identifiers come first
+	fields := strings.Fields(rest)
+	if len(fields) < 3 {
+		return tocEntry{}, false
+	}
+	rest = strings.Join(fields[2:], " ")
+
+	entry := tocEntry{id: strings.TrimSpace(id), line: line}
+	for _, description := range tocDescriptions {
+		if strings.HasPrefix(rest, description+" ") {
+			entry.description = description
+			break
+		}
+	}
+	if len(entry.description) == 0 {
+		entry.description, _, _ = strings.Cut(rest, " ")
+	}
+
+	// The schema follows the description, the owner ends the line
+	// and whatever is between them is the name
+	fields = strings.Fields(strings.TrimPrefix(rest, entry.description))
+	if len(fields) < 3 {
+		return tocEntry{}, false
+	}
+	entry.schema = fields[0]
+	entry.name = strings.Join(fields[1:len(fields)-1], " ")
+
+	return entry, true
+}
+
+// matches is true when the entry belongs to the selected schema or table,
+// or is the schema of the selected table. The entries of a table, like
+// its constraints and triggers, are named after the table followed by
+// their own name, while comments and privileges are named after the kind
+// of object followed by its name
+func (entry tocEntry) matches(selection Selection) bool {
+	if entry.description == "SCHEMA" {
+		return entry.name == selection.Schema
+	}
+	if entry.schema != selection.Schema {
+		return false
+	}
+	if len(selection.Table) == 0 {
+		return true
+	}
+
+	return entry.name == selection.Table ||
+		strings.HasPrefix(entry.name, selection.Table+" ") ||
+		(slices.Contains([]string{"COMMENT", "ACL", "SECURITY LABEL"}, entry.description) &&
+			strings.HasSuffix(entry.name, " "+selection.Table))
+}
@@ -0,0 +1,189 @@
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestRestoreTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection executor.Selection
+
+		// schemas are the schemas of the database restored into,
+		// toc replaces the list of the dump when set
+		schemas []string
+		toc     string
+
+		restored []string
+		skipped  []string
+		wantErr  string
+	}{
+		{
+			name:      "table of a missing schema",
+			selection: executor.Selection{Database: "app", Schema: "sales", Table: "invoices"},
+			schemas:   []string{"public"},
+			restored: []string{
+				"SCHEMA - sales",
+				"TABLE sales invoices",
+				"TABLE DATA sales invoices",
+				"FK CONSTRAINT sales invoices invoices_order_fkey",
+			},
+			skipped: []string{"SCHEMA - public", "public orders"},
+		},
+		{
+			name:      "table of an existing schema",
+			selection: executor.Selection{Database: "app", Schema: "sales", Table: "invoices"},
+			schemas:   []string{"public", "sales"},
+			restored:  []string{"TABLE sales invoices", "TABLE DATA sales invoices"},
+			skipped:   []string{"SCHEMA - sales", "SCHEMA - public", "public orders"},
+		},
+		{
+			name:      "table with a serial column and an index",
+			selection: executor.Selection{Database: "app", Schema: "public", Table: "orders"},
+			schemas:   []string{"public"},
+			restored: []string{
+				"SEQUENCE public orders_id_seq",
+				"TABLE public orders",
+				"SEQUENCE OWNED BY public orders_id_seq",
+				"DEFAULT public orders id",
+				"TABLE DATA public orders",
+				"SEQUENCE SET public orders_id_seq",
+				"CONSTRAINT public orders orders_pkey",
+				"INDEX public orders_created_idx",
+				"COMMENT public TABLE orders",
+			},
+			skipped: []string{"SCHEMA - public", "SCHEMA - sales", "sales invoices"},
+		},
+		{
+			name:      "table without its schema",
+			selection: executor.Selection{Database: "app", Table: "invoices"},
+			schemas:   []string{"public"},
+			restored:  []string{"SCHEMA - sales", "TABLE sales invoices"},
+			skipped:   []string{"public orders"},
+		},
+		{
+			name:      "table of the same name in two schemas",
+			selection: executor.Selection{Database: "app", Table: "orders"},
+			schemas:   []string{"public"},
+			toc: `215; 1259 16386 TABLE public orders app
+216; 1259 16391 TABLE sales orders app
+`,
+			wantErr: "table orders of database app is in schemas public, sales",
+		},
+		{
+			name:      "missing table",
+			selection: executor.Selection{Database: "app", Table: "customers"},
+			schemas:   []string{"public"},
+			wantErr:   "nothing in database app matches table customers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+			if err := h.SetSchemas(tt.schemas...); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.toc) > 0 {
+				if err := h.SetTableOfContents(tt.toc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := h.SetDatabases("app"); err != nil {
+				t.Fatal(err)
+			}
+
+			options := h.Options("default", "cluster-example")
+			options.DumpOptions.PerDatabase = true
+			name, err := h.Backup(ctx, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rep.SetRestoreOptions(executor.RestoreOptions{Selection: tt.selection})
+
+			_, err = rep.Restore(ctx, name, "")
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := h.Restored()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range tt.restored {
+				if !strings.Contains(restored, entry) {
+					t.Errorf("%q has not been restored:\n%s", entry, restored)
+				}
+			}
+			for _, entry := range tt.skipped {
+				if strings.Contains(restored, entry) {
+					t.Errorf("%q has been restored:\n%s", entry, restored)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreSingleTransaction(t *testing.T) {
+	tests := []struct {
+		name        string
+		perDatabase bool
+		wantErr     error
+	}{
+		{name: "pg_dumpall script", wantErr: executor.ErrSingleTransactionScript},
+		{name: "per-database dumps", perDatabase: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+
+			options := h.Options("default", "cluster-example")
+			options.DumpOptions.PerDatabase = tt.perDatabase
+			name, err := h.Backup(ctx, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rep.SetRestoreOptions(executor.RestoreOptions{SingleTransaction: true})
+
+			_, err = rep.Restore(ctx, name, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				return
+			}
+
+			replayed, err := h.Replayed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(replayed) > 0 {
+				t.Errorf("the script has been replayed after being refused: %q", replayed)
+			}
+		})
+	}
+}
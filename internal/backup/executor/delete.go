@@ -0,0 +1,240 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+var (
+	// ErrLastBackup is raised when deleting the only backup of a cluster
+	ErrLastBackup = errors.New("refusing to delete the last backup of the cluster")
+
+	// ErrBackupLocked is raised when deleting a backup whose objects
+	// are protected by the object lock
+	ErrBackupLocked = errors.New("the backup is protected by the object lock")
+
+	// ErrBackupHasDependents is raised when deleting a backup other
+	// incremental backups depend on
+	ErrBackupHasDependents = errors.New("other backups depend on the backup")
+)
+
+// DeleteOptions are the settings of the removal of a backup
+type DeleteOptions struct {
+	// DryRun only reports the objects which would be removed
+	DryRun bool
+
+	// Force removes the backup even when it is the last one or
+	// when other backups depend on it. Locked backups are never removed
+	Force bool
+}
+
+// DeleteResult describes the removal of a backup
+type DeleteResult struct {
+	// Backup is the name of the backup
+	Backup string `json:"backup"`
+
+	// Keys are the objects removed, or which would be removed
+	// in a dry run
+	Keys []string `json:"keys"`
+
+	// Dependents are the backups which cannot be restored anymore
+	// once the backup is removed
+	Dependents []string `json:"dependents,omitempty"`
+
+	// DryRun is true when nothing has been removed
+	DryRun bool `json:"dryRun"`
+}
+
+// Delete removes every object belonging to a backup. The metadata goes
+// last, so that a partial failure can be retried
+func (repo *Repository) Delete(ctx context.Context, name string, options DeleteOptions) (*DeleteResult, error) {
+	logger := logging.FromContext(ctx)
+
+	metadata, err := repo.ReadMetadata(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := repo.isLocked(ctx, metadata, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		if metadata.RetainUntil != nil && metadata.RetainUntil.After(time.Now()) {
+			return nil, fmt.Errorf("%w: %s is retained until %s", ErrBackupLocked,
+				name, metadata.RetainUntil.Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("%w: %s is under legal hold", ErrBackupLocked, name)
+	}
+
+	backups, err := repo.ListBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DeleteResult{Backup: name, DryRun: options.DryRun}
+	siblings := 0
+	for _, item := range backups {
+		if item.Namespace != metadata.Namespace || item.ClusterName != metadata.ClusterName {
+			continue
+		}
+		siblings++
+		if item.Parent == name {
+			result.Dependents = append(result.Dependents, item.Name)
+		}
+	}
+
+	if !options.Force {
+		if siblings <= 1 {
+			return nil, fmt.Errorf("%w: %s, use --force to delete it anyway", ErrLastBackup, name)
+		}
+		if len(result.Dependents) > 0 {
+			return nil, fmt.Errorf("%w: %s is the parent of %s, use --force to delete it anyway",
+				ErrBackupHasDependents, name, strings.Join(result.Dependents, ", "))
+		}
+	}
+
+	keys, err := repo.backupObjectKeys(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	result.Keys = keys
+
+	if options.DryRun {
+		return result, nil
+	}
+
+	logger.Info("Removing backup", "name", name, "objects", len(keys), "dependents", result.Dependents)
+	if err := repo.deleteKeys(ctx, keys); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// backupObjectKeys lists every object belonging to a backup: the archive,
+// the manifest, the per-database dumps and anything else stored in its
+// folder. The metadata is always the last key
+func (repo *Repository) backupObjectKeys(ctx context.Context, metadata *BackupMetadata) ([]string, error) {
+	metadataKey := repo.metadataKey(metadata.Name)
+	folder := repo.backupKey(metadata.Name, "") + "/"
+
+	seen := map[string]bool{metadataKey: true}
+	var keys []string
+	add := func(key string) {
+		if len(key) > 0 && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	add(metadata.Archive)
+	add(metadata.Manifest)
+
+	paginator := s3.NewListObjectsV2Paginator(repo.client, &s3.ListObjectsV2Input{
+		Bucket: &repo.bucket,
+		Prefix: aws.String(folder),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			add(aws.ToString(object.Key))
+		}
+	}
+
+	return append(keys, metadataKey), nil
+}
+
+// deleteKeys removes the passed objects, one at a time and in order. In
+// versioned buckets, such as the ones with object lock, deleting a key
+// only hides it behind a delete marker, so every version of the key is
+// removed instead. The callers only remove objects whose retention has
+// passed, which the object storage enforces anyway
+func (repo *Repository) deleteKeys(ctx context.Context, keys []string) error {
+	versioned, err := repo.isVersioned(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range keys {
+		if versioned {
+			err = repo.deleteVersions(ctx, keys[i])
+		} else {
+			_, err = repo.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: &repo.bucket,
+				Key:    &keys[i],
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("while removing %s: %w", keys[i], err)
+		}
+	}
+
+	return nil
+}
+
+// deleteVersions removes every version of a key, the delete markers included
+func (repo *Repository) deleteVersions(ctx context.Context, key string) error {
+	var versionIDs []*string
+	paginator := s3.NewListObjectVersionsPaginator(repo.client, &s3.ListObjectVersionsInput{
+		Bucket: &repo.bucket,
+		Prefix: &key,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, version := range page.Versions {
+			if aws.ToString(version.Key) == key {
+				versionIDs = append(versionIDs, version.VersionId)
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if aws.ToString(marker.Key) == key {
+				versionIDs = append(versionIDs, marker.VersionId)
+			}
+		}
+	}
+
+	for _, versionID := range versionIDs {
+		if _, err := repo.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket:    &repo.bucket,
+			Key:       &key,
+			VersionId: versionID,
+		}); err != nil {
+			return fmt.Errorf("version %s: %w", aws.ToString(versionID), err)
+		}
+	}
+
+	return nil
+}
+
+// isVersioned is true when the bucket keeps the previous versions of
+// the objects, including when versioning has been suspended. Object
+// storages without versioning are considered unversioned
+func (repo *Repository) isVersioned(ctx context.Context) (bool, error) {
+	output, err := repo.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: &repo.bucket,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("while checking the versioning of bucket %s: %w", repo.bucket, err)
+	}
+
+	return len(output.Status) > 0, nil
+}
@@ -0,0 +1,206 @@
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestDeleteVersionedBackup(t *testing.T) {
+	tests := []struct {
+		name       string
+		objectLock bool
+	}{
+		{name: "versioned bucket"},
+		{name: "bucket with object lock", objectLock: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+
+			options := h.Options("default", "cluster-example")
+			if tt.objectLock {
+				h.S3.EnableObjectLock(h.Bucket)
+				options.ObjectLock = backup.ObjectLockOptions{LegalHold: true}
+			} else {
+				h.S3.EnableVersioning(h.Bucket)
+			}
+
+			var names []string
+			for range 2 {
+				name, err := h.Backup(ctx, options)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, name)
+			}
+
+			var keys []string
+			for _, key := range h.S3.Keys(h.Bucket) {
+				if strings.Contains(key, names[0]) {
+					keys = append(keys, key)
+				}
+			}
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.objectLock {
+				if _, err := rep.Delete(ctx, names[0], executor.DeleteOptions{}); !errors.Is(err, executor.ErrBackupLocked) {
+					t.Fatalf("deleting a backup under legal hold: got %v, want %v", err, executor.ErrBackupLocked)
+				}
+				for _, key := range keys {
+					h.S3.SetLegalHold(h.Bucket, key, false)
+				}
+			}
+
+			if _, err := rep.Delete(ctx, names[0], executor.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range keys {
+				if versions := h.S3.Versions(h.Bucket, key); len(versions) > 0 {
+					t.Errorf("%d versions of %s are left behind", len(versions), key)
+				}
+			}
+
+			backups, err := rep.ListBackups(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 1 || backups[0].Name != names[1] {
+				t.Errorf("listed %+v, want only backup %s", backups, names[1])
+			}
+		})
+	}
+}
+
+func TestDeleteSafetyChecks(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// backups are the types of the backups taken, the first one
+		// is deleted. otherCluster takes a backup of another cluster
+		// sharing the prefix, and retained locks the backups
+		backups      []executor.BackupType
+		otherCluster bool
+		retained     bool
+
+		options        executor.DeleteOptions
+		wantErr        error
+		wantDependents int
+		wantRemoved    bool
+	}{
+		{
+			name:        "backup with a newer one",
+			backups:     []executor.BackupType{executor.BackupTypeLogical, executor.BackupTypeLogical},
+			wantRemoved: true,
+		},
+		{
+			name:    "last backup",
+			backups: []executor.BackupType{executor.BackupTypeLogical},
+			wantErr: executor.ErrLastBackup,
+		},
+		{
+			name:         "last backup of the cluster in a shared prefix",
+			backups:      []executor.BackupType{executor.BackupTypeLogical},
+			otherCluster: true,
+			wantErr:      executor.ErrLastBackup,
+		},
+		{
+			name:        "last backup forced",
+			backups:     []executor.BackupType{executor.BackupTypeLogical},
+			options:     executor.DeleteOptions{Force: true},
+			wantRemoved: true,
+		},
+		{
+			name:    "parent of an incremental backup",
+			backups: []executor.BackupType{executor.BackupTypeFull, executor.BackupTypeIncremental},
+			wantErr: executor.ErrBackupHasDependents,
+		},
+		{
+			name:           "parent of an incremental backup forced",
+			backups:        []executor.BackupType{executor.BackupTypeFull, executor.BackupTypeIncremental},
+			options:        executor.DeleteOptions{Force: true},
+			wantDependents: 1,
+			wantRemoved:    true,
+		},
+		{
+			name:           "dry run",
+			backups:        []executor.BackupType{executor.BackupTypeFull, executor.BackupTypeIncremental},
+			options:        executor.DeleteOptions{Force: true, DryRun: true},
+			wantDependents: 1,
+		},
+		{
+			name:     "retained backup forced",
+			backups:  []executor.BackupType{executor.BackupTypeLogical, executor.BackupTypeLogical},
+			retained: true,
+			options:  executor.DeleteOptions{Force: true},
+			wantErr:  executor.ErrBackupLocked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+			if tt.retained {
+				h.S3.EnableObjectLock(h.Bucket)
+			}
+
+			var names []string
+			for _, backupType := range tt.backups {
+				options := h.Options("default", "cluster-example")
+				options.AllowSharedPrefix = tt.otherCluster
+				options.BackupType = backupType
+				if tt.retained {
+					options.ObjectLock = backup.ObjectLockOptions{Mode: "compliance", Retention: "1d"}
+				}
+				name, err := h.Backup(ctx, options)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, name)
+			}
+			if tt.otherCluster {
+				options := h.Options("default", "another-cluster")
+				options.Prefix = "default/cluster-example"
+				options.AllowSharedPrefix = true
+				if _, err := h.Backup(ctx, options); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := rep.Delete(ctx, names[0], tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if len(result.Dependents) != tt.wantDependents {
+					t.Errorf("got the dependents %v, want %d", result.Dependents, tt.wantDependents)
+				}
+				if len(result.Keys) == 0 || !strings.HasSuffix(result.Keys[len(result.Keys)-1], "/backup.json") {
+					t.Errorf("got the keys %v, want the metadata last", result.Keys)
+				}
+			}
+
+			_, err = rep.ReadMetadata(ctx, names[0])
+			if removed := errors.Is(err, executor.ErrMetadataNotFound); removed != tt.wantRemoved {
+				t.Errorf("the backup has been removed: %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"path/filepath"
+)
+
+// DefaultWorkingDir is the scratch volume of the sidecar, where the
+// backups are written before being uploaded
+const DefaultWorkingDir = "/backup"
+
+// Environment is where the backups are taken and restored: the directory
+// holding them on their way to and from the object storage, and the
+// PostgreSQL client binaries. The zero value is the one of the sidecar
+type Environment struct {
+	// WorkingDir is where the backups are written before being
+	// uploaded, DefaultWorkingDir when empty
+	WorkingDir string
+
+	// BinDir holds the PostgreSQL client binaries, which are looked up
+	// in the PATH when empty
+	BinDir string
+}
+
+// workingDir is the directory the backups are written into
+func (env Environment) workingDir() string {
+	if len(env.WorkingDir) == 0 {
+		return DefaultWorkingDir
+	}
+
+	return env.WorkingDir
+}
+
+// path is the path of a file of the working directory
+func (env Environment) path(file string) string {
+	return filepath.Join(env.workingDir(), file)
+}
+
+// binary is the command running a PostgreSQL client binary
+func (env Environment) binary(name string) string {
+	if len(env.BinDir) == 0 {
+		return name
+	}
+
+	return filepath.Join(env.BinDir, name)
+}
@@ -2,15 +2,8 @@ package executor
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"github.com/cloudnative-pg/cloudnative-pg/pkg/management/url"
-	"github.com/cloudnative-pg/cloudnative-pg/pkg/utils"
 	"github.com/google/uuid"
-	"io"
-	"net"
-	"net/http"
 	"time"
 
 	"github.com/cloudnative-pg/cloudnative-pg/pkg/management/postgres/webserver"
@@ -35,13 +28,16 @@ var backupModeBackoff = wait.Backoff{
 
 // Executor manages the execution of a backup
 type Executor struct {
-	backupClient         webserver.BackupClient
-	beginWal             string
-	endWal               string
-	backup               string
-	repository           *Repository
-	backupClientEndpoint string
-	executed             bool
+	instance    InstanceClient
+	beginWal    string
+	endWal      string
+	backup      string
+	backupType  BackupType
+	hooks       Hooks
+	hookResults []HookResult
+	metadata    *BackupMetadata
+	repository  *Repository
+	executed    bool
 }
 
 // GetBeginWal returns the beginWal value, panics if the executor was not executed
@@ -60,20 +56,41 @@ func (executor *Executor) GetEndWal() string {
 	return executor.endWal
 }
 
-// newExecutor creates a new backup Executor
-func newExecutor(repo *Repository, endpoint string) *Executor {
+// GetMetadata returns the metadata of the backup stored in the repository,
+// panics if the executor was not executed
+func (executor *Executor) GetMetadata() *BackupMetadata {
+	if !executor.executed {
+		panic("metadata: please run take backup before trying to access this value")
+	}
+	return executor.metadata
+}
+
+// GetBackupName returns the name the backup is stored with
+func (executor *Executor) GetBackupName() string {
+	return executor.backup
+}
+
+// NewExecutor creates a new backup Executor talking to the passed
+// instance manager, or to the one of the local pod when nil
+func NewExecutor(repo *Repository, backupType BackupType, hooks Hooks, instance InstanceClient) *Executor {
+	if instance == nil {
+		instance = localInstanceClient()
+	}
+
 	backupName, _ := uuid.NewUUID()
 	return &Executor{
-		backupClient:         webserver.NewBackupClient(),
-		backup:               backupName.String(),
-		repository:           repo,
-		backupClientEndpoint: endpoint,
+		instance:   instance,
+		backup:     backupName.String(),
+		backupType: backupType,
+		hooks:      hooks,
+		repository: repo,
 	}
 }
 
-// NewS3Executor creates a new backup Executor
-func NewS3Executor(repo *Repository) *Executor {
-	return newExecutor(repo, podIP)
+// NewS3Executor creates a new backup Executor talking to the instance
+// manager of the local pod
+func NewS3Executor(repo *Repository, backupType BackupType, hooks Hooks) *Executor {
+	return NewExecutor(repo, backupType, hooks, nil)
 }
 
 // Backup executes a backup. Returns the result and any error encountered
@@ -88,13 +105,58 @@ func (executor *Executor) Backup(ctx context.Context) (*webserver.BackupResultDa
 		return nil, err
 	}
 
-	contextLogger.Info("Copying files")
-	if err := executor.execSnapshot(ctx); err != nil {
-		return nil, err
+	startedAt := time.Now()
+	err := executor.runHooks(ctx, executor.hooks.pre(executor.repository.env))
+	if err == nil {
+		contextLogger.Info("Copying files")
+		err = executor.execSnapshot(ctx)
 	}
 
+	// The post-backup hooks undo what the pre-backup ones did, so they
+	// run even when the backup failed. Their failure doesn't invalidate
+	// the snapshot and is only recorded with the outcome of the backup
+	_ = executor.runHooks(context.WithoutCancel(ctx), executor.hooks.post(executor.repository.env))
+
+	// PostgreSQL is taken out of backup mode whatever happened to the
+	// snapshot, even when the backup lock has been lost in the meantime
 	contextLogger.Info("Finishing backup")
-	return executor.unsetBackupMode(ctx)
+	backupStatus, stopErr := executor.unsetBackupMode(context.WithoutCancel(ctx))
+	if err = errors.Join(err, stopErr); err != nil {
+		executor.recordFailure(context.WithoutCancel(ctx), startedAt, err)
+		return nil, err
+	}
+
+	contextLogger.Info("Writing backup metadata")
+	executor.metadata.BeginLSN = string(backupStatus.BeginLSN)
+	executor.metadata.EndLSN = string(backupStatus.EndLSN)
+	executor.metadata.BeginWal = executor.beginWal
+	executor.metadata.EndWal = executor.endWal
+	executor.metadata.StoppedAt = time.Now()
+	executor.metadata.Hooks = executor.hookResults
+	if err := executor.repository.WriteMetadata(ctx, executor.metadata); err != nil {
+		return nil, err
+	}
+
+	return backupStatus, nil
+}
+
+// recordFailure stores why the backup failed, together with the
+// outcome of its hooks. Failing to do so is only logged, so that the
+// error of the backup is the one reported
+func (executor *Executor) recordFailure(ctx context.Context, startedAt time.Time, cause error) {
+	logger := logging.FromContext(ctx)
+
+	failure := &BackupFailure{
+		Name:      executor.backup,
+		Type:      executor.backupType,
+		Error:     cause.Error(),
+		Hooks:     executor.hookResults,
+		StartedAt: startedAt,
+		StoppedAt: time.Now(),
+	}
+	if err := executor.repository.WriteFailure(ctx, failure); err != nil {
+		logger.Error(err, "while recording the backup failure", "backup", executor.backup)
+	}
 }
 
 // setBackupMode starts a backup by setting PostgreSQL in backup mode
@@ -107,11 +169,14 @@ func (executor *Executor) setBackupMode(ctx context.Context) error {
 		return currentWALErr
 	}
 
-	if err := executor.backupClient.Start(ctx, executor.backupClientEndpoint, webserver.StartBackupRequest{
+	if err := executor.instance.StartBackup(ctx, webserver.StartBackupRequest{
 		ImmediateCheckpoint: true,
 		WaitForArchive:      true,
 		BackupName:          executor.backup,
-		Force:               true,
+		// Concurrent backups are prevented by the repository lock, so
+		// a backup still registered in the instance is a leftover of a
+		// sidecar which died while taking it
+		Force: true,
 	}); err != nil {
 		logger.Error(err, "while requesting new backup on PostgreSQL")
 		return err
@@ -119,12 +184,12 @@ func (executor *Executor) setBackupMode(ctx context.Context) error {
 
 	logger.Info("Requesting PostgreSQL Backup mode")
 	if err := retry.OnError(backupModeBackoff, retryOnBackupNotStarted, func() error {
-		response, err := executor.backupClient.StatusWithErrors(ctx, executor.backupClientEndpoint)
+		response, err := executor.instance.BackupStatus(ctx)
 		if err != nil {
 			return err
 		}
 
-		if response.Data.Phase != webserver.Started {
+		if response.Data == nil || response.Data.Phase != webserver.Started {
 			logger.V(4).Info("Backup still not started", "status", response.Data)
 			return ErrBackupNotStarted
 		}
@@ -138,16 +203,17 @@ func (executor *Executor) setBackupMode(ctx context.Context) error {
 	return nil
 }
 
-// execSnapshot  runs pg_dumpall against the postgres cluster
+// execSnapshot copies the postgres cluster into the repository
 func (executor *Executor) execSnapshot(ctx context.Context) error {
 	logger := logging.FromContext(ctx)
 
-	logger.Info("Running pg_dumpall")
-	err := executor.repository.Snapshot(ctx)
+	logger.Info("Taking snapshot", "type", executor.backupType)
+	metadata, err := executor.repository.Snapshot(ctx, executor.backup, executor.backupType)
 	if err != nil {
 		return err
 	}
 
+	executor.metadata = metadata
 	return nil
 }
 
@@ -155,7 +221,7 @@ func (executor *Executor) execSnapshot(ctx context.Context) error {
 func (executor *Executor) unsetBackupMode(ctx context.Context) (*webserver.BackupResultData, error) {
 	logger := logging.FromContext(ctx)
 
-	if err := executor.backupClient.Stop(ctx, executor.backupClientEndpoint, webserver.StopBackupRequest{
+	if err := executor.instance.StopBackup(ctx, webserver.StopBackupRequest{
 		BackupName: executor.backup,
 	}); err != nil {
 		logger.Error(err, "while requesting new backup on PostgreSQL")
@@ -165,12 +231,12 @@ func (executor *Executor) unsetBackupMode(ctx context.Context) (*webserver.Backu
 	logger.Info("Stopping PostgreSQL Backup mode")
 	var backupStatus webserver.BackupResultData
 	if err := retry.OnError(backupModeBackoff, retryOnBackupNotStopped, func() error {
-		response, err := executor.backupClient.StatusWithErrors(ctx, executor.backupClientEndpoint)
+		response, err := executor.instance.BackupStatus(ctx)
 		if err != nil {
 			return err
 		}
 
-		if response.Data.Phase != webserver.Completed {
+		if response.Data == nil || response.Data.Phase != webserver.Completed {
 			logger.V(4).Info("backup still not stopped", "status", response.Data)
 			return ErrBackupNotStopped
 		}
@@ -203,76 +269,10 @@ func retryOnBackupNotStopped(e error) bool {
 func (executor *Executor) getCurrentWALFile(ctx context.Context) (string, error) {
 	const currentWALFileControlFile = "Latest checkpoint's REDO WAL file"
 
-	controlDataOutput, err := getPgControlData(ctx)
+	controlDataOutput, err := executor.instance.PgControlData(ctx)
 	if err != nil {
 		return "", err
 	}
 
 	return controlDataOutput[currentWALFileControlFile], nil
 }
-
-// getPgControlData obtains the pg_controldata from the instance HTTP endpoint
-func getPgControlData(
-	ctx context.Context,
-) (map[string]string, error) {
-	contextLogger := logging.FromContext(ctx)
-
-	const (
-		connectionTimeout = 2 * time.Second
-		requestTimeout    = 30 * time.Second
-	)
-
-	// We want a connection timeout to prevent waiting for the default
-	// TCP connection timeout (30 seconds) on lost SYN packets
-	timeoutClient := &http.Client{
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout: connectionTimeout,
-			}).DialContext,
-		},
-		Timeout: requestTimeout,
-	}
-
-	httpURL := url.Build(podIP, url.PathPGControlData, url.StatusPort)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := timeoutClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			contextLogger.Error(err, "while closing body")
-		}
-	}()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		contextLogger.Info("Error while querying the pg_controldata endpoint",
-			"statusCode", resp.StatusCode,
-			"body", string(body))
-		return nil, fmt.Errorf("error while querying the pg_controldata endpoint: %d", resp.StatusCode)
-	}
-
-	type pgControldataResponse struct {
-		Data  string `json:"data,omitempty"`
-		Error error  `json:"error,omitempty"`
-	}
-
-	var result pgControldataResponse
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		result.Error = err
-		return nil, err
-	}
-
-	return utils.ParsePgControldataOutput(result.Data), result.Error
-}
@@ -0,0 +1,97 @@
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestBackupFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		hooks     executor.Hooks
+		noSpace   bool
+		wantErr   error
+		wantHooks []string
+	}{
+		{
+			name: "failed pre-backup hook",
+			hooks: executor.Hooks{
+				PreCommand:  "echo freezing; exit 3",
+				PostCommand: "echo thawed",
+			},
+			wantHooks: []string{"preBackupCommand: freezing", "postBackupCommand: thawed"},
+		},
+		{
+			name: "failed snapshot",
+			hooks: executor.Hooks{
+				PreCommand:  "echo frozen",
+				PostCommand: "echo thawed",
+			},
+			noSpace:   true,
+			wantErr:   executor.ErrNotEnoughSpace,
+			wantHooks: []string{"preBackupCommand: frozen", "postBackupCommand: thawed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+
+			if tt.noSpace {
+				if err := h.SetDatabaseSize("1152921504606846976"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			exec := executor.NewExecutor(rep, executor.BackupTypeLogical, tt.hooks, h.Dependencies().Instance)
+			_, backupErr := exec.Backup(ctx)
+			if backupErr == nil {
+				t.Fatal("the backup succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(backupErr, tt.wantErr) {
+				t.Fatalf("got %v, want %v", backupErr, tt.wantErr)
+			}
+
+			if h.Instance.InBackupMode() {
+				t.Error("PostgreSQL has been left in backup mode")
+			}
+
+			failure, err := rep.ReadFailure(ctx, exec.GetBackupName())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failure == nil {
+				t.Fatal("the failure has not been recorded")
+			}
+			if failure.Error != backupErr.Error() {
+				t.Errorf("recorded error %q, want %q", failure.Error, backupErr.Error())
+			}
+
+			var hooks []string
+			for _, hook := range failure.Hooks {
+				hooks = append(hooks, hook.Name+": "+strings.TrimSpace(hook.Output))
+			}
+			if strings.Join(hooks, ",") != strings.Join(tt.wantHooks, ",") {
+				t.Errorf("recorded hooks %v, want %v", hooks, tt.wantHooks)
+			}
+
+			backups, err := rep.ListBackups(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 0 {
+				t.Errorf("the failed backup is in the catalog: %+v", backups)
+			}
+		})
+	}
+}
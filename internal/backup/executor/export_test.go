@@ -0,0 +1,59 @@
+package executor
+
+// SetCopyLimits lowers the sizes deciding when objects are copied and
+// streamed in parts, so that small objects exercise multipart copies.
+// The returned function restores them
+func SetCopyLimits(maxCopy int64, partSize int64) func() {
+	previousMaxCopy, previousCopyPart, previousStreamPart := maxCopyObjectSize, copyPartSize, streamPartSize
+	maxCopyObjectSize, copyPartSize, streamPartSize = maxCopy, partSize, partSize
+
+	return func() {
+		maxCopyObjectSize, copyPartSize, streamPartSize = previousMaxCopy, previousCopyPart, previousStreamPart
+	}
+}
+
+// NewLimiter, NewThrottledReader and NewThrottledWriter expose the
+// throttled streams
+var (
+	NewLimiter         = newLimiter
+	NewThrottledReader = newThrottledReader
+	NewThrottledWriter = newThrottledWriter
+)
+
+// NewGlobalsFilter and TolerateExistingObjects expose the rewriting
+// of the pg_dumpall scripts
+var (
+	NewGlobalsFilter        = newGlobalsFilter
+	TolerateExistingObjects = tolerateExistingObjects
+)
+
+// ParseRestoreErrors collects the errors printed by psql or pg_restore,
+// writing their output by chunks of the passed size
+func ParseRestoreErrors(source string, output string, chunkSize int) (*RestoreReport, *RestoreError) {
+	report := &RestoreReport{}
+	parser := &restoreErrorParser{report: report, source: source}
+	for data := []byte(output); len(data) > 0; {
+		chunk := data[:min(chunkSize, len(data))]
+		_, _ = parser.Write(chunk)
+		data = data[len(chunk):]
+	}
+
+	return report, parser.Flush()
+}
+
+// InspectArchive returns the layout and the path of the dump held by
+// an extracted archive
+func InspectArchive(folder string) (ArchiveLayout, string, error) {
+	content, err := inspectArchive(folder)
+	return content.layout, content.path, err
+}
+
+// ValidateArchive checks an extracted archive against the metadata of its backup
+func ValidateArchive(folder string, metadata *BackupMetadata) error {
+	content, err := inspectArchive(folder)
+	if err != nil {
+		return err
+	}
+
+	return validateArchive(folder, metadata, content)
+}
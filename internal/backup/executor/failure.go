@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// failureFile records why a backup failed. It is kept apart from the
+// metadata, so that failed backups never appear in the catalog
+const failureFile = "failure.json"
+
+// BackupFailure describes a backup which failed, together with the
+// outcome of the hooks which ran around it
+type BackupFailure struct {
+	// Name is the name the backup would have been stored with
+	Name string     `json:"name"`
+	Type BackupType `json:"type"`
+
+	// Error is the reason of the failure
+	Error string `json:"error"`
+
+	// Hooks are the outcomes of the hooks run around the snapshot,
+	// including the one which failed
+	Hooks []HookResult `json:"hooks,omitempty"`
+
+	StartedAt time.Time `json:"startedAt"`
+	StoppedAt time.Time `json:"stoppedAt"`
+}
+
+// WriteFailure stores the failure of a backup in the repository
+func (repo *Repository) WriteFailure(ctx context.Context, failure *BackupFailure) error {
+	content, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &repo.bucket,
+		Key:         aws.String(repo.backupKey(failure.Name, failureFile)),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// ReadFailure reads why a backup failed, returning nil when the
+// backup has no failure recorded
+func (repo *Repository) ReadFailure(ctx context.Context, name string) (*BackupFailure, error) {
+	key := repo.backupKey(name, failureFile)
+	resp, err := repo.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &key,
+	})
+	if errors.As(err, new(*types.NoSuchKey)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var failure BackupFailure
+	if err := json.Unmarshal(content, &failure); err != nil {
+		return nil, fmt.Errorf("while decoding %s: %w", key, err)
+	}
+
+	return &failure, nil
+}
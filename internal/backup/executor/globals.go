@@ -0,0 +1,267 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// DumpContentAll dumps both the schema and the data
+	DumpContentAll = "all"
+
+	// DumpContentSchema dumps only the schema
+	DumpContentSchema = "schema"
+
+	// DumpContentData dumps only the data
+	DumpContentData = "data"
+)
+
+// managedRoles are the roles CloudNativePG creates in every cluster,
+// and whose passwords it manages
+var managedRoles = []string{"postgres", "streaming_replica"}
+
+// roleStatement matches the statements of pg_dumpall creating or
+// altering a role, capturing the role name
+var roleStatement = regexp.MustCompile(`^(CREATE|ALTER) ROLE ("(?:[^"]|"")+"|[^\s;]+)[\s;]`)
+
+// databaseStatement matches the statements of pg_dumpall creating a
+// database, capturing the database name
+var databaseStatement = regexp.MustCompile(`^CREATE DATABASE ("(?:[^"]|"")+"|[^\s;]+) WITH TEMPLATE = template0`)
+
+// copyStatement matches the start of the data of a table, which lasts
+// until a line holding only a backslash and a dot
+var copyStatement = regexp.MustCompile(`^COPY .* FROM stdin;$`)
+
+// DumpOptions selects what a logical backup contains. They don't
+// apply to physical backups, which copy the whole data directory
+type DumpOptions struct {
+	// NoRolePasswords leaves the password hashes of the roles out
+	NoRolePasswords bool
+
+	// SkipManagedRoles leaves out the roles managed by CloudNativePG,
+	// and the tablespaces
+	SkipManagedRoles bool
+
+	// Content is one of DumpContentAll, DumpContentSchema or DumpContentData
+	Content string
+
+	// PerDatabase dumps the global objects with pg_dumpall and every
+	// database with pg_dump in the custom format, instead of taking a
+	// single pg_dumpall script
+	PerDatabase bool
+}
+
+// SetDumpOptions sets what the following logical backups contain
+func (repo *Repository) SetDumpOptions(options DumpOptions) {
+	repo.dumpOptions = options
+}
+
+// args are the pg_dumpall options implementing the dump options
+func (options DumpOptions) args() []string {
+	return append(options.globalsArgs(), options.contentArgs()...)
+}
+
+// globalsArgs are the options about the roles and the tablespaces
+func (options DumpOptions) globalsArgs() []string {
+	var args []string
+	if options.NoRolePasswords {
+		args = append(args, "--no-role-passwords")
+	}
+	if options.SkipManagedRoles {
+		args = append(args, "--no-tablespaces")
+	}
+
+	return args
+}
+
+// contentArgs are the options about the content of the databases,
+// accepted by both pg_dumpall and pg_dump
+func (options DumpOptions) contentArgs() []string {
+	switch options.Content {
+	case DumpContentSchema:
+		return []string{"--schema-only"}
+	case DumpContentData:
+		return []string{"--data-only"}
+	}
+
+	return nil
+}
+
+// isManagedRole is true when the passed role name, as written in the
+// dump, is a role managed by CloudNativePG
+func isManagedRole(name string) bool {
+	return slices.Contains(managedRoles, unquoteIdentifier(name))
+}
+
+// unquoteIdentifier is the name of an identifier as written in a dump
+func unquoteIdentifier(name string) string {
+	if strings.HasPrefix(name, `"`) {
+		return strings.ReplaceAll(strings.Trim(name, `"`), `""`, `"`)
+	}
+
+	return name
+}
+
+// isGlobalsEnd is true for the line where pg_dumpall stops dumping the
+// global objects and connects to the first database. Only the lines
+// before are filtered, so the data of the databases is never touched
+func isGlobalsEnd(line string) bool {
+	return strings.HasPrefix(line, `\connect `)
+}
+
+// globalsFilter removes the statements about the roles managed by
+// CloudNativePG from the output of pg_dumpall
+type globalsFilter struct {
+	writer  io.Writer
+	pending []byte
+	done    bool
+}
+
+func newGlobalsFilter(writer io.Writer) *globalsFilter {
+	return &globalsFilter{writer: writer}
+}
+
+func (f *globalsFilter) Write(p []byte) (int, error) {
+	if f.done {
+		return f.writer.Write(p)
+	}
+
+	f.pending = append(f.pending, p...)
+	for !f.done {
+		index := bytes.IndexByte(f.pending, '\n')
+		if index < 0 {
+			break
+		}
+
+		line := f.pending[:index+1]
+		if err := f.writeLine(line); err != nil {
+			return 0, err
+		}
+		f.pending = f.pending[index+1:]
+	}
+
+	if f.done && len(f.pending) > 0 {
+		if _, err := f.writer.Write(f.pending); err != nil {
+			return 0, err
+		}
+		f.pending = nil
+	}
+
+	return len(p), nil
+}
+
+func (f *globalsFilter) writeLine(line []byte) error {
+	if isGlobalsEnd(string(line)) {
+		f.done = true
+	} else if match := roleStatement.FindSubmatch(line); match != nil && isManagedRole(string(match[2])) {
+		return nil
+	}
+
+	_, err := f.writer.Write(line)
+	return err
+}
+
+// Flush writes the last line, which is not terminated by a newline
+func (f *globalsFilter) Flush() error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+
+	pending := f.pending
+	f.pending = nil
+	if f.done {
+		_, err := f.writer.Write(pending)
+		return err
+	}
+
+	return f.writeLine(pending)
+}
+
+// tolerateExistingObjects rewrites a pg_dumpall script so that it can be
+// replayed into a cluster which already has some of its roles and
+// databases, like the application database created by CloudNativePG.
+// Roles and databases are created only when missing, while the roles
+// managed by CloudNativePG are left untouched. Every line is kept in
+// place, so that errors point to the same line of the dump
+func tolerateExistingObjects(reader io.Reader) io.Reader {
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		pipeWriter.CloseWithError(rewriteExistingObjects(bufio.NewReader(reader), pipeWriter))
+	}()
+
+	return pipeReader
+}
+
+func rewriteExistingObjects(reader *bufio.Reader, writer io.Writer) error {
+	globals := true
+	copying := false
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			// The data of the tables is never rewritten
+			switch {
+			case copying:
+				copying = strings.TrimRight(line, "\n") != `\.`
+			case globals && isGlobalsEnd(line):
+				globals = false
+			case globals:
+				line = rewriteRoleStatement(line)
+			case copyStatement.MatchString(strings.TrimRight(line, "\n")):
+				copying = true
+			default:
+				line = rewriteDatabaseStatement(line)
+			}
+
+			if _, err := io.WriteString(writer, line); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func rewriteRoleStatement(line string) string {
+	match := roleStatement.FindStringSubmatch(line)
+	if match == nil {
+		return line
+	}
+
+	if isManagedRole(match[2]) {
+		return "\n"
+	}
+	if match[1] != "CREATE" {
+		return line
+	}
+
+	return fmt.Sprintf(
+		"DO $create_role$ BEGIN %s EXCEPTION WHEN duplicate_object THEN "+
+			"RAISE NOTICE '%%, skipping', SQLERRM; END $create_role$;\n",
+		strings.TrimSpace(line))
+}
+
+// rewriteDatabaseStatement creates the database only when missing. The
+// statement cannot run inside a DO block, so psql runs it with \gexec
+// only when the query returns it
+func rewriteDatabaseStatement(line string) string {
+	match := databaseStatement.FindStringSubmatch(line)
+	if match == nil {
+		return line
+	}
+
+	statement := strings.TrimSuffix(strings.TrimSpace(line), ";")
+	return fmt.Sprintf("SELECT %s WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = %s)\\gexec\n",
+		quoteLiteral(statement), quoteLiteral(unquoteIdentifier(match[1])))
+}
@@ -0,0 +1,99 @@
+package executor_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+// globalsDump is a pg_dumpall script with roles and a database, whose
+// data mentions the same statements
+const globalsDump = `CREATE ROLE app;
+ALTER ROLE app WITH LOGIN PASSWORD 'md5secret';
+CREATE ROLE postgres;
+ALTER ROLE postgres WITH SUPERUSER;
+CREATE ROLE "streaming_replica";
+CREATE ROLE "my ""quoted"" role";
+\connect template1
+CREATE DATABASE app WITH TEMPLATE = template0 ENCODING = 'UTF8';
+\connect app
+COPY public.statements (body) FROM stdin;
+CREATE ROLE postgres;
+CREATE DATABASE app WITH TEMPLATE = template0;
+\.
+CREATE ROLE postgres;`
+
+func TestGlobalsFilter(t *testing.T) {
+	want := `CREATE ROLE app;
+ALTER ROLE app WITH LOGIN PASSWORD 'md5secret';
+CREATE ROLE "my ""quoted"" role";
+\connect template1
+CREATE DATABASE app WITH TEMPLATE = template0 ENCODING = 'UTF8';
+\connect app
+COPY public.statements (body) FROM stdin;
+CREATE ROLE postgres;
+CREATE DATABASE app WITH TEMPLATE = template0;
+\.
+CREATE ROLE postgres;`
+
+	for _, chunkSize := range []int{1, 7, len(globalsDump)} {
+		var result bytes.Buffer
+		filter := executor.NewGlobalsFilter(&result)
+		for data := []byte(globalsDump); len(data) > 0; {
+			chunk := data[:min(chunkSize, len(data))]
+			if n, err := filter.Write(chunk); err != nil || n != len(chunk) {
+				t.Fatalf("wrote %d bytes of %d: %v", n, len(chunk), err)
+			}
+			data = data[len(chunk):]
+		}
+		if err := filter.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if result.String() != want {
+			t.Errorf("writing by chunks of %d bytes, got:\n%s\nwant:\n%s", chunkSize, result.String(), want)
+		}
+	}
+}
+
+func TestTolerateExistingObjects(t *testing.T) {
+	content, err := io.ReadAll(executor.TolerateExistingObjects(strings.NewReader(globalsDump)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`DO $create_role$ BEGIN CREATE ROLE app; EXCEPTION WHEN duplicate_object THEN ` +
+			`RAISE NOTICE '%, skipping', SQLERRM; END $create_role$;`,
+		`ALTER ROLE app WITH LOGIN PASSWORD 'md5secret';`,
+		``,
+		``,
+		``,
+		`DO $create_role$ BEGIN CREATE ROLE "my ""quoted"" role"; EXCEPTION WHEN duplicate_object THEN ` +
+			`RAISE NOTICE '%, skipping', SQLERRM; END $create_role$;`,
+		`\connect template1`,
+		`SELECT 'CREATE DATABASE app WITH TEMPLATE = template0 ENCODING = ''UTF8''' ` +
+			`WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = 'app')\gexec`,
+		`\connect app`,
+		`COPY public.statements (body) FROM stdin;`,
+		`CREATE ROLE postgres;`,
+		`CREATE DATABASE app WITH TEMPLATE = template0;`,
+		`\.`,
+		`CREATE ROLE postgres;`,
+	}
+
+	// Every line is kept in place, so that the errors
+	// point to the lines of the dump
+	lines := strings.Split(string(content), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), content)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d is %q, want %q", i+1, lines[i], want[i])
+		}
+	}
+}
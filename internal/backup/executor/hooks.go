@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+const (
+	// DefaultHookTimeout is how long a hook can run when no timeout is set
+	DefaultHookTimeout = 5 * time.Minute
+
+	// maxHookOutput is how much of the output of a hook is kept in the
+	// backup metadata, the end of the output being the most useful part
+	maxHookOutput = 4096
+)
+
+// Hooks are the SQL statements and shell commands run around the snapshot
+type Hooks struct {
+	PreSQL      string
+	PostSQL     string
+	PreCommand  string
+	PostCommand string
+
+	// Timeout is how long every single hook can run
+	Timeout time.Duration
+}
+
+// HookResult is the outcome of a hook, recorded in the backup metadata
+type HookResult struct {
+	// Name is the parameter the hook has been configured with
+	Name string `json:"name"`
+
+	Output    string    `json:"output,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	StoppedAt time.Time `json:"stoppedAt"`
+}
+
+// hook is a single configured hook
+type hook struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// pre are the hooks to be run before the snapshot, in order
+func (hooks Hooks) pre(env Environment) []hook {
+	return configuredHooks(
+		hook{name: "preBackupSQL", run: sqlHook(env, hooks.PreSQL)},
+		hook{name: "preBackupCommand", run: commandHook(env, hooks.PreCommand)},
+	)
+}
+
+// post are the hooks to be run after the snapshot, in the opposite
+// order of the pre-backup ones as they are meant to undo them
+func (hooks Hooks) post(env Environment) []hook {
+	return configuredHooks(
+		hook{name: "postBackupCommand", run: commandHook(env, hooks.PostCommand)},
+		hook{name: "postBackupSQL", run: sqlHook(env, hooks.PostSQL)},
+	)
+}
+
+func configuredHooks(candidates ...hook) []hook {
+	result := make([]hook, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.run != nil {
+			result = append(result, candidate)
+		}
+	}
+
+	return result
+}
+
+// runHooks runs the passed hooks in order, stopping at the first failure.
+// The results are recorded to be stored in the backup metadata
+func (executor *Executor) runHooks(ctx context.Context, hooks []hook) error {
+	logger := logging.FromContext(ctx)
+
+	timeout := executor.hooks.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	for _, h := range hooks {
+		logger.Info("Running backup hook", "hook", h.name, "timeout", timeout)
+
+		hookCtx, cancel := context.WithTimeout(ctx, timeout)
+		result := HookResult{Name: h.name, StartedAt: time.Now()}
+		output, err := h.run(hookCtx)
+		cancel()
+
+		result.StoppedAt = time.Now()
+		result.Output = truncateOutput(output)
+		if err != nil {
+			if hookCtx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after %s: %w", timeout, err)
+			}
+			result.Error = err.Error()
+		}
+		executor.hookResults = append(executor.hookResults, result)
+
+		if err != nil {
+			logger.Error(err, "Backup hook failed", "hook", h.name, "output", result.Output)
+			return fmt.Errorf("%s failed: %w", h.name, err)
+		}
+		logger.Info("Backup hook completed", "hook", h.name, "output", result.Output)
+	}
+
+	return nil
+}
+
+// sqlHook runs SQL statements on the local instance, stopping at the
+// first error
+func sqlHook(env Environment, statements string) func(ctx context.Context) (string, error) {
+	if len(strings.TrimSpace(statements)) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context) (string, error) {
+		return runHookCommand(exec.CommandContext(ctx, env.binary(Psql),
+			"-h", "/controller/run",
+			"-d", "postgres",
+			"-X",
+			"-v", "ON_ERROR_STOP=1",
+			"-c", statements,
+		))
+	}
+}
+
+// commandHook runs a shell command in the sidecar
+func commandHook(env Environment, command string) func(ctx context.Context) (string, error) {
+	if len(strings.TrimSpace(command)) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context) (string, error) {
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+		cmd.Dir = env.workingDir()
+		return runHookCommand(cmd)
+	}
+}
+
+func runHookCommand(cmd *exec.Cmd) (string, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	return strings.TrimSpace(output.String()), err
+}
+
+func truncateOutput(output string) string {
+	if len(output) <= maxHookOutput {
+		return output
+	}
+
+	return "..." + output[len(output)-maxHookOutput:]
+}
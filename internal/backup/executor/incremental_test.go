@@ -0,0 +1,131 @@
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/harness"
+)
+
+// takeBackup takes a backup of the passed type, whose data directory
+// holds the passed data
+func takeBackup(t *testing.T, h *harness.Harness, backupType executor.BackupType, data string) *executor.BackupMetadata {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := h.SetDump(data + "\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	options := h.Options("default", "cluster-example")
+	options.BackupType = backupType
+	name, err := h.Backup(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := h.Repository("default", "cluster-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := rep.ReadMetadata(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func TestIncrementalBackupParent(t *testing.T) {
+	h := newHarness(t)
+
+	first := takeBackup(t, h, executor.BackupTypeIncremental, "first")
+	if first.Type != executor.BackupTypeFull || len(first.Parent) > 0 {
+		t.Fatalf("incremental backup without a physical backup: got %s with parent %q, want a full backup",
+			first.Type, first.Parent)
+	}
+
+	// Logical backups cannot be the parent of an incremental backup
+	takeBackup(t, h, executor.BackupTypeLogical, "logical")
+
+	second := takeBackup(t, h, executor.BackupTypeIncremental, "second")
+	if second.Type != executor.BackupTypeIncremental || second.Parent != first.Name {
+		t.Errorf("got %s with parent %q, want an incremental backup of %s", second.Type, second.Parent, first.Name)
+	}
+
+	third := takeBackup(t, h, executor.BackupTypeIncremental, "third")
+	if third.Parent != second.Name {
+		t.Errorf("got parent %q, want the latest incremental backup %s", third.Parent, second.Name)
+	}
+}
+
+func TestIncrementalBackupWalSummarization(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+
+	takeBackup(t, h, executor.BackupTypeFull, "full")
+	if err := h.SetWalSummarization(false); err != nil {
+		t.Fatal(err)
+	}
+
+	options := h.Options("default", "cluster-example")
+	options.BackupType = executor.BackupTypeIncremental
+	if _, err := h.Backup(ctx, options); !errors.Is(err, executor.ErrWalSummarizationDisabled) {
+		t.Fatalf("got %v, want %v", err, executor.ErrWalSummarizationDisabled)
+	}
+}
+
+func TestRestoreIncrementalChain(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// removeLink removes the first incremental backup of the chain
+		removeLink bool
+	}{
+		{name: "complete chain"},
+		{name: "missing link", removeLink: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+
+			takeBackup(t, h, executor.BackupTypeFull, "full")
+			middle := takeBackup(t, h, executor.BackupTypeIncremental, "first")
+			latest := takeBackup(t, h, executor.BackupTypeIncremental, "second")
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.removeLink {
+				if _, err := rep.Delete(ctx, middle.Name, executor.DeleteOptions{Force: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pgData := filepath.Join(t.TempDir(), "pgdata")
+			_, err = rep.Restore(ctx, latest.Name, pgData)
+			if tt.removeLink {
+				if !errors.Is(err, executor.ErrMetadataNotFound) {
+					t.Fatalf("restoring with a missing link: got %v, want %v", err, executor.ErrMetadataNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			combined, err := h.Combined()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "full\nfirst\nsecond"; combined != want {
+				t.Errorf("combined %q, want the chain from the full backup %q", combined, want)
+			}
+		})
+	}
+}
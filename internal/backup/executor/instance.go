@@ -0,0 +1,228 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cloudnative-pg/cloudnative-pg/pkg/management/postgres/webserver"
+	"github.com/cloudnative-pg/cloudnative-pg/pkg/management/url"
+	"github.com/cloudnative-pg/cloudnative-pg/pkg/utils"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+// Dependencies are the external services the repository and the
+// executor talk to. The zero value reaches the object storage with the
+// default AWS configuration and the instance manager of the local pod,
+// while the test harness replaces them with fakes
+type Dependencies struct {
+	// AWSConfig is the configuration of the object storage client,
+	// loaded from the environment when nil
+	AWSConfig *aws.Config
+
+	// S3Options customize the object storage client
+	S3Options []func(*s3.Options)
+
+	// Instance is the client of the instance manager, the one
+	// running in the local pod when nil
+	Instance InstanceClient
+
+	// Environment is where the backups are taken and restored
+	Environment Environment
+
+	// Progress tracks the backups for the health server of the sidecar.
+	// Every repository tracks its own backups when nil
+	Progress *ProgressTracker
+}
+
+// localInstanceClient returns the client of the instance manager
+// running in the local pod
+func localInstanceClient() InstanceClient {
+	return NewInstanceClient(fmt.Sprintf("http://%s:%d", podIP, url.StatusPort))
+}
+
+// InstanceClient talks to the CloudNativePG instance manager, which puts
+// PostgreSQL in backup mode and exposes the output of pg_controldata
+type InstanceClient interface {
+	// StartBackup puts PostgreSQL in backup mode
+	StartBackup(ctx context.Context, request webserver.StartBackupRequest) error
+
+	// StopBackup takes PostgreSQL out of backup mode
+	StopBackup(ctx context.Context, request webserver.StopBackupRequest) error
+
+	// BackupStatus returns the status of the backup mode
+	BackupStatus(ctx context.Context) (*webserver.Response[webserver.BackupResultData], error)
+
+	// PgControlData returns the output of pg_controldata
+	PgControlData(ctx context.Context) (map[string]string, error)
+}
+
+// instanceClient is the HTTP client of the status endpoint of
+// the instance manager
+type instanceClient struct {
+	baseURL string
+	cli     *http.Client
+}
+
+// NewInstanceClient creates a client of the instance manager status
+// endpoint listening on the passed base URL
+func NewInstanceClient(baseURL string) InstanceClient {
+	const (
+		connectionTimeout = 2 * time.Second
+		requestTimeout    = 30 * time.Second
+	)
+
+	// We want a connection timeout to prevent waiting for the default
+	// TCP connection timeout (30 seconds) on lost SYN packets
+	timeoutClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: connectionTimeout,
+			}).DialContext,
+		},
+		Timeout: requestTimeout,
+	}
+
+	return &instanceClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		cli:     timeoutClient,
+	}
+}
+
+func (c *instanceClient) url(path string) string {
+	return c.baseURL + "/" + strings.TrimPrefix(path, "/")
+}
+
+// StartBackup implements the InstanceClient interface
+func (c *instanceClient) StartBackup(ctx context.Context, request webserver.StartBackupRequest) error {
+	_, err := executeInstanceRequest[struct{}](ctx, c.cli, http.MethodPost, c.url(url.PathPgModeBackup), request, false)
+	return err
+}
+
+// StopBackup implements the InstanceClient interface
+func (c *instanceClient) StopBackup(ctx context.Context, request webserver.StopBackupRequest) error {
+	_, err := executeInstanceRequest[webserver.BackupResultData](
+		ctx, c.cli, http.MethodPut, c.url(url.PathPgModeBackup), request, false)
+	return err
+}
+
+// BackupStatus implements the InstanceClient interface. Errors reported
+// in the response body are returned with the response
+func (c *instanceClient) BackupStatus(ctx context.Context) (*webserver.Response[webserver.BackupResultData], error) {
+	return executeInstanceRequest[webserver.BackupResultData](
+		ctx, c.cli, http.MethodGet, c.url(url.PathPgModeBackup), nil, true)
+}
+
+// PgControlData implements the InstanceClient interface
+func (c *instanceClient) PgControlData(ctx context.Context) (map[string]string, error) {
+	contextLogger := logging.FromContext(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(url.PathPGControlData), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			contextLogger.Error(err, "while closing body")
+		}
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		contextLogger.Info("Error while querying the pg_controldata endpoint",
+			"statusCode", resp.StatusCode,
+			"body", string(body))
+		return nil, fmt.Errorf("error while querying the pg_controldata endpoint: %d", resp.StatusCode)
+	}
+
+	type pgControldataResponse struct {
+		Data string `json:"data,omitempty"`
+	}
+
+	var result pgControldataResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return utils.ParsePgControldataOutput(result.Data), nil
+}
+
+// executeInstanceRequest sends a request to the instance manager and
+// decodes its response. Errors in the response body are ignored when
+// requested, so that the caller can inspect them
+func executeInstanceRequest[T any](
+	ctx context.Context,
+	cli *http.Client,
+	method string,
+	httpURL string,
+	payload any,
+	ignoreBodyErrors bool,
+) (*webserver.Response[T], error) {
+	contextLogger := logging.FromContext(ctx)
+
+	var body io.Reader
+	if payload != nil {
+		jsonBody, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		body = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, httpURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while executing http request: %w", err)
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			contextLogger.Error(err, "while closing response body")
+		}
+	}()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusInternalServerError {
+		return nil, fmt.Errorf("encountered an internal server error status code 500 with body: %s", string(content))
+	}
+
+	var result webserver.Response[T]
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, fmt.Errorf("while unmarshalling the body, body: %s err: %w", string(content), err)
+	}
+	if result.Error != nil && !ignoreBodyErrors {
+		return nil, fmt.Errorf("body contained an error code: %s and message: %s",
+			result.Error.Code, result.Error.Message)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,198 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveLayout is what the archive of a backup contains
+type ArchiveLayout string
+
+const (
+	// LayoutScript is a single pg_dumpall script
+	LayoutScript ArchiveLayout = "script"
+
+	// LayoutDatabases is a folder holding the global objects and a
+	// dump of every database, in the custom or the directory format
+	LayoutDatabases ArchiveLayout = "databases"
+
+	// LayoutDirectory is a folder holding the dump of a single
+	// database in the directory format of pg_dump
+	LayoutDirectory ArchiveLayout = "directory"
+
+	// LayoutDataDirectory is the data directory of a physical backup
+	LayoutDataDirectory ArchiveLayout = "dataDirectory"
+)
+
+// describe is the layout as written in error messages
+func (layout ArchiveLayout) describe() string {
+	switch layout {
+	case LayoutScript:
+		return "a pg_dumpall script"
+	case LayoutDatabases:
+		return "a dump of every database"
+	case LayoutDirectory:
+		return "a directory format dump"
+	case LayoutDataDirectory:
+		return "a data directory"
+	default:
+		return string(layout)
+	}
+}
+
+// directoryDumpMarker is the table of contents of a dump
+// in the directory format
+const directoryDumpMarker = "toc.dat"
+
+// archiveLayout is the layout the archive of a backup is expected to
+// have. Backups taken before the layout was recorded are recognized
+// from the rest of their metadata
+func (metadata *BackupMetadata) archiveLayout() ArchiveLayout {
+	switch {
+	case len(metadata.Layout) > 0:
+		return metadata.Layout
+	case metadata.Type.IsPhysical():
+		return LayoutDataDirectory
+	case len(metadata.Databases) > 0:
+		return LayoutDatabases
+	default:
+		return LayoutScript
+	}
+}
+
+// archiveContent is the dump found in an extracted archive
+type archiveContent struct {
+	layout ArchiveLayout
+
+	// path is the script or the folder to restore
+	path string
+}
+
+// inspectArchive finds the dump held by an extracted archive, which must
+// be a single script or a single folder whose layout is recognized
+func inspectArchive(folder string) (archiveContent, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return archiveContent{}, err
+	}
+
+	switch len(entries) {
+	case 0:
+		return archiveContent{}, errors.New("the archive is empty")
+	case 1:
+	default:
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return archiveContent{}, fmt.Errorf("the archive should hold a single script or folder, found: %s",
+			strings.Join(names, ", "))
+	}
+
+	entry := entries[0]
+	path := filepath.Join(folder, entry.Name())
+	switch {
+	case entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".sql"):
+		return archiveContent{layout: LayoutScript, path: path}, nil
+	case !entry.IsDir():
+		return archiveContent{}, fmt.Errorf("the archive holds %s, which is neither an SQL script nor a folder",
+			entry.Name())
+	}
+
+	for _, candidate := range []struct {
+		layout ArchiveLayout
+		marker string
+	}{
+		{LayoutDatabases, globalsFile},
+		{LayoutDirectory, directoryDumpMarker},
+		{LayoutDataDirectory, manifestFile},
+	} {
+		found, err := fileExists(filepath.Join(path, candidate.marker))
+		if err != nil {
+			return archiveContent{}, err
+		}
+		if found {
+			return archiveContent{layout: candidate.layout, path: path}, nil
+		}
+	}
+
+	return archiveContent{}, fmt.Errorf("the folder %s of the archive holds neither %s, %s nor %s",
+		entry.Name(), globalsFile, directoryDumpMarker, manifestFile)
+}
+
+// validateArchive checks an extracted archive against the metadata of its
+// backup: it must have the expected layout and hold every file recorded
+// when the backup was taken
+func validateArchive(folder string, metadata *BackupMetadata, content archiveContent) error {
+	expected := metadata.archiveLayout()
+	if content.layout != expected {
+		return fmt.Errorf("the archive of backup %s should hold %s, but it holds %s",
+			metadata.Name, expected.describe(), content.layout.describe())
+	}
+
+	var missing []string
+	for _, file := range metadata.Contents {
+		if !filepath.IsLocal(file) {
+			return fmt.Errorf("invalid file %q in the metadata of backup %s", file, metadata.Name)
+		}
+
+		found, err := fileExists(filepath.Join(folder, file))
+		if err != nil {
+			return err
+		}
+		if !found {
+			missing = append(missing, file)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the archive of backup %s is missing: %s", metadata.Name, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// listArchiveContents lists the files which are going to be archived,
+// relative to the working directory, to be recorded in the metadata
+func (env Environment) listArchiveContents(dump string) ([]string, error) {
+	var contents []string
+	err := filepath.WalkDir(env.path(dump), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		relative, err := filepath.Rel(env.workingDir(), path)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, relative)
+		return nil
+	})
+
+	return contents, err
+}
+
+// isDatabaseDump is true for the dumps of the databases of a per-database
+// backup: files in the custom format or folders in the directory format
+func isDatabaseDump(folder string, entry fs.DirEntry) (bool, error) {
+	if entry.Type().IsRegular() {
+		return true, nil
+	}
+	if !entry.IsDir() {
+		return false, nil
+	}
+
+	return fileExists(filepath.Join(folder, entry.Name(), directoryDumpMarker))
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
@@ -0,0 +1,177 @@
+package executor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+// writeArchive writes the passed files into a folder, as if extracted
+// from an archive. The names ending with a slash are empty folders
+func writeArchive(t *testing.T, files ...string) string {
+	t.Helper()
+
+	folder := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(folder, file)
+		if strings.HasSuffix(file, "/") {
+			if err := os.MkdirAll(path, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("-- dump\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return folder
+}
+
+func TestInspectArchive(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+
+		wantLayout executor.ArchiveLayout
+		wantPath   string
+		wantErr    string
+	}{
+		{
+			name:       "pg_dumpall script",
+			files:      []string{"backup.sql"},
+			wantLayout: executor.LayoutScript,
+			wantPath:   "backup.sql",
+		},
+		{
+			name:       "dump of every database",
+			files:      []string{"backup/globals.sql", "backup/app.dump"},
+			wantLayout: executor.LayoutDatabases,
+			wantPath:   "backup",
+		},
+		{
+			name:       "directory format dump",
+			files:      []string{"app/toc.dat", "app/3001.dat.gz"},
+			wantLayout: executor.LayoutDirectory,
+			wantPath:   "app",
+		},
+		{
+			name:       "data directory",
+			files:      []string{"backup/backup_manifest", "backup/base/PG_VERSION"},
+			wantLayout: executor.LayoutDataDirectory,
+			wantPath:   "backup",
+		},
+		{
+			name:    "empty archive",
+			wantErr: "the archive is empty",
+		},
+		{
+			name:    "more scripts",
+			files:   []string{"app.sql", "globals.sql"},
+			wantErr: "the archive should hold a single script or folder, found: app.sql, globals.sql",
+		},
+		{
+			name:    "unknown file",
+			files:   []string{"backup.tar"},
+			wantErr: "the archive holds backup.tar, which is neither an SQL script nor a folder",
+		},
+		{
+			name:    "unknown folder",
+			files:   []string{"backup/"},
+			wantErr: "the folder backup of the archive holds neither globals.sql, toc.dat nor backup_manifest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := writeArchive(t, tt.files...)
+
+			layout, path, err := executor.InspectArchive(folder)
+			if len(tt.wantErr) > 0 {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if layout != tt.wantLayout || path != filepath.Join(folder, tt.wantPath) {
+				t.Errorf("got %s at %s, want %s at %s", layout, path, tt.wantLayout, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestValidateArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		metadata executor.BackupMetadata
+		wantErr  string
+	}{
+		{
+			name:  "complete archive",
+			files: []string{"backup/globals.sql", "backup/app.dump"},
+			metadata: executor.BackupMetadata{
+				Name:     "backup",
+				Layout:   executor.LayoutDatabases,
+				Contents: []string{"backup/globals.sql", "backup/app.dump"},
+			},
+		},
+		{
+			name:     "archive without a recorded layout",
+			files:    []string{"backup/globals.sql", "backup/app.dump"},
+			metadata: executor.BackupMetadata{Name: "backup", Databases: []string{"app"}},
+		},
+		{
+			name:     "unexpected layout",
+			files:    []string{"backup.sql"},
+			metadata: executor.BackupMetadata{Name: "backup", Databases: []string{"app"}},
+			wantErr:  "the archive of backup backup should hold a dump of every database, but it holds a pg_dumpall script",
+		},
+		{
+			name:  "missing files",
+			files: []string{"backup/globals.sql"},
+			metadata: executor.BackupMetadata{
+				Name:     "backup",
+				Layout:   executor.LayoutDatabases,
+				Contents: []string{"backup/globals.sql", "backup/app.dump", "backup/sales.dump"},
+			},
+			wantErr: "the archive of backup backup is missing: backup/app.dump, backup/sales.dump",
+		},
+		{
+			name:  "file outside of the archive",
+			files: []string{"backup.sql"},
+			metadata: executor.BackupMetadata{
+				Name:     "backup",
+				Contents: []string{"../backup.sql"},
+			},
+			wantErr: `invalid file "../backup.sql" in the metadata of backup backup`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := writeArchive(t, tt.files...)
+
+			err := executor.ValidateArchive(folder, &tt.metadata)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
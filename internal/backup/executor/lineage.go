@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+const lineageFile = "lineage.json"
+
+// ErrLineageConflict is raised when a prefix already holds the backups
+// of another cluster
+var ErrLineageConflict = errors.New("backup prefix belongs to another cluster")
+
+// LineageOwner identifies the cluster owning the backups under a prefix
+type LineageOwner struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// String implements the Stringer interface
+func (owner LineageOwner) String() string {
+	return fmt.Sprintf("%s/%s", owner.Namespace, owner.Name)
+}
+
+// ClaimLineage marks the repository prefix as owned by the passed cluster.
+// If the prefix is already owned by another cluster an error is raised,
+// unless sharing the prefix has been explicitly allowed. The claim is a
+// conditional write, so that only one of the clusters starting together
+// on the same prefix owns it
+func (repo *Repository) ClaimLineage(ctx context.Context, owner LineageOwner, allowShared bool) error {
+	logger := logging.FromContext(ctx)
+
+	current, err := repo.ReadLineage(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		logger.Info("Claiming backup prefix", "prefix", repo.path, "owner", owner.String())
+		err := repo.writeLineage(ctx, owner)
+		if !isPreconditionFailed(err) {
+			if err != nil {
+				return err
+			}
+			repo.owner = &owner
+			return nil
+		}
+
+		// Another cluster claimed the prefix in the meantime
+		if current, err = repo.ReadLineage(ctx); err != nil {
+			return err
+		}
+	}
+
+	if current != nil && *current != owner {
+		if !allowShared {
+			return fmt.Errorf("%w: prefix %q is used by cluster %s, refusing to store backups of %s",
+				ErrLineageConflict, repo.path, current.String(), owner.String())
+		}
+		logger.Info("Sharing backup prefix with another cluster",
+			"prefix", repo.path, "owner", current.String(), "cluster", owner.String())
+	}
+
+	repo.owner = &owner
+	return nil
+}
+
+// ReadLineage returns the cluster owning the repository prefix,
+// or nil when the prefix has not been claimed yet
+func (repo *Repository) ReadLineage(ctx context.Context) (*LineageOwner, error) {
+	key := filepath.Join(repo.path, lineageFile)
+	resp, err := repo.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &key,
+	})
+	if errors.As(err, new(*types.NoSuchKey)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var owner LineageOwner
+	if err := json.Unmarshal(content, &owner); err != nil {
+		return nil, fmt.Errorf("while decoding %s: %w", key, err)
+	}
+
+	return &owner, nil
+}
+
+// writeLineage creates the lineage file, failing with a precondition
+// error when it already exists
+func (repo *Repository) writeLineage(ctx context.Context, owner LineageOwner) error {
+	content, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+
+	key := filepath.Join(repo.path, lineageFile)
+	_, err = repo.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &repo.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	}, s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-None-Match", "*")))
+
+	return err
+}
@@ -0,0 +1,95 @@
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/harness"
+)
+
+func TestClaimLineage(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+
+	rep, err := h.Repository("default", "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := executor.LineageOwner{Namespace: "default", Name: "first"}
+	second := executor.LineageOwner{Namespace: "default", Name: "second"}
+
+	if err := rep.ClaimLineage(ctx, first, false); err != nil {
+		t.Fatalf("claiming a free prefix: %v", err)
+	}
+	if err := rep.ClaimLineage(ctx, first, false); err != nil {
+		t.Fatalf("claiming an owned prefix again: %v", err)
+	}
+	if err := rep.ClaimLineage(ctx, second, false); !errors.Is(err, executor.ErrLineageConflict) {
+		t.Fatalf("claiming a prefix of another cluster: got %v, want %v", err, executor.ErrLineageConflict)
+	}
+	if err := rep.ClaimLineage(ctx, second, true); err != nil {
+		t.Fatalf("sharing a prefix explicitly: %v", err)
+	}
+
+	owner, err := rep.ReadLineage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *owner != first {
+		t.Errorf("owner = %s, want %s", owner, first)
+	}
+}
+
+func TestClaimLineageConcurrently(t *testing.T) {
+	const clusters = 8
+	ctx := context.Background()
+	h := newHarness(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, clusters)
+	for i := range clusters {
+		rep, err := h.Repository("default", "racing")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			owner := executor.LineageOwner{Namespace: "default", Name: fmt.Sprintf("cluster-%d", i)}
+			errs[i] = rep.ClaimLineage(ctx, owner, false)
+		}()
+	}
+	wg.Wait()
+
+	claimed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			claimed++
+		case !errors.Is(err, executor.ErrLineageConflict):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if claimed != 1 {
+		t.Errorf("%d clusters claimed the prefix, want 1", claimed)
+	}
+}
+
+// newHarness starts a harness closed at the end of the test
+func newHarness(t *testing.T) *harness.Harness {
+	t.Helper()
+
+	h, err := harness.New("backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+
+	return h
+}
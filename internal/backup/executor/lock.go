@@ -0,0 +1,274 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+const (
+	lockFile = "backup.lock"
+
+	// lockLease is how long a lock is valid without being renewed. A
+	// sidecar dying while holding the lock blocks the backups for this long
+	lockLease = 2 * time.Minute
+
+	// lockRenewInterval is how often the holder renews the lease
+	lockRenewInterval = 30 * time.Second
+
+	// lockPollInterval is how often a queued backup retries to get the lock
+	lockPollInterval = 10 * time.Second
+)
+
+// ErrBackupInProgress is raised when another backup of the same cluster
+// is holding the lock
+var ErrBackupInProgress = errors.New("another backup is in progress")
+
+// lockContent is the content of the lock object
+type lockContent struct {
+	Holder     string    `json:"holder"`
+	Host       string    `json:"host,omitempty"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Lock is the cluster-wide backup lock, stored as an object in the
+// repository and written with conditional requests, so that only one
+// backup at a time can hold it
+type Lock struct {
+	repo       *Repository
+	key        string
+	holder     string
+	acquiredAt time.Time
+	etag       string
+	cancel     context.CancelCauseFunc
+	done       chan struct{}
+}
+
+// AcquireLock takes the backup lock for the passed holder. When the lock
+// is held by another backup, it waits up to the passed timeout for it to
+// be released, failing with ErrBackupInProgress afterwards. The returned
+// context is canceled if the lease cannot be renewed
+func (repo *Repository) AcquireLock(
+	ctx context.Context,
+	holder string,
+	timeout time.Duration,
+) (*Lock, context.Context, error) {
+	logger := logging.FromContext(ctx)
+
+	lock := &Lock{
+		repo:   repo,
+		key:    filepath.Join(repo.path, lockFile),
+		holder: holder,
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := lock.tryAcquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if current == nil {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, nil, fmt.Errorf("%w: backup %s on %s holds the lock since %s",
+				ErrBackupInProgress, current.Holder, current.Host, current.AcquiredAt.Format(time.RFC3339))
+		}
+
+		logger.Info("Waiting for the running backup to complete",
+			"holder", current.Holder, "host", current.Host, "acquiredAt", current.AcquiredAt)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	logger.Info("Acquired backup lock", "holder", holder)
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	lock.cancel = cancel
+	lock.done = make(chan struct{})
+	go lock.renew(lockCtx)
+
+	return lock, lockCtx, nil
+}
+
+// tryAcquire creates the lock object if it doesn't exist, replacing it
+// when its lease has expired. When the lock is held by someone else, the
+// current content is returned
+func (lock *Lock) tryAcquire(ctx context.Context) (*lockContent, error) {
+	logger := logging.FromContext(ctx)
+
+	lock.acquiredAt = time.Now()
+	err := lock.write(ctx, smithyhttp.AddHeaderValue("If-None-Match", "*"))
+	if err == nil {
+		return nil, nil
+	}
+	if !isPreconditionFailed(err) {
+		return nil, err
+	}
+
+	current, etag, err := lock.read(ctx)
+	if errors.As(err, new(*types.NoSuchKey)) {
+		// Released in the meantime
+		return lock.tryAcquire(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lock.acquiredAt = time.Now()
+	if time.Now().Before(current.ExpiresAt) {
+		return current, nil
+	}
+
+	logger.Info("Taking over an expired backup lock",
+		"holder", current.Holder, "host", current.Host, "expiredAt", current.ExpiresAt)
+	err = lock.write(ctx, smithyhttp.AddHeaderValue("If-Match", etag))
+	if isPreconditionFailed(err) {
+		// Someone else took over the expired lock first
+		return lock.tryAcquire(ctx)
+	}
+
+	return nil, err
+}
+
+// write stores the lock content with a conditional request, keeping
+// track of the resulting entity tag for the following renewals
+func (lock *Lock) write(ctx context.Context, condition func(*middleware.Stack) error) error {
+	host, _ := os.Hostname()
+	content, err := json.Marshal(lockContent{
+		Holder:     lock.holder,
+		Host:       host,
+		AcquiredAt: lock.acquiredAt,
+		ExpiresAt:  time.Now().Add(lockLease),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := lock.repo.client
+	output, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &lock.repo.bucket,
+		Key:         &lock.key,
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	}, s3.WithAPIOptions(condition))
+	if err != nil {
+		return err
+	}
+
+	lock.etag = aws.ToString(output.ETag)
+	return nil
+}
+
+// read returns the current content of the lock and its entity tag
+func (lock *Lock) read(ctx context.Context) (*lockContent, string, error) {
+	client := lock.repo.client
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &lock.repo.bucket,
+		Key:    &lock.key,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var content lockContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, "", fmt.Errorf("while decoding %s: %w", lock.key, err)
+	}
+
+	return &content, aws.ToString(resp.ETag), nil
+}
+
+// renew extends the lease until the lock is released. If the lease cannot
+// be renewed the lock context is canceled, stopping the running backup
+func (lock *Lock) renew(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	defer close(lock.done)
+
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := lock.write(ctx, smithyhttp.AddHeaderValue("If-Match", lock.etag)); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error(err, "while renewing the backup lock, stopping the backup")
+			lock.cancel(fmt.Errorf("lost the backup lock: %w", err))
+			return
+		}
+	}
+}
+
+// Release stops renewing the lease and removes the lock object
+func (lock *Lock) Release(ctx context.Context) error {
+	lock.cancel(nil)
+	<-lock.done
+
+	client := lock.repo.client
+	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &lock.repo.bucket,
+		Key:    &lock.key,
+	}, s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-Match", lock.etag)))
+	if isPreconditionFailed(err) {
+		// The lease expired and someone else owns the lock now
+		return nil
+	}
+
+	return err
+}
+
+// isPreconditionFailed is true when a conditional request was rejected
+func isPreconditionFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+
+	var responseErr *smithyhttp.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.HTTPStatusCode() == http.StatusPreconditionFailed ||
+			responseErr.HTTPStatusCode() == http.StatusConflict
+	}
+
+	return false
+}
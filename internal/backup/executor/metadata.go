@@ -0,0 +1,324 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// BackupType is the kind of backup stored in the repository
+type BackupType string
+
+const (
+	// BackupTypeLogical is a pg_dumpall of the whole cluster
+	BackupTypeLogical BackupType = "logical"
+
+	// BackupTypeFull is a physical base backup taken with pg_basebackup
+	BackupTypeFull BackupType = "full"
+
+	// BackupTypeIncremental is a physical PostgreSQL 17 incremental backup
+	// depending on the backup manifest of its parent
+	BackupTypeIncremental BackupType = "incremental"
+)
+
+const (
+	metadataFile = "backup.json"
+	manifestFile = "backup_manifest"
+)
+
+// ErrMetadataNotFound is raised when a backup has no metadata in the repository
+var ErrMetadataNotFound = errors.New("backup metadata not found")
+
+// ParseBackupType parses a backup type, defaulting to a logical backup
+func ParseBackupType(value string) (BackupType, error) {
+	switch BackupType(value) {
+	case "", BackupTypeLogical:
+		return BackupTypeLogical, nil
+	case BackupTypeFull, BackupTypeIncremental:
+		return BackupType(value), nil
+	}
+
+	return "", fmt.Errorf("unknown backup type %q, expected one of: %s, %s, %s",
+		value, BackupTypeLogical, BackupTypeFull, BackupTypeIncremental)
+}
+
+// IsPhysical is true when the backup is a copy of the data directory
+func (t BackupType) IsPhysical() bool {
+	return t == BackupTypeFull || t == BackupTypeIncremental
+}
+
+// Binaries are the PostgreSQL client binaries taking and restoring the
+// backups of this type. psql queries the instance before every backup
+func (t BackupType) Binaries() []string {
+	if t.IsPhysical() {
+		return []string{PGBaseBackup, PGCombineBackup, Psql}
+	}
+	return []string{PGDumpall, PGDump, PGRestore, Psql}
+}
+
+// RequiredBinaries are the binaries of every type of backup, which the
+// sidecar needs since any type can be requested
+func RequiredBinaries() []string {
+	var result []string
+	for _, backupType := range []BackupType{BackupTypeLogical, BackupTypeFull, BackupTypeIncremental} {
+		for _, binary := range backupType.Binaries() {
+			if !slices.Contains(result, binary) {
+				result = append(result, binary)
+			}
+		}
+	}
+
+	return result
+}
+
+// BackupMetadata describes a backup stored in the repository
+type BackupMetadata struct {
+	// Name is the name of the backup inside the repository
+	Name string `json:"name"`
+
+	// ClusterName and Namespace identify the cluster the backup was taken from
+	ClusterName string `json:"clusterName,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+
+	// Type is the kind of backup
+	Type BackupType `json:"type"`
+
+	// Parent is the name of the backup an incremental backup depends on
+	Parent string `json:"parent,omitempty"`
+
+	// Archive is the object key of the backup archive
+	Archive string `json:"archive"`
+
+	// Manifest is the object key of the PostgreSQL backup manifest,
+	// only set for physical backups
+	Manifest string `json:"manifest,omitempty"`
+
+	// Databases are the databases dumped on their own by a logical
+	// backup, empty when the backup is a single pg_dumpall script
+	Databases []string `json:"databases,omitempty"`
+
+	// Layout is what the archive contains, and decides how it is restored
+	Layout ArchiveLayout `json:"layout,omitempty"`
+
+	// Contents is the manifest of the archive of a logical backup: the
+	// files it holds, checked before restoring
+	Contents []string `json:"contents,omitempty"`
+
+	BeginLSN  string    `json:"beginLSN,omitempty"`
+	EndLSN    string    `json:"endLSN,omitempty"`
+	BeginWal  string    `json:"beginWal,omitempty"`
+	EndWal    string    `json:"endWal,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	StoppedAt time.Time `json:"stoppedAt,omitempty"`
+
+	// PostgresVersion and PluginVersion are the versions
+	// the backup has been taken with
+	PostgresVersion string `json:"postgresVersion,omitempty"`
+	PluginVersion   string `json:"pluginVersion,omitempty"`
+
+	// Tags are the tags of the objects of the backup
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Hooks are the outcomes of the hooks run around the snapshot
+	Hooks []HookResult `json:"hooks,omitempty"`
+
+	// StorageClass is the storage class of the archive, the default
+	// class of the bucket when empty
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// LockMode and RetainUntil are the object lock retention of the
+	// objects of the backup, which cannot be deleted before
+	LockMode    string     `json:"lockMode,omitempty"`
+	RetainUntil *time.Time `json:"retainUntil,omitempty"`
+
+	// LegalHold is true when the objects have been uploaded under legal hold
+	LegalHold bool `json:"legalHold,omitempty"`
+}
+
+// backupKey is the object key of a file belonging to a backup. Every
+// backup is stored under its own folder, named after the backup
+func (repo *Repository) backupKey(name string, file string) string {
+	return filepath.Join(repo.path, name, file)
+}
+
+// metadataKey is the object key of the metadata of a backup
+func (repo *Repository) metadataKey(name string) string {
+	return repo.backupKey(name, metadataFile)
+}
+
+// WriteMetadata stores the metadata of a backup in the repository
+func (repo *Repository) WriteMetadata(ctx context.Context, metadata *BackupMetadata) error {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	client := repo.client
+	input := &s3.PutObjectInput{
+		Bucket:      &repo.bucket,
+		Key:         aws.String(repo.metadataKey(metadata.Name)),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+		Metadata:    objectMetadata(metadata),
+		Tagging:     objectTagging(metadata),
+	}
+	repo.lockObject(input)
+
+	_, err = client.PutObject(ctx, input)
+	return err
+}
+
+// ReadMetadata reads the metadata of a backup from the repository,
+// completed with the user metadata of its object
+func (repo *Repository) ReadMetadata(ctx context.Context, name string) (*BackupMetadata, error) {
+	client := repo.client
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &repo.bucket,
+		Key:    aws.String(repo.metadataKey(name)),
+	})
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, fmt.Errorf("%w: %s", ErrMetadataNotFound, name)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata BackupMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return nil, fmt.Errorf("while decoding metadata of backup %s: %w", name, err)
+	}
+	if err := applyObjectMetadata(&metadata, resp.Metadata); err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+// ListBackups returns the metadata of every backup in the repository,
+// sorted from the oldest to the newest
+func (repo *Repository) ListBackups(ctx context.Context) ([]*BackupMetadata, error) {
+	client := repo.client
+	prefix := repo.path
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var result []*BackupMetadata
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    &repo.bucket,
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, folder := range page.CommonPrefixes {
+			name := filepath.Base(aws.ToString(folder.Prefix))
+			metadata, err := repo.ReadMetadata(ctx, name)
+			if errors.Is(err, ErrMetadataNotFound) {
+				// Backups still running or which failed
+				// have no metadata
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, metadata)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+
+	return result, nil
+}
+
+// listOwnBackups lists the backups of the cluster owning the repository.
+// When the prefix is shared, the backups of other clusters are skipped,
+// as told by the cluster and namespace of their object metadata
+func (repo *Repository) listOwnBackups(ctx context.Context) ([]*BackupMetadata, error) {
+	backups, err := repo.ListBackups(ctx)
+	if err != nil || repo.owner == nil {
+		return backups, err
+	}
+
+	result := make([]*BackupMetadata, 0, len(backups))
+	for _, item := range backups {
+		if item.Namespace == repo.owner.Namespace && item.ClusterName == repo.owner.Name {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// latestPhysicalBackup finds the most recent completed physical backup,
+// which is the parent of the next incremental backup
+func (repo *Repository) latestPhysicalBackup(ctx context.Context) (*BackupMetadata, error) {
+	backups, err := repo.listOwnBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Type.IsPhysical() && len(backups[i].Manifest) > 0 {
+			return backups[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// resolveChain returns the backups needed to restore the passed one,
+// starting from the full backup and ending with the passed backup
+func (repo *Repository) resolveChain(ctx context.Context, metadata *BackupMetadata) ([]*BackupMetadata, error) {
+	chain := []*BackupMetadata{metadata}
+	visited := map[string]bool{metadata.Name: true}
+
+	current := metadata
+	for current.Type == BackupTypeIncremental {
+		if len(current.Parent) == 0 {
+			return nil, fmt.Errorf("incremental backup %s has no parent", current.Name)
+		}
+		if visited[current.Parent] {
+			return nil, fmt.Errorf("backup chain of %s contains a cycle at %s", metadata.Name, current.Parent)
+		}
+		visited[current.Parent] = true
+
+		parent, err := repo.ReadMetadata(ctx, current.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("while resolving parent of backup %s: %w", current.Name, err)
+		}
+		if !parent.Type.IsPhysical() {
+			return nil, fmt.Errorf("parent %s of backup %s is not a physical backup", parent.Name, current.Name)
+		}
+
+		chain = append([]*BackupMetadata{parent}, chain...)
+		current = parent
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrObjectLockNotEnabled is raised when object lock is requested on a
+// bucket which has not been created with object lock enabled
+var ErrObjectLockNotEnabled = errors.New("object lock is not enabled on the bucket")
+
+// ObjectLock makes the objects of the backups immutable, so that they
+// cannot be deleted, even with the credentials of the cluster
+type ObjectLock struct {
+	// Mode is the retention mode, GOVERNANCE or COMPLIANCE. When empty
+	// no retention is set
+	Mode types.ObjectLockMode
+
+	// RetainUntil is when the retention of the objects expires
+	RetainUntil time.Time
+
+	// LegalHold keeps the objects until the hold is removed by hand
+	LegalHold bool
+}
+
+// enabled is true when the objects need to be locked
+func (lock ObjectLock) enabled() bool {
+	return len(lock.Mode) > 0 || lock.LegalHold
+}
+
+// SetObjectLock sets the lock of the objects of the following backups
+func (repo *Repository) SetObjectLock(lock ObjectLock) {
+	repo.objectLock = lock
+}
+
+// CheckObjectLock ensures the bucket supports the requested object lock,
+// failing before taking a backup which could not be protected
+func (repo *Repository) CheckObjectLock(ctx context.Context) error {
+	if !repo.objectLock.enabled() {
+		return nil
+	}
+
+	output, err := repo.client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: &repo.bucket,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+		return fmt.Errorf("%w: %s", ErrObjectLockNotEnabled, repo.bucket)
+	}
+	if err != nil {
+		return err
+	}
+
+	if output.ObjectLockConfiguration == nil ||
+		output.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("%w: %s", ErrObjectLockNotEnabled, repo.bucket)
+	}
+
+	return nil
+}
+
+// lockObject adds the object lock to the upload of an object of a backup.
+// Locked uploads need a checksum of their content
+func (repo *Repository) lockObject(input *s3.PutObjectInput) {
+	lock := repo.objectLock
+	if !lock.enabled() {
+		return
+	}
+
+	input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	if len(lock.Mode) > 0 {
+		input.ObjectLockMode = lock.Mode
+		input.ObjectLockRetainUntilDate = &lock.RetainUntil
+	}
+	if lock.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+}
+
+// recordObjectLock stores the lock of a backup in its metadata
+func (repo *Repository) recordObjectLock(metadata *BackupMetadata) {
+	lock := repo.objectLock
+	if len(lock.Mode) > 0 {
+		metadata.LockMode = string(lock.Mode)
+		metadata.RetainUntil = &lock.RetainUntil
+	}
+	metadata.LegalHold = lock.LegalHold
+}
+
+// isLocked is true when the objects of a backup cannot be deleted yet.
+// A legal hold can be removed by hand, so its current status is checked
+func (repo *Repository) isLocked(ctx context.Context, metadata *BackupMetadata, now time.Time) (bool, error) {
+	if metadata.RetainUntil != nil && metadata.RetainUntil.After(now) {
+		return true, nil
+	}
+
+	if !metadata.LegalHold {
+		return false, nil
+	}
+
+	output, err := repo.client.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{
+		Bucket: &repo.bucket,
+		Key:    &metadata.Archive,
+	})
+	if err != nil {
+		return false, fmt.Errorf("while reading the legal hold of %s: %w", metadata.Name, err)
+	}
+
+	return output.LegalHold != nil && output.LegalHold.Status == types.ObjectLockLegalHoldStatusOn, nil
+}
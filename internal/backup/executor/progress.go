@@ -0,0 +1,286 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+// progressLogInterval is how often the progress of a running backup is logged
+const progressLogInterval = 30 * time.Second
+
+// Phase is the step a backup is running
+type Phase string
+
+const (
+	// PhaseIdle means no backup has been taken since the sidecar started
+	PhaseIdle Phase = "idle"
+
+	// PhaseDumping means PostgreSQL is being copied into the working directory
+	PhaseDumping Phase = "dumping"
+
+	// PhaseArchiving means the copy is being archived
+	PhaseArchiving Phase = "archiving"
+
+	// PhaseUploading means the archive is being uploaded into the repository
+	PhaseUploading Phase = "uploading"
+
+	// PhaseCompleted means the last backup completed
+	PhaseCompleted Phase = "completed"
+
+	// PhaseFailed means the last backup failed
+	PhaseFailed Phase = "failed"
+)
+
+// Progress is the status of the backup running in the sidecar, or of the
+// last one taken when none is running
+type Progress struct {
+	Backup string     `json:"backup,omitempty"`
+	Type   BackupType `json:"type,omitempty"`
+	Phase  Phase      `json:"phase"`
+	Error  string     `json:"error,omitempty"`
+
+	// EstimatedBytes is the size of the databases, which the
+	// size of the copy is expected to be close to
+	EstimatedBytes int64 `json:"estimatedBytes,omitempty"`
+
+	// ProducedBytes is the size of the copy in the working directory
+	ProducedBytes int64 `json:"producedBytes,omitempty"`
+
+	// UploadBytes is the size of the files to be uploaded, known
+	// once the archive has been created
+	UploadBytes   int64 `json:"uploadBytes,omitempty"`
+	UploadedBytes int64 `json:"uploadedBytes,omitempty"`
+
+	// Percent is the estimated completion of the current phase
+	Percent float64 `json:"percent"`
+
+	// Throughput is the rate, in bytes per second, of the current phase
+	Throughput float64 `json:"throughput"`
+
+	StartedAt      *time.Time `json:"startedAt,omitempty"`
+	PhaseStartedAt *time.Time `json:"phaseStartedAt,omitempty"`
+	StoppedAt      *time.Time `json:"stoppedAt,omitempty"`
+}
+
+// ProgressTracker tracks the progress of the backups of the sidecar.
+// The backup lock ensures only one of them runs at a time
+type ProgressTracker struct {
+	mu       sync.Mutex
+	progress Progress
+
+	// producedPath is the file or directory the copy is written into
+	producedPath string
+
+	// uploadedBase is the size of the files already uploaded
+	uploadedBase int64
+}
+
+// NewProgressTracker creates a tracker with no backup running
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{progress: Progress{Phase: PhaseIdle}}
+}
+
+// Progress returns the progress of the running backup
+func (t *ProgressTracker) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.progress.Phase == PhaseDumping {
+		t.progress.ProducedBytes = pathSize(t.producedPath)
+	}
+
+	result := t.progress
+	var done, total int64
+	switch result.Phase {
+	case PhaseDumping:
+		done, total = result.ProducedBytes, result.EstimatedBytes
+	case PhaseUploading:
+		done, total = result.UploadedBytes, result.UploadBytes
+	case PhaseCompleted:
+		result.Percent = 100
+	}
+
+	if total > 0 {
+		// The estimate is not exact, never report a phase as completed
+		// before it is
+		result.Percent = min(float64(done)*100/float64(total), 99)
+	}
+	if result.PhaseStartedAt != nil {
+		if elapsed := time.Since(*result.PhaseStartedAt).Seconds(); elapsed > 0 {
+			result.Throughput = float64(done) / elapsed
+		}
+	}
+
+	return result
+}
+
+func (t *ProgressTracker) start(name string, backupType BackupType) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.progress = Progress{
+		Backup:    name,
+		Type:      backupType,
+		Phase:     PhaseIdle,
+		StartedAt: &now,
+	}
+	t.producedPath = ""
+	t.uploadedBase = 0
+}
+
+func (t *ProgressTracker) setEstimate(estimated int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress.EstimatedBytes = estimated
+}
+
+func (t *ProgressTracker) setPhase(phase Phase) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.progress.Phase == PhaseDumping {
+		t.progress.ProducedBytes = pathSize(t.producedPath)
+	}
+
+	now := time.Now()
+	t.progress.Phase = phase
+	t.progress.PhaseStartedAt = &now
+}
+
+// dumping starts the phase copying PostgreSQL into the passed path
+func (t *ProgressTracker) dumping(path string) {
+	t.setPhase(PhaseDumping)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.producedPath = path
+}
+
+// uploading starts the phase uploading the passed files
+func (t *ProgressTracker) uploading(files ...string) {
+	t.setPhase(PhaseUploading)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, file := range files {
+		t.progress.UploadBytes += pathSize(file)
+	}
+}
+
+// setUploaded records the position reached uploading the current file
+func (t *ProgressTracker) setUploaded(position int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress.UploadedBytes = t.uploadedBase + position
+}
+
+// uploaded records the current file as completely uploaded
+func (t *ProgressTracker) uploaded(size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.uploadedBase += size
+	t.progress.UploadedBytes = t.uploadedBase
+}
+
+func (t *ProgressTracker) stop(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.progress.StoppedAt = &now
+	t.progress.PhaseStartedAt = nil
+	if err != nil {
+		t.progress.Phase = PhaseFailed
+		t.progress.Error = err.Error()
+		return
+	}
+	t.progress.Phase = PhaseCompleted
+}
+
+// logProgress logs the progress of the running backup until the context
+// is canceled
+func (t *ProgressTracker) logProgress(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	ticker := time.NewTicker(progressLogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		progress := t.Progress()
+		logger.Info("Backup progress",
+			"backup", progress.Backup,
+			"phase", progress.Phase,
+			"percent", int(progress.Percent),
+			"estimatedBytes", progress.EstimatedBytes,
+			"producedBytes", progress.ProducedBytes,
+			"uploadBytes", progress.UploadBytes,
+			"uploadedBytes", progress.UploadedBytes,
+			"throughput", int64(progress.Throughput))
+	}
+}
+
+// progressReader counts the bytes of a file read by the uploader. Seeking
+// is tracked too, as the uploader rewinds the file to retry a request.
+// The file is not embedded, so that its WriteTo method cannot bypass
+// the counting
+type progressReader struct {
+	file     *os.File
+	tracker  *ProgressTracker
+	position int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.position += int64(n)
+	r.tracker.setUploaded(r.position)
+	return n, err
+}
+
+func (r *progressReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.file.Seek(offset, whence)
+	if err == nil {
+		r.position = position
+		r.tracker.setUploaded(position)
+	}
+	return position, err
+}
+
+var _ io.ReadSeeker = &progressReader{}
+
+// pathSize returns the size of a file, or of the files in a directory
+func pathSize(path string) int64 {
+	if len(path) == 0 {
+		return 0
+	}
+
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The copy is being written, files can disappear
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IsPrimary is true when the local instance is not in recovery,
+// that is when it is the primary of the cluster
+func (repo *Repository) IsPrimary(ctx context.Context) (bool, error) {
+	output, err := repo.env.executeQuery(ctx, "SELECT pg_is_in_recovery()")
+	if err != nil {
+		return false, err
+	}
+
+	return output == "f", nil
+}
+
+// ErrWalSummarizationDisabled is raised when an incremental backup is
+// requested from an instance which doesn't summarize its WAL
+var ErrWalSummarizationDisabled = errors.New("incremental backups need WAL summarization, " +
+	"set summarize_wal to \"on\" in the postgresql parameters of the cluster")
+
+// checkWalSummarization fails unless the local instance summarizes its
+// WAL, which pg_basebackup needs to take incremental backups
+func (env Environment) checkWalSummarization(ctx context.Context) error {
+	output, err := env.executeQuery(ctx, "SHOW summarize_wal")
+	if err != nil {
+		return fmt.Errorf("while checking summarize_wal: %w", err)
+	}
+	if output != "on" {
+		return fmt.Errorf("%w, it is %q", ErrWalSummarizationDisabled, output)
+	}
+
+	return nil
+}
+
+// executeQuery runs a query on the local instance with psql
+// returning its unaligned output
+func (env Environment) executeQuery(ctx context.Context, query string) (string, error) {
+	return env.query(ctx, Connection{DBName: "postgres"}, query)
+}
+
+// quoteIdentifier quotes a name to be used as an SQL identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a value to be used as an SQL string literal
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
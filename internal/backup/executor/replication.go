@@ -0,0 +1,399 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+const replicationFile = "replication.json"
+
+// streamConcurrency is how many parts of an object streamed to a replica
+// are uploaded at the same time, each of them being buffered in memory
+const streamConcurrency = 2
+
+// streamPartSize is the smallest part of an object streamed to a replica
+var streamPartSize int64 = 64 * 1024 * 1024
+
+// replica is a repository the backups are copied to
+type replica struct {
+	name string
+	repo *Repository
+
+	// serverSideCopy is true when the replica is on the same provider
+	// as the repository, so that objects can be copied without being
+	// downloaded
+	serverSideCopy bool
+}
+
+// AddReplica adds a repository the backups are copied to by Replicate.
+// Server side copies need the replica to be on the same provider and
+// its credentials to be allowed to read the repository
+func (repo *Repository) AddReplica(name string, replicaRepo *Repository, serverSideCopy bool) {
+	repo.replicas = append(repo.replicas, replica{
+		name:           name,
+		repo:           replicaRepo,
+		serverSideCopy: serverSideCopy,
+	})
+}
+
+// ReplicationStatus is the state of the replicas of the repository,
+// stored in the catalog beside the backups
+type ReplicationStatus struct {
+	// UpdatedAt is when the replicas have been last checked
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Replicas []ReplicaStatus `json:"replicas"`
+}
+
+// ReplicaStatus is the state of a single replica
+type ReplicaStatus struct {
+	// Name identifies the replica
+	Name string `json:"name"`
+
+	// LastBackup is the most recent backup available on the replica
+	LastBackup string `json:"lastBackup,omitempty"`
+
+	// LagSeconds is how much older the most recent backup of the
+	// replica is than the most recent backup of the repository
+	LagSeconds int64 `json:"lagSeconds"`
+
+	// Replicated are the backups copied by the last replication
+	Replicated []string `json:"replicated,omitempty"`
+
+	// Missing are the backups which could not be copied yet
+	Missing []string `json:"missing,omitempty"`
+
+	// Mismatched are the backups whose archive on the replica
+	// differs in size from the one in the repository
+	Mismatched []string `json:"mismatched,omitempty"`
+
+	// Error is the error of the last replication, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// Replicate copies the backups missing from every replica, then compares
+// the replicas with the repository and stores the outcome in the catalog.
+// Backups removed from the repository by the retention policy are left
+// on the replicas. A failing replica doesn't stop the others
+func (repo *Repository) Replicate(ctx context.Context) (*ReplicationStatus, error) {
+	if len(repo.replicas) == 0 {
+		return nil, nil
+	}
+
+	backups, err := repo.listOwnBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ReplicationStatus{UpdatedAt: time.Now()}
+	var errs []error
+	for _, target := range repo.replicas {
+		replicaStatus, err := repo.replicateTo(ctx, target, backups)
+		if err != nil {
+			replicaStatus.Error = err.Error()
+			errs = append(errs, fmt.Errorf("replica %s: %w", target.name, err))
+		}
+		status.Replicas = append(status.Replicas, replicaStatus)
+	}
+
+	if err := repo.writeReplicationStatus(ctx, status); err != nil {
+		errs = append(errs, err)
+	}
+
+	return status, errors.Join(errs...)
+}
+
+// replicateTo copies the missing backups to a replica, from the oldest
+// to the newest, and checks the ones already there
+func (repo *Repository) replicateTo(
+	ctx context.Context,
+	target replica,
+	backups []*BackupMetadata,
+) (ReplicaStatus, error) {
+	logger := logging.FromContext(ctx).WithValues("replica", target.name)
+
+	status := ReplicaStatus{Name: target.name}
+	existing, err := target.repo.ListBackups(ctx)
+	if err != nil {
+		status.Missing = backupNames(backups)
+		return status, err
+	}
+
+	replicated := make(map[string]*BackupMetadata, len(existing))
+	for _, item := range existing {
+		replicated[item.Name] = item
+	}
+
+	var replicationErr error
+	for _, item := range backups {
+		copied, found := replicated[item.Name]
+		if found {
+			if matches, err := repo.sameArchive(ctx, item, target.repo, copied); err != nil {
+				return status, err
+			} else if !matches {
+				status.Mismatched = append(status.Mismatched, item.Name)
+			}
+			continue
+		}
+
+		// Once a copy fails the following ones are only reported,
+		// they will be retried together by the next backup
+		if replicationErr != nil {
+			status.Missing = append(status.Missing, item.Name)
+			continue
+		}
+
+		logger.Info("Replicating backup", "name", item.Name)
+		copied, err := repo.copyBackup(ctx, target, item)
+		if err != nil {
+			replicationErr = fmt.Errorf("while replicating %s: %w", item.Name, err)
+			status.Missing = append(status.Missing, item.Name)
+			continue
+		}
+		replicated[item.Name] = copied
+		status.Replicated = append(status.Replicated, item.Name)
+	}
+
+	if len(backups) > 0 {
+		latest := backups[len(backups)-1]
+		var lastReplicated *BackupMetadata
+		for _, item := range backups {
+			if copied := replicated[item.Name]; copied != nil {
+				lastReplicated = item
+			}
+		}
+
+		if lastReplicated == nil {
+			status.LagSeconds = int64(time.Since(backups[0].StoppedAt).Seconds())
+		} else {
+			status.LastBackup = lastReplicated.Name
+			status.LagSeconds = int64(latest.StoppedAt.Sub(lastReplicated.StoppedAt).Seconds())
+		}
+	}
+
+	return status, replicationErr
+}
+
+// copyBackup copies the objects of a backup to a replica, the metadata
+// last so that the backup only appears on the replica once complete
+func (repo *Repository) copyBackup(
+	ctx context.Context,
+	target replica,
+	metadata *BackupMetadata,
+) (*BackupMetadata, error) {
+	if isArchiveClass(types.StorageClass(metadata.StorageClass)) {
+		return nil, fmt.Errorf("the archive is in the %s storage class", metadata.StorageClass)
+	}
+
+	copied := *metadata
+	copied.Archive = target.repo.backupKey(metadata.Name, path.Base(metadata.Archive))
+	if err := repo.copyObject(ctx, target, metadata, metadata.Archive, copied.Archive,
+		types.StorageClass(metadata.StorageClass)); err != nil {
+		return nil, err
+	}
+
+	if len(metadata.Manifest) > 0 {
+		copied.Manifest = target.repo.backupKey(metadata.Name, path.Base(metadata.Manifest))
+		if err := repo.copyObject(ctx, target, metadata, metadata.Manifest, copied.Manifest, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// The object lock of the repository doesn't apply to the replica
+	copied.LockMode = ""
+	copied.RetainUntil = nil
+	copied.LegalHold = false
+	if err := target.repo.WriteMetadata(ctx, &copied); err != nil {
+		return nil, err
+	}
+
+	return &copied, nil
+}
+
+// copyObject copies an object of a backup to a replica, server side when
+// possible and otherwise streaming it through the sidecar. Objects larger
+// than what a single request can write are copied in parts
+func (repo *Repository) copyObject(
+	ctx context.Context,
+	target replica,
+	metadata *BackupMetadata,
+	sourceKey string,
+	key string,
+	class types.StorageClass,
+) error {
+	if target.serverSideCopy {
+		err := repo.copyServerSide(ctx, target, metadata, sourceKey, key, class)
+		if err == nil {
+			return nil
+		}
+
+		// The credentials of the replica may not be allowed to read
+		// the repository, in which case the object is streamed
+		logging.FromContext(ctx).Info("Server side copy failed, streaming the object",
+			"key", sourceKey, "replica", target.name, "error", err.Error())
+	}
+
+	resp, err := repo.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &sourceKey,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The body is uploaded in parts buffered in memory, sized so that
+	// the largest objects fit in the number of parts of an upload
+	partSize := max(streamPartSize, aws.ToInt64(resp.ContentLength)/int64(manager.MaxUploadParts)+1)
+	uploader := manager.NewUploader(target.repo.client, func(u *manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = streamConcurrency
+	})
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:       &target.repo.bucket,
+		Key:          &key,
+		Body:         newThrottledReader(ctx, resp.Body, repo.bandwidthLimiter),
+		ContentType:  resp.ContentType,
+		Metadata:     resp.Metadata,
+		Tagging:      objectTagging(metadata),
+		StorageClass: class,
+	})
+	return err
+}
+
+// copyServerSide copies an object of a backup to a replica on the same
+// provider without downloading it. Single copies keep the metadata and
+// the tags of the object, which multipart copies need to be given
+func (repo *Repository) copyServerSide(
+	ctx context.Context,
+	target replica,
+	metadata *BackupMetadata,
+	sourceKey string,
+	key string,
+	class types.StorageClass,
+) error {
+	head, err := repo.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &sourceKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	source := url.PathEscape(repo.bucket + "/" + sourceKey)
+	if size := aws.ToInt64(head.ContentLength); size > maxCopyObjectSize {
+		return target.repo.copyInParts(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:       &target.repo.bucket,
+			Key:          &key,
+			ContentType:  head.ContentType,
+			Metadata:     head.Metadata,
+			Tagging:      objectTagging(metadata),
+			StorageClass: class,
+		}, source, size)
+	}
+
+	_, err = target.repo.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:       &target.repo.bucket,
+		Key:          &key,
+		CopySource:   &source,
+		StorageClass: class,
+	})
+	return err
+}
+
+// sameArchive compares the size of the archive of a backup with the one
+// of its copy on a replica
+func (repo *Repository) sameArchive(
+	ctx context.Context,
+	metadata *BackupMetadata,
+	replicaRepo *Repository,
+	copied *BackupMetadata,
+) (bool, error) {
+	original, err := repo.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &metadata.Archive,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	replicated, err := replicaRepo.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &replicaRepo.bucket,
+		Key:    &copied.Archive,
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return aws.ToInt64(original.ContentLength) == aws.ToInt64(replicated.ContentLength), nil
+}
+
+// ReadReplicationStatus reads the state of the replicas from the catalog,
+// returning nil when the backups have never been replicated
+func (repo *Repository) ReadReplicationStatus(ctx context.Context) (*ReplicationStatus, error) {
+	resp, err := repo.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &repo.bucket,
+		Key:    aws.String(filepath.Join(repo.path, replicationFile)),
+	})
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var status ReplicationStatus
+	if err := json.Unmarshal(content, &status); err != nil {
+		return nil, fmt.Errorf("while decoding the replication status: %w", err)
+	}
+
+	return &status, nil
+}
+
+func (repo *Repository) writeReplicationStatus(ctx context.Context, status *ReplicationStatus) error {
+	content, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &repo.bucket,
+		Key:         aws.String(filepath.Join(repo.path, replicationFile)),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func backupNames(backups []*BackupMetadata) []string {
+	names := make([]string, 0, len(backups))
+	for _, item := range backups {
+		names = append(names, item.Name)
+	}
+	return names
+}
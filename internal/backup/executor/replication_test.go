@@ -0,0 +1,84 @@
+package executor_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestReplicateLargeArchive(t *testing.T) {
+	const partSize = manager.MinUploadPartSize
+
+	tests := []struct {
+		name           string
+		serverSideCopy bool
+		size           int64
+		wantParts      int
+	}{
+		{name: "streamed in parts", size: 2*partSize + 1024, wantParts: 3},
+		{name: "streamed at once", size: 1024, wantParts: 0},
+		{name: "copied server side in parts", serverSideCopy: true, size: 2*partSize + 1024, wantParts: 3},
+		{name: "copied server side at once", serverSideCopy: true, size: 1024, wantParts: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+			h.S3.CreateBucket("replica")
+			t.Cleanup(executor.SetCopyLimits(2*partSize, partSize))
+
+			options := h.Options("default", "cluster-example")
+			name, err := h.Backup(ctx, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+			metadata, err := rep.ReadMetadata(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The archive is replaced by one larger than a part
+			archive := make([]byte, tt.size)
+			if _, err := rand.Read(archive); err != nil {
+				t.Fatal(err)
+			}
+			h.S3.Put(h.Bucket, metadata.Archive, archive)
+
+			replica, err := executor.NewRepositoryWithDependencies("replica", options.Prefix, "gzip", h.Dependencies())
+			if err != nil {
+				t.Fatal(err)
+			}
+			rep.AddReplica("replica", replica, tt.serverSideCopy)
+
+			status, err := rep.Replicate(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(status.Replicas) != 1 || status.Replicas[0].LastBackup != name {
+				t.Fatalf("unexpected replication status %+v", status)
+			}
+
+			copied := h.S3.Get("replica", metadata.Archive)
+			if copied == nil {
+				t.Fatal("the archive has not been replicated")
+			}
+			if !bytes.Equal(copied.Data, archive) {
+				t.Errorf("the replicated archive differs, %d bytes instead of %d", len(copied.Data), len(archive))
+			}
+			if copied.Parts != tt.wantParts {
+				t.Errorf("the archive has been replicated in %d parts, want %d", copied.Parts, tt.wantParts)
+			}
+		})
+	}
+}
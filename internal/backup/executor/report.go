@@ -0,0 +1,256 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+// maxReportedErrors is how many errors a restore report holds. Restores
+// continuing on errors can raise one for every statement of the dump
+const maxReportedErrors = 100
+
+var (
+	// psqlError matches an error of psql running a script, like
+	// "psql:<stdin>:12: ERROR:  42P07: relation "orders" already exists"
+	psqlError = regexp.MustCompile(`^psql:.*?:(\d+): (?:ERROR|FATAL|PANIC):  (?:([0-9A-Z]{5}): )?(.*)$`)
+
+	// psqlStatement matches the statement psql echoes after an error
+	psqlStatement = regexp.MustCompile(`^psql:.*?:\d+: STATEMENT:  (.*)$`)
+
+	// psqlFailure matches an error of psql not related to a statement,
+	// like a failed connection
+	psqlFailure = regexp.MustCompile(`^psql: error: (.*)$`)
+
+	// pgRestoreEntry matches the entry of the dump pg_restore
+	// announces before the error it raised
+	pgRestoreEntry = regexp.MustCompile(`^pg_restore: from TOC entry (.*)$`)
+
+	// pgRestoreError matches an error of pg_restore, possibly raised
+	// by a statement
+	pgRestoreError = regexp.MustCompile(
+		`^pg_restore: (?:error: |\[archiver \(db\)\] )` +
+			`(?:could not execute query: (?:ERROR|FATAL|PANIC):  (?:([0-9A-Z]{5}): )?)?(.*)$`)
+)
+
+// RestoreReport is the outcome of the restore of a logical backup
+type RestoreReport struct {
+	// Backup is the name of the backup, or the local file, restored
+	Backup string `json:"backup"`
+
+	// Errors are the first errors raised while restoring
+	Errors []RestoreError `json:"errors,omitempty"`
+
+	// ErrorCount is the number of errors raised, including
+	// the ones left out of the report
+	ErrorCount int `json:"errorCount"`
+}
+
+// RestoreError is an error raised by psql or pg_restore while restoring
+type RestoreError struct {
+	// Source is the dump being restored, such as the pg_dumpall script
+	// or the dump of a database
+	Source string `json:"source"`
+
+	// Line is the line of the pg_dumpall script which failed
+	Line int `json:"line,omitempty"`
+
+	// Entry is the entry of the dump which failed, as listed by pg_restore
+	Entry string `json:"entry,omitempty"`
+
+	// Code is the SQLSTATE of the error, when known
+	Code string `json:"code,omitempty"`
+
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+
+	// Statement is the statement which failed
+	Statement string `json:"statement,omitempty"`
+}
+
+// Error describes where the restore failed
+func (restoreError *RestoreError) Error() string {
+	var location []string
+	if restoreError.Line > 0 {
+		location = append(location, "line "+strconv.Itoa(restoreError.Line))
+	}
+	if len(restoreError.Entry) > 0 {
+		location = append(location, "entry "+restoreError.Entry)
+	}
+	if len(restoreError.Code) > 0 {
+		location = append(location, restoreError.Code)
+	}
+
+	if len(location) == 0 {
+		return restoreError.Message
+	}
+	return fmt.Sprintf("%s (%s)", restoreError.Message, strings.Join(location, ", "))
+}
+
+func (report *RestoreReport) add(restoreError RestoreError) {
+	report.ErrorCount++
+	if len(report.Errors) < maxReportedErrors {
+		report.Errors = append(report.Errors, restoreError)
+	}
+}
+
+// runRestoreCommand runs psql or pg_restore, adding the errors they print
+// to the report. When continueOnError is set, the failures caused by the
+// errors of the statements are tolerated, since they are in the report
+func runRestoreCommand(
+	ctx context.Context,
+	report *RestoreReport,
+	source string,
+	input io.Reader,
+	continueOnError bool,
+	command string,
+	args ...string,
+) error {
+	logger := logging.FromContext(ctx)
+
+	parser := &restoreErrorParser{report: report, source: source}
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = input
+	cmd.Stderr = parser
+
+	errorCount := report.ErrorCount
+	err := cmd.Run()
+	last := parser.Flush()
+	raised := report.ErrorCount - errorCount
+
+	var exitErr *exec.ExitError
+	if err != nil && errors.As(err, &exitErr) && continueOnError && raised > 0 {
+		err = nil
+	}
+	if err != nil {
+		logger.Error(err, "command failed", command, "args", args, "errors", raised)
+		if last != nil {
+			return fmt.Errorf("while restoring %s: %w", source, last)
+		}
+		return fmt.Errorf("while restoring %s: %w", source, err)
+	}
+
+	logger.Info("command succeeded", command, "args", args, "errors", raised)
+	return nil
+}
+
+// restoreErrorParser collects the errors printed by psql and pg_restore.
+// Their messages span more lines: the details, the hints and the failed
+// statement follow the error
+type restoreErrorParser struct {
+	report  *RestoreReport
+	source  string
+	pending []byte
+
+	// current is the error whose following lines are being read
+	current *RestoreError
+
+	// last is the last error collected
+	last *RestoreError
+
+	// inStatement is true while reading a statement spanning more lines
+	inStatement bool
+
+	// entry is the entry announced by pg_restore before its error
+	entry string
+}
+
+func (p *restoreErrorParser) Write(data []byte) (int, error) {
+	p.pending = append(p.pending, data...)
+	for {
+		index := bytes.IndexByte(p.pending, '\n')
+		if index < 0 {
+			break
+		}
+
+		p.parseLine(string(p.pending[:index]))
+		p.pending = p.pending[index+1:]
+	}
+
+	return len(data), nil
+}
+
+// Flush parses the last line and returns the last error collected
+func (p *restoreErrorParser) Flush() *RestoreError {
+	if len(p.pending) > 0 {
+		p.parseLine(string(p.pending))
+		p.pending = nil
+	}
+	p.finish()
+
+	return p.last
+}
+
+func (p *restoreErrorParser) parseLine(line string) {
+	switch {
+	case psqlError.MatchString(line):
+		match := psqlError.FindStringSubmatch(line)
+		lineNumber, _ := strconv.Atoi(match[1])
+		p.start(RestoreError{Line: lineNumber, Code: match[2], Message: match[3]})
+
+	case psqlFailure.MatchString(line):
+		p.start(RestoreError{Message: psqlFailure.FindStringSubmatch(line)[1]})
+
+	case pgRestoreEntry.MatchString(line):
+		p.finish()
+		p.entry = pgRestoreEntry.FindStringSubmatch(line)[1]
+
+	case pgRestoreError.MatchString(line):
+		match := pgRestoreError.FindStringSubmatch(line)
+		p.start(RestoreError{Entry: p.entry, Code: match[1], Message: match[2]})
+		p.entry = ""
+
+	case p.current == nil:
+		// Notices and warnings are not reported
+
+	case psqlStatement.MatchString(line):
+		p.current.Statement = psqlStatement.FindStringSubmatch(line)[1]
+		p.inStatement = true
+
+	case strings.HasPrefix(line, "Command was: "):
+		p.current.Statement = strings.TrimPrefix(line, "Command was: ")
+		p.inStatement = true
+
+	case strings.HasPrefix(line, "DETAIL:  "):
+		p.current.Detail = strings.TrimPrefix(line, "DETAIL:  ")
+		p.inStatement = false
+
+	case strings.HasPrefix(line, "HINT:  "):
+		p.current.Hint = strings.TrimPrefix(line, "HINT:  ")
+		p.inStatement = false
+
+	case strings.HasPrefix(line, "psql:") || strings.HasPrefix(line, "pg_restore:"):
+		p.finish()
+
+	case p.inStatement:
+		p.current.Statement += "\n" + line
+	}
+}
+
+// start begins collecting a new error
+func (p *restoreErrorParser) start(restoreError RestoreError) {
+	p.finish()
+	restoreError.Source = p.source
+	p.current = &restoreError
+}
+
+// finish adds the error being collected to the report
+func (p *restoreErrorParser) finish() {
+	if p.current == nil {
+		return
+	}
+
+	p.report.add(*p.current)
+	p.last = p.current
+	p.current = nil
+	p.inStatement = false
+}
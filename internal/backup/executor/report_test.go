@@ -0,0 +1,117 @@
+package executor_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestRestoreErrorParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []executor.RestoreError
+	}{
+		{
+			name: "psql errors",
+			output: `psql:<stdin>:12: NOTICE:  table "orders" does not exist, skipping
+psql:<stdin>:14: ERROR:  42P07: relation "orders" already exists
+psql:<stdin>:14: STATEMENT:  CREATE TABLE orders (
+    id integer
+);
+psql:<stdin>:20: ERROR:  23505: duplicate key value violates unique constraint "orders_pkey"
+DETAIL:  Key (id)=(1) already exists.
+HINT:  Remove the duplicate.
+psql:<stdin>:30: ERROR:  syntax error at or near "FOO"`,
+			want: []executor.RestoreError{
+				{
+					Source:    "app",
+					Line:      14,
+					Code:      "42P07",
+					Message:   `relation "orders" already exists`,
+					Statement: "CREATE TABLE orders (\n    id integer\n);",
+				},
+				{
+					Source:  "app",
+					Line:    20,
+					Code:    "23505",
+					Message: `duplicate key value violates unique constraint "orders_pkey"`,
+					Detail:  "Key (id)=(1) already exists.",
+					Hint:    "Remove the duplicate.",
+				},
+				{
+					Source:  "app",
+					Line:    30,
+					Message: `syntax error at or near "FOO"`,
+				},
+			},
+		},
+		{
+			name:   "psql connection failure",
+			output: "psql: error: connection to server on socket \"/controller/run/.s.PGSQL.5432\" failed\n",
+			want: []executor.RestoreError{
+				{Source: "app", Message: `connection to server on socket "/controller/run/.s.PGSQL.5432" failed`},
+			},
+		},
+		{
+			name: "pg_restore errors",
+			output: `pg_restore: while PROCESSING TOC:
+pg_restore: from TOC entry 215; 1259 16386 TABLE orders app
+pg_restore: error: could not execute query: ERROR:  42P07: relation "orders" already exists
+Command was: CREATE TABLE public.orders (
+    id integer
+);
+pg_restore: warning: errors ignored on restore: 1
+pg_restore: error: could not open input file "app.dump": No such file or directory
+`,
+			want: []executor.RestoreError{
+				{
+					Source:    "app",
+					Entry:     "215; 1259 16386 TABLE orders app",
+					Code:      "42P07",
+					Message:   `relation "orders" already exists`,
+					Statement: "CREATE TABLE public.orders (\n    id integer\n);",
+				},
+				{
+					Source:  "app",
+					Message: `could not open input file "app.dump": No such file or directory`,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, chunkSize := range []int{1, len(tt.output)} {
+			t.Run(fmt.Sprintf("%s by chunks of %d bytes", tt.name, chunkSize), func(t *testing.T) {
+				report, last := executor.ParseRestoreErrors("app", tt.output, chunkSize)
+				if !reflect.DeepEqual(report.Errors, tt.want) {
+					t.Errorf("got the errors:\n%+v\nwant:\n%+v", report.Errors, tt.want)
+				}
+				if report.ErrorCount != len(tt.want) {
+					t.Errorf("counted %d errors, want %d", report.ErrorCount, len(tt.want))
+				}
+				if last == nil || !reflect.DeepEqual(*last, tt.want[len(tt.want)-1]) {
+					t.Errorf("got the last error %+v, want %+v", last, tt.want[len(tt.want)-1])
+				}
+			})
+		}
+	}
+}
+
+func TestRestoreReportLimit(t *testing.T) {
+	var output strings.Builder
+	for i := range 150 {
+		fmt.Fprintf(&output, "psql:<stdin>:%d: ERROR:  42P07: relation \"table_%d\" already exists\n", i+1, i)
+	}
+
+	report, last := executor.ParseRestoreErrors("app", output.String(), output.Len())
+	if report.ErrorCount != 150 || len(report.Errors) != 100 {
+		t.Errorf("got %d errors out of %d, want the first 100 out of 150", len(report.Errors), report.ErrorCount)
+	}
+	if last == nil || last.Line != 150 {
+		t.Errorf("got the last error %+v, want the error of line 150", last)
+	}
+}
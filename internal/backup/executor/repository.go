@@ -9,146 +9,490 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/archiver"
 	"github.com/go-logr/logr"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+	"golang.org/x/time/rate"
 )
 
 const (
 	PGDumpall        = "pg_dumpall"
+	PGDump           = "pg_dump"
+	PGRestore        = "pg_restore"
+	PGBaseBackup     = "pg_basebackup"
+	PGCombineBackup  = "pg_combinebackup"
 	Psql             = "psql"
 	BackupTimeFormat = "20060102150405"
-	workingDir       = "/backup"
+	CompressionNone  = "none"
 )
 
 // Repository represents a backup repository where
 // base directories are stored
 type Repository struct {
-	bucket string
-	path   string
-	cfg    aws.Config
+	bucket      string
+	path        string
+	compression string
+	owner       *LineageOwner
+	client      *s3.Client
+
+	throttling       Throttling
+	bandwidthLimiter *rate.Limiter
+	dumpOptions      DumpOptions
+	objectLock       ObjectLock
+	tiering          Tiering
+	replicas         []replica
+	objectTags       map[string]string
+	restoreOptions   RestoreOptions
+	scratchLimit     int64
+
+	env      Environment
+	progress *ProgressTracker
 }
 
 // NewRepository creates a new repository ensuring
 // that the repository is initialized and ready to
 // accept backups
-func NewRepository(bucket string, path string) (*Repository, error) {
-	cfg, err := config.LoadDefaultConfig(
-		context.TODO(),
-	)
-	if err != nil {
-		return nil, err
+func NewRepository(bucket string, path string, compression string) (*Repository, error) {
+	return NewRepositoryWithDependencies(bucket, path, compression, Dependencies{})
+}
+
+// NewRepositoryWithDependencies creates a new repository reaching the
+// object storage through the passed dependencies
+func NewRepositoryWithDependencies(
+	bucket string,
+	path string,
+	compression string,
+	dependencies Dependencies,
+) (*Repository, error) {
+	cfg := dependencies.AWSConfig
+	if cfg == nil {
+		defaultConfig, err := config.LoadDefaultConfig(
+			context.TODO(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		cfg = &defaultConfig
 	}
 
-	client := s3.NewFromConfig(cfg)
+	client := s3.NewFromConfig(*cfg, dependencies.S3Options...)
 	params := &s3.HeadBucketInput{
 		Bucket: &bucket,
 	}
-	if _, err = client.HeadBucket(context.TODO(), params); err != nil {
+	if _, err := client.HeadBucket(context.TODO(), params); err != nil {
 		var noBucket *types.NoSuchBucket
 		if errors.Is(err, noBucket) {
 			return nil, fmt.Errorf("bucket %s: not found", bucket)
 		}
 	}
 
+	progress := dependencies.Progress
+	if progress == nil {
+		progress = NewProgressTracker()
+	}
+
 	return &Repository{
-		bucket: bucket,
-		path:   path,
-		cfg:    cfg,
+		bucket:      bucket,
+		path:        path,
+		compression: compression,
+		client:      client,
+		env:         dependencies.Environment,
+		progress:    progress,
 	}, nil
 }
 
-// Snapshot takes a Snapshot of the Postgres cluster
-func (repo *Repository) Snapshot(ctx context.Context) error {
+// Snapshot takes a Snapshot of the Postgres cluster, uploads it
+// into the repository under the passed backup name and returns
+// the metadata describing it
+func (repo *Repository) Snapshot(
+	ctx context.Context,
+	name string,
+	backupType BackupType,
+) (result *BackupMetadata, err error) {
+	logger := logging.FromContext(ctx)
+
+	repo.progress.start(name, backupType)
+	defer func() {
+		repo.progress.stop(err)
+	}()
+
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	go repo.progress.logProgress(progressCtx)
+
+	metadata := &BackupMetadata{
+		Name:      name,
+		Type:      backupType,
+		StartedAt: time.Now(),
+	}
+	repo.recordObjectLock(metadata)
+	metadata.StorageClass = string(repo.tiering.StorageClass)
+	if repo.owner != nil {
+		metadata.ClusterName = repo.owner.Name
+		metadata.Namespace = repo.owner.Namespace
+	}
+
+	if backupType == BackupTypeIncremental {
+		parent, err := repo.latestPhysicalBackup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			logger.Info("No physical backup to use as parent, taking a full backup instead")
+			metadata.Type = BackupTypeFull
+		} else {
+			metadata.Parent = parent.Name
+		}
+	}
+
+	repo.recordLabels(ctx, metadata)
+
+	if err := repo.checkFreeSpace(ctx, metadata); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Creating snapshot", "name", metadata.Name, "type", metadata.Type, "parent", metadata.Parent)
+	if metadata.Type.IsPhysical() {
+		err = repo.physicalSnapshot(ctx, metadata)
+	} else {
+		err = repo.logicalSnapshot(ctx, metadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// logicalSnapshot dumps the whole cluster with pg_dumpall or, when
+// dumping every database on its own, into a folder of pg_dump archives
+func (repo *Repository) logicalSnapshot(ctx context.Context, metadata *BackupMetadata) error {
 	logger := logging.FromContext(ctx)
 
-	client := s3.NewFromConfig(repo.cfg)
+	dump := metadata.Name
+	if repo.dumpOptions.PerDatabase {
+		repo.progress.dumping(repo.env.path(dump))
+		databases, err := repo.env.executePerDatabaseBackup(ctx, metadata.Name, repo.throttling, repo.dumpOptions)
+		if err != nil {
+			return err
+		}
+		metadata.Databases = databases
+		metadata.Layout = LayoutDatabases
+	} else {
+		repo.progress.dumping(repo.env.path(metadata.Name + ".sql"))
+		var err error
+		if dump, err = repo.env.executeBackup(ctx, metadata.Name, repo.throttling, repo.dumpOptions); err != nil {
+			return err
+		}
+		metadata.Layout = LayoutScript
+	}
 
-	logger.Info("Creating snapshot")
-	file, err := executeBackup(ctx)
+	contents, err := repo.env.listArchiveContents(dump)
 	if err != nil {
 		return err
 	}
+	metadata.Contents = contents
 
 	logger.Info("Archiving snapshot")
-	file, err = archiveBackup(file)
+	repo.progress.setPhase(PhaseArchiving)
+	file, err := repo.env.archiveBackup(dump, repo.compression)
 	if err != nil {
 		return err
 	}
 
-	key := filepath.Join(repo.path, file)
-	fileName := filepath.Join(workingDir, file)
+	metadata.Archive = repo.backupKey(metadata.Name, file)
+	return repo.uploadFile(ctx, metadata, metadata.Archive, repo.env.path(file))
+}
 
-	f, err := os.Open(fileName)
+// physicalSnapshot copies the data directory with pg_basebackup. Incremental
+// backups are taken against the backup manifest of their parent
+func (repo *Repository) physicalSnapshot(ctx context.Context, metadata *BackupMetadata) error {
+	logger := logging.FromContext(ctx)
+
+	var parentManifest string
+	if metadata.Type == BackupTypeIncremental {
+		if err := repo.env.checkWalSummarization(ctx); err != nil {
+			return err
+		}
+
+		parent, err := repo.ReadMetadata(ctx, metadata.Parent)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("Downloading parent backup manifest", "parent", parent.Name)
+		parentManifest, err = repo.downloadBackup(ctx, logger, parent.Manifest)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.Remove(parentManifest)
+		}()
+	}
+
+	repo.progress.dumping(repo.env.path(metadata.Name))
+	if err := repo.env.executeBaseBackup(ctx, metadata.Name, parentManifest, repo.throttling); err != nil {
+		return err
+	}
+
+	metadata.Layout = LayoutDataDirectory
+	metadata.Manifest = repo.backupKey(metadata.Name, manifestFile)
+	manifestFileName := filepath.Join(repo.env.path(metadata.Name), manifestFile)
+	if err := repo.uploadObject(ctx, metadata, metadata.Manifest, manifestFileName); err != nil {
+		return err
+	}
+
+	logger.Info("Archiving snapshot")
+	repo.progress.setPhase(PhaseArchiving)
+	file, err := repo.env.archiveBackup(metadata.Name, repo.compression)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	input := &s3.PutObjectInput{
-		Bucket: &repo.bucket,
-		Key:    &key,
-		Body:   f,
+	metadata.Archive = repo.backupKey(metadata.Name, file)
+	return repo.uploadFile(ctx, metadata, metadata.Archive, repo.env.path(file))
+}
+
+// uploadFile uploads the archive of a backup into the repository, in the
+// storage class of the archives, tracking the progress of the upload, and
+// removes it
+func (repo *Repository) uploadFile(ctx context.Context, metadata *BackupMetadata, key string, fileName string) error {
+	repo.progress.uploading(fileName)
+	if err := repo.putFile(ctx, metadata, key, fileName, true); err != nil {
+		return err
 	}
 
-	if err := os.Remove(fileName); err != nil {
+	return os.Remove(fileName)
+}
+
+// uploadObject uploads a local file of a backup into the repository
+func (repo *Repository) uploadObject(ctx context.Context, metadata *BackupMetadata, key string, fileName string) error {
+	return repo.putFile(ctx, metadata, key, fileName, false)
+}
+
+func (repo *Repository) putFile(
+	ctx context.Context,
+	metadata *BackupMetadata,
+	key string,
+	fileName string,
+	archive bool,
+) error {
+	logger := logging.FromContext(ctx)
+
+	client := repo.client
+
+	f, err := os.Open(fileName)
+	if err != nil {
 		return err
 	}
+	defer f.Close()
+
+	var body io.ReadSeeker = f
+	if archive {
+		body = &progressReader{file: f, tracker: repo.progress}
+	}
+	body = newThrottledReadSeeker(ctx, body, repo.bandwidthLimiter)
 
-	logger.Info("Uploading snapshot")
+	input := &s3.PutObjectInput{
+		Bucket:   &repo.bucket,
+		Key:      &key,
+		Body:     body,
+		Metadata: objectMetadata(metadata),
+		Tagging:  objectTagging(metadata),
+	}
+	repo.lockObject(input)
+	if archive {
+		input.StorageClass = repo.tiering.StorageClass
+	}
 
-	logger.Info(fmt.Sprintf("uploading key: %s, file: %s", key, filepath.Join(workingDir, file)))
+	logger.Info(fmt.Sprintf("uploading key: %s, file: %s", key, fileName))
 	if _, err := client.PutObject(ctx, input); err != nil {
 		logger.Error(err, fmt.Sprintf("Unable to upload object to remote bucket: key %s, error: %s", key, err.Error()))
 		return err
 	}
 
+	if archive {
+		repo.progress.uploaded(pathSize(fileName))
+	}
+
 	return nil
 }
 
-func (repo *Repository) Restore(ctx context.Context, backupName string) error {
+// Restore restores a backup. Logical backups are replayed into the running
+// cluster, while physical backups are combined into pgData. The report
+// lists the errors raised by the statements of logical backups
+func (repo *Repository) Restore(ctx context.Context, backupName string, pgData string) (*RestoreReport, error) {
+	report := &RestoreReport{Backup: backupName}
+	return report, repo.restore(ctx, report, backupName, pgData)
+}
+
+func (repo *Repository) restore(ctx context.Context, report *RestoreReport, backupName string, pgData string) error {
 	logger := logging.FromContext(ctx)
 
+	selection := repo.restoreOptions.Selection
+	if err := selection.validate(); err != nil {
+		return err
+	}
+
 	logger.Info("Restoring snapshot")
 
-	logger.Info("Downloading snapshot")
-	backupFilename, err := repo.downloadBackup(ctx, logger, backupName)
-	if err != nil {
+	metadata, err := repo.ReadMetadata(ctx, backupName)
+	if errors.Is(err, ErrMetadataNotFound) {
+		// Backups taken before metadata was introduced are
+		// pg_dumpall scripts referenced by their object key
+		metadata = &BackupMetadata{Name: backupName, Type: BackupTypeLogical, Archive: backupName}
+	} else if err != nil {
 		return err
 	}
 
-	backupFile := filepath.Base(backupFilename)
-	folderName := backupFile[:len(backupFile)-len(".tar.gz")]
+	if !selection.IsEmpty() {
+		switch layout := metadata.archiveLayout(); {
+		case layout != LayoutDatabases:
+			return fmt.Errorf("%w: %s holds %s", ErrNotPerDatabase, backupName, layout.describe())
+		case !slices.Contains(metadata.Databases, selection.Database):
+			return fmt.Errorf("database %s is not in backup %s, which contains: %s",
+				selection.Database, backupName, strings.Join(metadata.Databases, ", "))
+		}
+	}
 
-	logger.Info("Extracting snapshot")
-	if err := archiver.ExtractArchive(backupFilename, filepath.Join(workingDir)); err != nil {
+	if metadata.Type.IsPhysical() {
+		return repo.restorePhysical(ctx, metadata, pgData)
+	}
+
+	// Checked before downloading the archive, and once more
+	// on what it holds
+	if err := repo.restoreOptions.checkLayout(metadata.archiveLayout()); err != nil {
+		return fmt.Errorf("backup %s: %w", backupName, err)
+	}
+
+	return repo.restoreLogical(ctx, report, metadata)
+}
+
+// restoreLogical replays a pg_dumpall script into the running cluster or,
+// for backups taken with per-database dumps, restores the global objects
+// and the selected databases
+func (repo *Repository) restoreLogical(ctx context.Context, report *RestoreReport, metadata *BackupMetadata) error {
+	logger := logging.FromContext(ctx)
+
+	if err := repo.ensureReadable(ctx, metadata.Archive); err != nil {
 		return err
 	}
 
-	logger.Info("Executing restore")
-	if err := executeRestore(ctx, filepath.Join(workingDir, folderName)); err != nil {
+	folder, content, err := repo.extractBackup(ctx, metadata)
+	if err != nil {
 		return err
 	}
 
-	if err := os.Remove(backupFilename); err != nil {
+	// Failed restores are cleaned up as well, so that they can be
+	// retried with another selection without filling the volume
+	defer func() {
+		if err := os.RemoveAll(folder); err != nil {
+			logger.Error(err, "while removing extracted backup", "folder", folder)
+		}
+	}()
+
+	logger.Info("Executing restore")
+	return repo.env.restoreContent(ctx, report, content, "", repo.restoreOptions)
+}
+
+// restorePhysical downloads a physical backup together with the backups
+// it depends on, and combines them into a data directory
+func (repo *Repository) restorePhysical(ctx context.Context, metadata *BackupMetadata, pgData string) error {
+	logger := logging.FromContext(ctx)
+
+	chain, err := repo.resolveChain(ctx, metadata)
+	if err != nil {
 		return err
 	}
-	if err := os.RemoveAll(filepath.Join(workingDir, folderName)); err != nil {
+
+	archives := make([]string, 0, len(chain))
+	for _, item := range chain {
+		archives = append(archives, item.Archive)
+	}
+	if err := repo.ensureReadable(ctx, archives...); err != nil {
 		return err
 	}
 
-	return nil
+	folders := make([]string, 0, len(chain))
+	defer func() {
+		for _, folder := range folders {
+			if err := os.RemoveAll(folder); err != nil {
+				logger.Error(err, "while removing extracted backup", "folder", folder)
+			}
+		}
+	}()
+
+	dataDirectories := make([]string, 0, len(chain))
+	for _, item := range chain {
+		folder, content, err := repo.extractBackup(ctx, item)
+		if err != nil {
+			return err
+		}
+
+		folders = append(folders, folder)
+		dataDirectories = append(dataDirectories, content.path)
+	}
+
+	logger.Info("Combining backups", "backups", len(dataDirectories), "pgData", pgData)
+	return repo.env.executeCombineBackup(ctx, pgData, dataDirectories)
+}
+
+// extractBackup downloads the archive of a backup and extracts it into a
+// folder of its own, to be removed by the caller. The archive is checked
+// against the metadata before anything is restored
+func (repo *Repository) extractBackup(
+	ctx context.Context,
+	metadata *BackupMetadata,
+) (_ string, _ archiveContent, err error) {
+	logger := logging.FromContext(ctx)
+
+	logger.Info("Downloading snapshot", "name", metadata.Name, "type", metadata.Type)
+	backupFilename, err := repo.downloadBackup(ctx, logger, metadata.Archive)
+	if err != nil {
+		return "", archiveContent{}, err
+	}
+	defer func() {
+		if removeErr := os.Remove(backupFilename); removeErr != nil {
+			logger.Error(removeErr, "while removing downloaded archive", "file", backupFilename)
+		}
+	}()
+
+	folder, err := os.MkdirTemp(repo.env.workingDir(), "restore-")
+	if err != nil {
+		return "", archiveContent{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(folder)
+		}
+	}()
+
+	logger.Info("Extracting snapshot", "name", metadata.Name)
+	if err := archiver.ExtractArchive(backupFilename, folder); err != nil {
+		return "", archiveContent{}, err
+	}
+
+	content, err := inspectArchive(folder)
+	if err != nil {
+		return "", archiveContent{}, fmt.Errorf("invalid archive of backup %s: %w", metadata.Name, err)
+	}
+	if err := validateArchive(folder, metadata, content); err != nil {
+		return "", archiveContent{}, err
+	}
+
+	return folder, content, nil
 }
 
 func (repo *Repository) downloadBackup(ctx context.Context, logger logr.Logger, backupName string) (string, error) {
-	client := s3.NewFromConfig(repo.cfg)
+	client := repo.client
 
 	input := &s3.GetObjectInput{
 		Bucket: &repo.bucket,
@@ -161,61 +505,117 @@ func (repo *Repository) downloadBackup(ctx context.Context, logger logr.Logger,
 	}
 	defer resp.Body.Close()
 
-	backupFile := filepath.Join(workingDir, filepath.Base(backupName))
+	backupFile := repo.env.path(filepath.Base(backupName))
 	fo, err := os.Create(backupFile)
 	if err != nil {
 		return "", err
 	}
 	defer fo.Close()
 
-	if _, err := io.Copy(fo, resp.Body); err != nil {
+	if _, err := io.Copy(fo, newThrottledReader(ctx, resp.Body, repo.bandwidthLimiter)); err != nil {
 		return "", err
 	}
 
 	return backupFile, nil
 }
 
-// executeBackup executes pg_dump against the app database
-func executeBackup(ctx context.Context) (string, error) {
-	now := time.Now()
-	file := fmt.Sprintf("%s.sql", now.Format(BackupTimeFormat))
-
-	args := []string{
+// executeBackup executes pg_dumpall against the cluster, streaming the
+// dump into a file through the role filter and the rate limiter
+func (env Environment) executeBackup(
+	ctx context.Context,
+	name string,
+	throttling Throttling,
+	dumpOptions DumpOptions,
+) (string, error) {
+	file := fmt.Sprintf("%s.sql", name)
+
+	args := append([]string{
 		"-h",
 		"/controller/run",
-		"-f",
-		filepath.Join(workingDir, file),
-	}
+	}, dumpOptions.args()...)
 
-	if err := executeCommand(ctx, PGDumpall, args...); err != nil {
+	if err := dumpInto(ctx, env.path(file), newLimiter(throttling.MaxDumpRate),
+		dumpOptions.SkipManagedRoles, throttling, env.binary(PGDumpall), args...); err != nil {
 		return "", err
 	}
 
 	return file, nil
 }
 
-// executeBackup executes psql -f  against the Postgres dump file
-func executeRestore(ctx context.Context, backupFolder string) error {
+// dumpCommand runs the dump with a low priority, if requested
+func dumpCommand(throttling Throttling, command string, args ...string) (string, []string) {
+	if throttling.LowPriorityDump {
+		return lowPriorityCommand(command, args...)
+	}
+
+	return command, args
+}
+
+// executeBaseBackup copies the data directory with pg_basebackup. When a
+// parent manifest is passed the backup is incremental
+func (env Environment) executeBaseBackup(ctx context.Context, name string, parentManifest string, throttling Throttling) error {
 	args := []string{
 		"-h",
 		"/controller/run",
-		"-f",
-		backupFolder,
+		"-D",
+		env.path(name),
+		"--checkpoint=fast",
+		"--wal-method=stream",
+		"--no-password",
+	}
+	if len(parentManifest) > 0 {
+		args = append(args, "--incremental", parentManifest)
 	}
-	if err := executeCommand(ctx, Psql, args...); err != nil {
+	if throttling.MaxDumpRate > 0 {
+		args = append(args, fmt.Sprintf("--max-rate=%dk", baseBackupMaxRate(throttling.MaxDumpRate)))
+	}
+
+	command, args := dumpCommand(throttling, env.binary(PGBaseBackup), args...)
+	return executeCommand(ctx, command, args...)
+}
+
+// baseBackupMaxRate converts a rate in bytes per second into the kilobytes
+// per second accepted by pg_basebackup, which must be between 32kB and 1GB
+func baseBackupMaxRate(bytesPerSecond int64) int64 {
+	const (
+		minRate = 32
+		maxRate = 1024 * 1024
+	)
+
+	return min(max(bytesPerSecond/1024, minRate), maxRate)
+}
+
+// executeCombineBackup reconstructs a full data directory from a full
+// backup followed by the incremental backups depending on it
+func (env Environment) executeCombineBackup(ctx context.Context, pgData string, folders []string) error {
+	args := append([]string{"-o", pgData}, folders...)
+	return executeCommand(ctx, env.binary(PGCombineBackup), args...)
+}
+
+// executeRestore replays the Postgres dump file with psql, tolerating
+// the roles and the databases which already exist in the cluster
+func (env Environment) executeRestore(ctx context.Context, report *RestoreReport, backupFile string, options RestoreOptions) error {
+	f, err := os.Open(backupFile)
+	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	return nil
+	return runRestoreCommand(ctx, report, filepath.Base(backupFile), tolerateExistingObjects(f),
+		options.ContinueOnError, env.binary(Psql), options.psqlArgs()...)
 }
 
-// archiveBackup converts a file into a gunzipped tar archive
-func archiveBackup(file string) (string, error) {
-	srcFile := filepath.Join(workingDir, file)
-	destFilename := fmt.Sprintf("%s.tar.gz", file)
+// archiveBackup converts a file into a tar archive, gzipped
+// unless compression is disabled
+func (env Environment) archiveBackup(file string, compression string) (string, error) {
+	srcFile := env.path(file)
+	destFilename := fmt.Sprintf("%s.tar", file)
+	if compression != CompressionNone {
+		destFilename += ".gz"
+	}
 
 	err := archiver.CreateArchive(
-		filepath.Join(workingDir, destFilename),
+		env.path(destFilename),
 		[]string{srcFile},
 	)
 	if err != nil {
@@ -246,3 +646,33 @@ func executeCommand(ctx context.Context, command string, args ...string) error {
 	logger.Info("command succeeded", command, "args", args, "stdout", stdout.String(), "stderr", stderr.String())
 	return nil
 }
+
+// executeCommandWithIO executes a command reading its standard input
+// from the passed reader and writing its standard output into the passed
+// writer. When no writer is passed, the output is logged
+func executeCommandWithIO(
+	ctx context.Context,
+	input io.Reader,
+	output io.Writer,
+	command string,
+	args ...string,
+) error {
+	var stdout, stderr bytes.Buffer
+	logger := logging.FromContext(ctx)
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = input
+	cmd.Stdout = output
+	if output == nil {
+		cmd.Stdout = &stdout
+	}
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		logger.Error(err, "command failed", command, "args", args, "stdout", stdout.String(), "stderr", stderr.String())
+		return err
+	}
+
+	logger.Info("command succeeded", command, "args", args, "stdout", stdout.String(), "stderr", stderr.String())
+	return nil
+}
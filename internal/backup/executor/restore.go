@@ -0,0 +1,260 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/archiver"
+)
+
+// ErrSingleTransactionScript is raised when restoring a pg_dumpall script
+// in a single transaction, which it cannot run in since it creates the
+// databases and connects to them
+var ErrSingleTransactionScript = errors.New("a pg_dumpall script cannot be restored in a single transaction, " +
+	"take the backup with per-database dumps to restore every database in its own transaction")
+
+// Connection is the PostgreSQL instance logical backups are restored
+// into. The zero value is the local instance, through the socket of the
+// CloudNativePG instance manager
+type Connection struct {
+	Host   string
+	Port   int
+	DBName string
+}
+
+// args are the psql connection arguments
+func (connection Connection) args() []string {
+	host := connection.Host
+	if len(host) == 0 {
+		host = "/controller/run"
+	}
+
+	args := []string{"-h", host}
+	if connection.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(connection.Port))
+	}
+	if len(connection.DBName) > 0 {
+		args = append(args, "-d", connection.DBName)
+	}
+
+	return args
+}
+
+// withDatabase is the same connection, to another database
+func (connection Connection) withDatabase(database string) Connection {
+	connection.DBName = database
+	return connection
+}
+
+// query runs a query with psql returning its unaligned output
+func (env Environment) query(ctx context.Context, connection Connection, query string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, env.binary(Psql), append(connection.args(), "-XAtc", query)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// RestoreOptions are the settings of the restores
+type RestoreOptions struct {
+	// Connection is where logical backups are restored
+	Connection Connection
+
+	// Selection restricts the restore of a logical backup
+	// taken with per-database dumps
+	Selection Selection
+
+	// ContinueOnError keeps restoring after a statement fails,
+	// instead of stopping at the first error
+	ContinueOnError bool
+
+	// SingleTransaction restores the global objects and every database
+	// in their own transaction, so that each is restored either entirely
+	// or not at all. pg_dumpall scripts, which create the databases,
+	// are refused
+	SingleTransaction bool
+}
+
+// checkLayout refuses the options which cannot restore an archive
+// of the passed layout
+func (options RestoreOptions) checkLayout(layout ArchiveLayout) error {
+	if options.SingleTransaction && layout == LayoutScript {
+		return ErrSingleTransactionScript
+	}
+
+	return nil
+}
+
+// psqlArgs are the arguments of psql replaying a script. The errors are
+// verbose, to include their code, and followed by the failed statement
+func (options RestoreOptions) psqlArgs() []string {
+	args := append(options.Connection.args(), "-v", "VERBOSITY=verbose", "--echo-errors")
+	if !options.ContinueOnError {
+		args = append(args, "-v", "ON_ERROR_STOP=1")
+	}
+	if options.SingleTransaction {
+		args = append(args, "--single-transaction")
+	}
+
+	return args
+}
+
+// pgRestoreArgs are the arguments of pg_restore restoring into a database
+func (options RestoreOptions) pgRestoreArgs(database string) []string {
+	args := options.Connection.withDatabase(database).args()
+	if !options.ContinueOnError {
+		args = append(args, "--exit-on-error")
+	}
+	if options.SingleTransaction {
+		args = append(args, "--single-transaction")
+	}
+
+	return args
+}
+
+// SetRestoreOptions sets how the following restores are run
+func (repo *Repository) SetRestoreOptions(options RestoreOptions) {
+	repo.restoreOptions = options
+}
+
+// Download writes the archive of a backup into the output. When extract
+// is set, the dump of a logical backup is written instead of its archive
+func (repo *Repository) Download(ctx context.Context, backupName string, output io.Writer, extract bool) error {
+	key := backupName
+	metadata, err := repo.ReadMetadata(ctx, backupName)
+	switch {
+	case errors.Is(err, ErrMetadataNotFound):
+		// Backups taken before metadata was introduced are
+		// referenced by their object key
+	case err != nil:
+		return err
+	default:
+		if layout := metadata.archiveLayout(); extract && layout != LayoutScript {
+			return fmt.Errorf("backup %s holds %s, only pg_dumpall scripts can be extracted",
+				backupName, layout.describe())
+		}
+		key = metadata.Archive
+	}
+
+	if err := repo.ensureReadable(ctx, key); err != nil {
+		return err
+	}
+
+	resp, err := repo.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := newThrottledReader(ctx, resp.Body, repo.bandwidthLimiter)
+	if extract {
+		return archiver.StreamFile(body, strings.HasSuffix(key, ".gz"), output)
+	}
+
+	_, err = io.Copy(output, body)
+	return err
+}
+
+// RestoreFile restores a local backup, without reaching the repository:
+// a plain SQL dump, the archive of a logical backup, which is restored like
+// Restore does, or the archive of a full physical backup, which is copied
+// into pgData
+func (env Environment) RestoreFile(
+	ctx context.Context,
+	fileName string,
+	pgData string,
+	options RestoreOptions,
+) (*RestoreReport, error) {
+	report := &RestoreReport{Backup: fileName}
+	return report, env.restoreFile(ctx, report, fileName, pgData, options)
+}
+
+func (env Environment) restoreFile(ctx context.Context, report *RestoreReport, fileName string, pgData string, options RestoreOptions) error {
+	logger := logging.FromContext(ctx)
+
+	if err := options.Selection.validate(); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(fileName, ".sql") {
+		if !options.Selection.IsEmpty() {
+			return fmt.Errorf("%w: %s is an SQL script", ErrNotPerDatabase, fileName)
+		}
+		logger.Info("Executing restore", "file", fileName)
+		return env.executeRestore(ctx, report, fileName, options)
+	}
+
+	folder, err := os.MkdirTemp(env.workingDir(), "restore-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(folder); err != nil {
+			logger.Error(err, "while removing extracted backup", "folder", folder)
+		}
+	}()
+
+	logger.Info("Extracting snapshot", "file", fileName)
+	if err := archiver.ExtractArchive(fileName, folder); err != nil {
+		return err
+	}
+
+	content, err := inspectArchive(folder)
+	if err != nil {
+		return fmt.Errorf("while reading %s: %w", fileName, err)
+	}
+
+	return env.restoreContent(ctx, report, content, pgData, options)
+}
+
+// restoreContent restores the dump found in an extracted archive, following
+// its layout. Data directories are combined into pgData
+func (env Environment) restoreContent(
+	ctx context.Context,
+	report *RestoreReport,
+	content archiveContent,
+	pgData string,
+	options RestoreOptions,
+) error {
+	logger := logging.FromContext(ctx)
+
+	if !options.Selection.IsEmpty() && content.layout != LayoutDatabases && content.layout != LayoutDirectory {
+		return fmt.Errorf("%w: the archive holds %s", ErrNotPerDatabase, content.layout.describe())
+	}
+	if err := options.checkLayout(content.layout); err != nil {
+		return err
+	}
+
+	switch content.layout {
+	case LayoutDataDirectory:
+		logger.Info("Combining backups", "backups", 1, "pgData", pgData)
+		return env.executeCombineBackup(ctx, pgData, []string{content.path})
+	case LayoutDatabases:
+		logger.Info("Executing restore", "layout", content.layout)
+		return env.restoreDatabases(ctx, report, content.path, options)
+	case LayoutDirectory:
+		logger.Info("Executing restore", "layout", content.layout)
+		return env.restoreDirectoryDump(ctx, report, content.path, options)
+	default:
+		logger.Info("Executing restore", "layout", content.layout)
+		return env.executeRestore(ctx, report, content.path, options)
+	}
+}
@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+// PruneResult is the outcome of the retention pruning
+type PruneResult struct {
+	// Removed are the names of the removed backups
+	Removed []string
+
+	// Locked are the names of the expired backups which are still
+	// protected by the object lock, and will be removed later
+	Locked []string
+}
+
+// Prune removes the backups completed before the cutoff time. The most
+// recent backup is always kept, together with every backup an incremental
+// backup which is kept depends on. Backups whose objects are still locked
+// are kept too, and reported in the result
+func (repo *Repository) Prune(ctx context.Context, cutoff time.Time) (*PruneResult, error) {
+	logger := logging.FromContext(ctx)
+
+	result := &PruneResult{}
+	backups, err := repo.listOwnBackups(ctx)
+	if err != nil {
+		return result, err
+	}
+	if len(backups) == 0 {
+		return result, nil
+	}
+
+	byName := make(map[string]*BackupMetadata, len(backups))
+	for _, item := range backups {
+		byName[item.Name] = item
+	}
+
+	now := time.Now()
+	keep := make(map[string]bool, len(backups))
+	for i, item := range backups {
+		if i != len(backups)-1 && item.StoppedAt.Before(cutoff) {
+			locked, err := repo.isLocked(ctx, item, now)
+			if err != nil {
+				return result, err
+			}
+			if !locked {
+				continue
+			}
+			result.Locked = append(result.Locked, item.Name)
+		}
+
+		for current := item; current != nil && !keep[current.Name]; current = byName[current.Parent] {
+			keep[current.Name] = true
+		}
+	}
+
+	for _, item := range backups {
+		if keep[item.Name] {
+			continue
+		}
+
+		logger.Info("Removing expired backup", "name", item.Name, "stoppedAt", item.StoppedAt)
+		if err := repo.removeBackupObjects(ctx, item); err != nil {
+			return result, err
+		}
+		result.Removed = append(result.Removed, item.Name)
+	}
+
+	return result, nil
+}
+
+// removeBackupObjects removes every object belonging to a backup. The
+// metadata goes last, so that a partial failure can be retried
+func (repo *Repository) removeBackupObjects(ctx context.Context, metadata *BackupMetadata) error {
+	keys, err := repo.backupObjectKeys(ctx, metadata)
+	if err != nil {
+		return err
+	}
+
+	return repo.deleteKeys(ctx, keys)
+}
@@ -0,0 +1,70 @@
+package executor_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+
+	rep, err := h.Repository("default", "cluster-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := h.Options("default", "cluster-example")
+	backup := func(backupType executor.BackupType) string {
+		t.Helper()
+
+		options.BackupType = backupType
+		name, err := h.Backup(ctx, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	expired := backup(executor.BackupTypeLogical)
+	locked := backup(executor.BackupTypeLogical)
+	parent := backup(executor.BackupTypeFull)
+	latest := backup(executor.BackupTypeIncremental)
+
+	metadata, err := rep.ReadMetadata(ctx, locked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retainUntil := time.Now().Add(time.Hour)
+	metadata.RetainUntil = &retainUntil
+	if err := rep.WriteMetadata(ctx, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := rep.Prune(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Removed, []string{expired}) {
+		t.Errorf("removed %v, want %v", result.Removed, []string{expired})
+	}
+	if !slices.Equal(result.Locked, []string{locked}) {
+		t.Errorf("locked %v, want %v", result.Locked, []string{locked})
+	}
+
+	backups, err := rep.ListBackups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range backups {
+		names = append(names, item.Name)
+	}
+	if want := []string{locked, parent, latest}; !slices.Equal(names, want) {
+		t.Errorf("kept %v, want the locked backup and the latest with its parent %v", names, want)
+	}
+}
@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const scheduleFile = "schedule.json"
+
+// ScheduleState is the state of the backups scheduled by the plugin,
+// stored in the catalog beside the backups
+type ScheduleState struct {
+	// Schedule is the cron expression in use
+	Schedule string `json:"schedule"`
+
+	// LastScheduleTime is the time the last scheduled backup was due
+	LastScheduleTime *time.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastRunTime is the time the last scheduled backup started
+	LastRunTime *time.Time `json:"lastRunTime,omitempty"`
+
+	// LastSuccessfulTime is the time the last successful scheduled backup completed
+	LastSuccessfulTime *time.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// LastBackup is the name of the last successful scheduled backup
+	LastBackup string `json:"lastBackup,omitempty"`
+
+	// LastError is the error of the last scheduled backup, if it failed
+	LastError string `json:"lastError,omitempty"`
+
+	// NextRunTime is the time the next scheduled backup is due
+	NextRunTime *time.Time `json:"nextRunTime,omitempty"`
+}
+
+// ReadScheduleState reads the schedule state from the catalog,
+// returning an empty state when no backup has been scheduled yet
+func (repo *Repository) ReadScheduleState(ctx context.Context) (*ScheduleState, error) {
+	client := repo.client
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &repo.bucket,
+		Key:    aws.String(filepath.Join(repo.path, scheduleFile)),
+	})
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return &ScheduleState{}, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var state ScheduleState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("while decoding the schedule state: %w", err)
+	}
+
+	return &state, nil
+}
+
+// WriteScheduleState stores the schedule state in the catalog
+func (repo *Repository) WriteScheduleState(ctx context.Context, state *ScheduleState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	client := repo.client
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &repo.bucket,
+		Key:         aws.String(filepath.Join(repo.path, scheduleFile)),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
@@ -0,0 +1,141 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+// databaseSizeQuery estimates the size of a backup from the size of the
+// databases which can be connected to, and thus dumped
+const databaseSizeQuery = "SELECT coalesce(sum(pg_database_size(datname)), 0)::bigint " +
+	"FROM pg_database WHERE datallowconn"
+
+// walSinceQuery measures the WAL written since a location, which bounds
+// the blocks an incremental backup taken against it has to copy
+const walSinceQuery = "SELECT greatest(pg_wal_lsn_diff(pg_current_wal_lsn(), %s), 0)::bigint"
+
+// ErrNotEnoughSpace is raised when the working directory cannot hold a backup
+var ErrNotEnoughSpace = errors.New("not enough space in the working directory")
+
+// SetScratchLimit sets the size limit of the scratch volume holding the
+// working directory, zero when the volume has none
+func (repo *Repository) SetScratchLimit(limit int64) {
+	repo.scratchLimit = limit
+}
+
+// checkFreeSpace ensures the working directory can hold the backup before
+// starting it. The dump and its archive coexist on disk until the archive
+// is complete, and compression cannot be relied upon to shrink the dump,
+// so twice the estimated size is required
+func (repo *Repository) checkFreeSpace(ctx context.Context, metadata *BackupMetadata) error {
+	logger := logging.FromContext(ctx)
+
+	estimated, err := repo.estimateBackupSize(ctx, metadata)
+	if err != nil {
+		return fmt.Errorf("while estimating the backup size: %w", err)
+	}
+
+	repo.progress.setEstimate(estimated)
+
+	required := estimated * 2
+
+	available, err := repo.availableSpace(repo.env.workingDir())
+	if err != nil {
+		return fmt.Errorf("while checking the free space in %s: %w", repo.env.workingDir(), err)
+	}
+
+	logger.Info("Checked free space", "estimatedSize", estimated, "requiredSpace", required, "availableSpace", available)
+	if available < required {
+		return fmt.Errorf("%w: %d bytes are available but the backup needs about %d bytes",
+			ErrNotEnoughSpace, available, required)
+	}
+
+	return nil
+}
+
+// estimateBackupSize returns the expected size in bytes of the backup.
+// Incremental backups only copy the blocks changed since their parent
+// started, which cannot exceed the WAL written in the meantime
+func (repo *Repository) estimateBackupSize(ctx context.Context, metadata *BackupMetadata) (int64, error) {
+	size, err := repo.env.estimateDatabaseSize(ctx)
+	if err != nil || metadata.Type != BackupTypeIncremental {
+		return size, err
+	}
+
+	parent, err := repo.ReadMetadata(ctx, metadata.Parent)
+	if err != nil {
+		return 0, err
+	}
+	if len(parent.BeginLSN) == 0 {
+		return size, nil
+	}
+
+	output, err := repo.env.executeQuery(ctx, fmt.Sprintf(walSinceQuery, quoteLiteral(parent.BeginLSN)))
+	if err != nil {
+		return 0, err
+	}
+	changed, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return min(changed, size), nil
+}
+
+// estimateDatabaseSize returns the total size in bytes of the databases
+func (env Environment) estimateDatabaseSize(ctx context.Context) (int64, error) {
+	output, err := env.executeQuery(ctx, databaseSizeQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(output, 10, 64)
+}
+
+// availableSpace returns the bytes available to unprivileged users in
+// the filesystem holding the passed directory. An emptyDir reports the
+// free space of the node, while the pod is evicted as soon as the volume
+// grows past its size limit, so the space left below the limit of the
+// scratch volume is returned when it is smaller
+func (repo *Repository) availableSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	available := int64(stat.Bavail) * stat.Bsize
+
+	if repo.scratchLimit > 0 {
+		used, err := directorySize(dir)
+		if err != nil {
+			return 0, err
+		}
+		available = min(available, max(repo.scratchLimit-used, 0))
+	}
+
+	return available, nil
+}
+
+// directorySize returns the bytes taken by the files of a directory
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
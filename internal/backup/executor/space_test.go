@@ -0,0 +1,139 @@
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+// freeSpace returns the bytes available in the passed directory
+func freeSpace(t *testing.T, dir string) int64 {
+	t.Helper()
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return int64(stat.Bavail) * stat.Bsize
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	const margin = 64 << 20
+
+	tests := []struct {
+		name        string
+		compression string
+		backupType  executor.BackupType
+
+		// size returns the database size and the WAL written since
+		// the parent backup, given the available space
+		size    func(available int64) (int64, int64)
+		wantErr bool
+	}{
+		{
+			name:        "gzip dump fitting once but not with its archive",
+			compression: "gzip",
+			backupType:  executor.BackupTypeLogical,
+			size:        func(available int64) (int64, int64) { return available/2 + margin, 0 },
+			wantErr:     true,
+		},
+		{
+			name:        "uncompressed dump fitting with its archive",
+			compression: "none",
+			backupType:  executor.BackupTypeLogical,
+			size:        func(available int64) (int64, int64) { return available/2 - margin, 0 },
+		},
+		{
+			name:        "incremental backup of a database larger than the volume",
+			compression: "gzip",
+			backupType:  executor.BackupTypeIncremental,
+			size:        func(available int64) (int64, int64) { return 4 * available, 1024 },
+		},
+		{
+			name:        "incremental backup after more WAL than the volume holds",
+			compression: "gzip",
+			backupType:  executor.BackupTypeIncremental,
+			size:        func(available int64) (int64, int64) { return 4 * available, 4 * available },
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+
+			options := h.Options("default", "cluster-example")
+			options.Compression = tt.compression
+			if tt.backupType == executor.BackupTypeIncremental {
+				options.BackupType = executor.BackupTypeFull
+				if _, err := h.Backup(ctx, options); err != nil {
+					t.Fatalf("taking the parent backup: %v", err)
+				}
+			}
+
+			databaseSize, walSince := tt.size(freeSpace(t, h.WorkingDir()))
+			if err := h.SetDatabaseSize(strconv.FormatInt(databaseSize, 10)); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.SetWalSince(strconv.FormatInt(walSince, 10)); err != nil {
+				t.Fatal(err)
+			}
+
+			options.BackupType = tt.backupType
+			_, err := h.Backup(ctx, options)
+			if got := errors.Is(err, executor.ErrNotEnoughSpace); got != tt.wantErr {
+				t.Fatalf("got %v, want not enough space: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCheckFreeSpaceScratchLimit(t *testing.T) {
+	// The harness databases take 1024 bytes, so that 2048 are required
+	tests := []struct {
+		name     string
+		limit    int64
+		leftover int64
+		wantErr  bool
+	}{
+		{name: "volume without limit"},
+		{name: "limit above the backup size", limit: 1 << 20},
+		{name: "limit below the backup size", limit: 2000, wantErr: true},
+		{name: "limit taken by other files", limit: 1 << 20, leftover: 1<<20 - 1024, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+
+			if tt.leftover > 0 {
+				leftover := filepath.Join(h.WorkingDir(), "leftover")
+				if err := os.WriteFile(leftover, make([]byte, tt.leftover), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { _ = os.Remove(leftover) })
+			}
+
+			options := h.Options("default", "cluster-example")
+			options.ScratchVolumeSize = tt.limit
+			_, err := h.Backup(ctx, options)
+			if got := errors.Is(err, executor.ErrNotEnoughSpace); got != tt.wantErr {
+				t.Fatalf("got %v, want not enough space: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+
+	pluginmetadata "github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
+)
+
+// The tags set by the plugin on every object of a backup, so that the
+// objects can be attributed to their cluster and backup by cost
+// allocation tools and lifecycle rules
+const (
+	tagCluster         = "cnpg.io/cluster"
+	tagNamespace       = "cnpg.io/namespace"
+	tagBackupID        = "cnpg.io/backupID"
+	tagBackupType      = "cnpg.io/backupType"
+	tagPostgresVersion = "cnpg.io/postgresVersion"
+	tagPluginVersion   = "cnpg.io/pluginVersion"
+)
+
+// SetObjectTags sets the tags added by the user to the objects
+// of the following backups
+func (repo *Repository) SetObjectTags(tags map[string]string) {
+	repo.objectTags = tags
+}
+
+// recordLabels stores in the metadata of a backup the tags of its
+// objects and the versions it has been taken with
+func (repo *Repository) recordLabels(ctx context.Context, metadata *BackupMetadata) {
+	metadata.PluginVersion = pluginmetadata.Data.Version
+
+	version, err := repo.env.postgresVersion(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error(err, "while reading the PostgreSQL version")
+	}
+	metadata.PostgresVersion = version
+
+	tags := make(map[string]string, len(repo.objectTags)+6)
+	maps.Copy(tags, repo.objectTags)
+	if len(metadata.ClusterName) > 0 {
+		tags[tagCluster] = metadata.ClusterName
+		tags[tagNamespace] = metadata.Namespace
+	}
+	tags[tagBackupID] = metadata.Name
+	tags[tagBackupType] = string(metadata.Type)
+	if len(metadata.PostgresVersion) > 0 {
+		tags[tagPostgresVersion] = metadata.PostgresVersion
+	}
+	if len(metadata.PluginVersion) > 0 {
+		tags[tagPluginVersion] = metadata.PluginVersion
+	}
+	metadata.Tags = tags
+}
+
+// postgresVersion is the version of the local PostgreSQL, such as "16.4"
+func (env Environment) postgresVersion(ctx context.Context) (string, error) {
+	output, err := env.executeQuery(ctx, "SHOW server_version")
+	if err != nil {
+		return "", err
+	}
+
+	// Distributions append their own version after a space
+	version, _, _ := strings.Cut(output, " ")
+	return version, nil
+}
+
+// objectTagging encodes the tags of a backup as expected by the
+// x-amz-tagging header
+func objectTagging(metadata *BackupMetadata) *string {
+	if len(metadata.Tags) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(metadata.Tags))
+	for key := range metadata.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, tagEscape(key)+"="+tagEscape(metadata.Tags[key]))
+	}
+	tagging := strings.Join(pairs, "&")
+
+	return &tagging
+}
+
+func tagEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// objectMetadata is the user metadata, sent as x-amz-meta-* headers,
+// describing the backup an object belongs to
+func objectMetadata(metadata *BackupMetadata) map[string]string {
+	result := map[string]string{
+		"backup-id":   metadata.Name,
+		"backup-type": string(metadata.Type),
+	}
+
+	optional := map[string]string{
+		"cluster":            metadata.ClusterName,
+		"namespace":          metadata.Namespace,
+		"postgresql-version": metadata.PostgresVersion,
+		"plugin-version":     metadata.PluginVersion,
+	}
+	for key, value := range optional {
+		if len(value) > 0 {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// applyObjectMetadata completes the metadata of a backup read from the
+// repository with the user metadata of its object, which identifies the
+// cluster and the versions of backups whose metadata predates them. The
+// user metadata must describe the backup it is stored with
+func applyObjectMetadata(metadata *BackupMetadata, userMetadata map[string]string) error {
+	if id, ok := userMetadata["backup-id"]; ok && id != metadata.Name {
+		return fmt.Errorf("the object metadata of backup %s belongs to backup %s", metadata.Name, id)
+	}
+
+	fields := []struct {
+		key   string
+		value *string
+	}{
+		{key: "cluster", value: &metadata.ClusterName},
+		{key: "namespace", value: &metadata.Namespace},
+		{key: "postgresql-version", value: &metadata.PostgresVersion},
+		{key: "plugin-version", value: &metadata.PluginVersion},
+	}
+	for _, field := range fields {
+		value := userMetadata[field.key]
+		switch {
+		case len(value) == 0:
+		case len(*field.value) == 0:
+			*field.value = value
+		case *field.value != value:
+			return fmt.Errorf("the %s of backup %s is %q, but its object metadata says %q",
+				field.key, metadata.Name, *field.value, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package executor_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	pluginmetadata "github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
+)
+
+func TestBackupObjectTags(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+
+	options := h.Options("default", "cluster-example")
+	options.ObjectTags = map[string]string{"team": "payments"}
+	name, err := h.Backup(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := h.Repository("default", "cluster-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := rep.ReadMetadata(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"team":                    "payments",
+		"cnpg.io/cluster":         "cluster-example",
+		"cnpg.io/namespace":       "default",
+		"cnpg.io/backupID":        name,
+		"cnpg.io/backupType":      string(metadata.Type),
+		"cnpg.io/postgresVersion": "16.4",
+		"cnpg.io/pluginVersion":   pluginmetadata.Data.Version,
+	}
+	for _, key := range []string{metadata.Archive, options.Prefix + "/" + name + "/backup.json"} {
+		object := h.S3.Get(h.Bucket, key)
+		if object == nil {
+			t.Fatalf("object %s not found", key)
+		}
+		for tag, value := range want {
+			if object.Tags[tag] != value {
+				t.Errorf("tag %s of %s is %q, want %q", tag, key, object.Tags[tag], value)
+			}
+		}
+		if object.Metadata["backup-id"] != name || object.Metadata["postgresql-version"] != "16.4" {
+			t.Errorf("unexpected user metadata of %s: %v", key, object.Metadata)
+		}
+	}
+}
+
+func TestListBackupsFromObjectMetadata(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+
+	options := h.Options("default", "cluster-example")
+	name, err := h.Backup(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata of older backups does not identify their cluster,
+	// which is only known from the user metadata of the object
+	object := h.S3.Get(h.Bucket, options.Prefix+"/"+name+"/backup.json")
+	if object == nil {
+		t.Fatal("metadata not found")
+	}
+	object.Data = bytes.ReplaceAll(object.Data, []byte(`"clusterName":"cluster-example","namespace":"default",`), nil)
+
+	rep, err := h.Repository("default", "cluster-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, err := rep.ListBackups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].ClusterName != "cluster-example" || backups[0].Namespace != "default" {
+		t.Fatalf("listed %+v, want the backup of default/cluster-example", backups)
+	}
+
+	object.Metadata["backup-id"] = "another-backup"
+	if _, err := rep.ReadMetadata(ctx, name); err == nil || !strings.Contains(err.Error(), "another-backup") {
+		t.Errorf("reading metadata belonging to another backup: got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package executor
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// throttleChunk is the largest amount of bytes transferred at once by a
+// throttled stream, keeping the rate smooth even with large buffers
+const throttleChunk = 64 * 1024
+
+// Throttling limits the resources a backup takes from the instance
+type Throttling struct {
+	// MaxUploadBandwidth is the maximum rate, in bytes per second, of
+	// the uploads and downloads to the object storage. Zero means no limit
+	MaxUploadBandwidth int64
+
+	// MaxDumpRate is the maximum rate, in bytes per second, PostgreSQL is
+	// copied into the working directory. Zero means no limit
+	MaxDumpRate int64
+
+	// LowPriorityDump runs the dump under nice and ionice, so that
+	// it yields to the PostgreSQL processes
+	LowPriorityDump bool
+}
+
+// SetThrottling sets the limits used by the following backups and restores
+func (repo *Repository) SetThrottling(throttling Throttling) {
+	repo.throttling = throttling
+	repo.bandwidthLimiter = newLimiter(throttling.MaxUploadBandwidth)
+}
+
+// newLimiter creates a limiter for the passed bytes per second, nil
+// when there is no limit
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), throttleChunk)
+}
+
+// throttledReader limits the rate a stream is read at
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func newThrottledReader(ctx context.Context, reader io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+
+	return &throttledReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// throttledReadSeeker is a throttledReader which can be rewound, as
+// the uploader needs to retry a request
+type throttledReadSeeker struct {
+	throttledReader
+	seeker io.Seeker
+}
+
+func newThrottledReadSeeker(ctx context.Context, reader io.ReadSeeker, limiter *rate.Limiter) io.ReadSeeker {
+	if limiter == nil {
+		return reader
+	}
+
+	return &throttledReadSeeker{
+		throttledReader: throttledReader{ctx: ctx, reader: reader, limiter: limiter},
+		seeker:          reader,
+	}
+}
+
+func (r *throttledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+// throttledWriter limits the rate a stream is written at
+type throttledWriter struct {
+	ctx     context.Context
+	writer  io.Writer
+	limiter *rate.Limiter
+}
+
+func newThrottledWriter(ctx context.Context, writer io.Writer, limiter *rate.Limiter) io.Writer {
+	if limiter == nil {
+		return writer
+	}
+
+	return &throttledWriter{ctx: ctx, writer: writer, limiter: limiter}
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		if err := w.limiter.WaitN(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := w.writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// lowPriorityCommand prefixes a command so that it runs with the lowest
+// CPU priority and the lowest best-effort I/O priority
+func lowPriorityCommand(command string, args ...string) (string, []string) {
+	return "nice", append([]string{"-n", "19", "ionice", "-c", "2", "-n", "7", command}, args...)
+}
@@ -0,0 +1,72 @@
+package executor_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestThrottledStreams(t *testing.T) {
+	// The limiter lets a chunk through at once, the rest of the data
+	// is transferred at the rate of the limit
+	const rate = 256 * 1024
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20*1024)
+	minDuration := time.Duration(float64(len(data)-64*1024) / rate * float64(time.Second))
+
+	tests := []struct {
+		name     string
+		transfer func(ctx context.Context, limit int64) ([]byte, error)
+	}{
+		{
+			name: "reader",
+			transfer: func(ctx context.Context, limit int64) ([]byte, error) {
+				return io.ReadAll(executor.NewThrottledReader(ctx, bytes.NewReader(data), executor.NewLimiter(limit)))
+			},
+		},
+		{
+			name: "writer",
+			transfer: func(ctx context.Context, limit int64) ([]byte, error) {
+				var result bytes.Buffer
+				_, err := executor.NewThrottledWriter(ctx, &result, executor.NewLimiter(limit)).Write(data)
+				return result.Bytes(), err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			start := time.Now()
+			result, err := tt.transfer(ctx, rate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < minDuration {
+				t.Errorf("transferred %d bytes in %v, faster than the limit allows", len(data), elapsed)
+			}
+			if !bytes.Equal(result, data) {
+				t.Errorf("the throttled stream altered the data")
+			}
+
+			start = time.Now()
+			if _, err := tt.transfer(ctx, 0); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed >= minDuration {
+				t.Errorf("transferred %d bytes in %v without a limit", len(data), elapsed)
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+			if _, err := tt.transfer(ctx, rate); !errors.Is(err, context.Canceled) {
+				t.Errorf("got %v after the context has been canceled, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
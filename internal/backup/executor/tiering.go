@@ -0,0 +1,405 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+)
+
+var (
+	// maxCopyObjectSize is the largest object which can be copied with
+	// a single request, larger ones are copied in parts
+	maxCopyObjectSize int64 = 5 * 1024 * 1024 * 1024
+
+	// copyPartSize is the size of the parts of a multipart copy
+	copyPartSize int64 = 512 * 1024 * 1024
+)
+
+const (
+	// archiveRestorePollInterval is how often the status of the
+	// restore of an archived object is checked. Restores from the
+	// archive classes take minutes to hours
+	archiveRestorePollInterval = time.Minute
+)
+
+// Tiering decides the storage classes of the backup archives. Metadata
+// and manifests always stay in the default storage class, since they are
+// read when listing and when taking incremental backups
+type Tiering struct {
+	// StorageClass is the class archives are uploaded with, the
+	// default class of the bucket when empty
+	StorageClass types.StorageClass
+
+	// TransitionStorageClass is the class archives are moved to
+	// once they are older than the transition cutoff
+	TransitionStorageClass types.StorageClass
+
+	// RestoreTier is the retrieval tier used when restoring
+	// archived objects, Standard when empty
+	RestoreTier types.Tier
+
+	// RestoreDays is how long the restored copy of an archived
+	// object stays readable, one day when zero
+	RestoreDays int32
+}
+
+// SetTiering sets the storage classes of the following backups
+func (repo *Repository) SetTiering(tiering Tiering) {
+	repo.tiering = tiering
+}
+
+// isArchiveClass is true for the storage classes whose objects need to
+// be restored before being read
+func isArchiveClass(class types.StorageClass) bool {
+	return class == types.StorageClassGlacier || class == types.StorageClassDeepArchive
+}
+
+// Transition copies in place, into the transition storage class, the
+// archives of the backups completed before the cutoff. Returns the names
+// of the backups which have been moved
+func (repo *Repository) Transition(ctx context.Context, cutoff time.Time) ([]string, error) {
+	logger := logging.FromContext(ctx)
+
+	target := repo.tiering.TransitionStorageClass
+	if len(target) == 0 {
+		return nil, nil
+	}
+
+	backups, err := repo.listOwnBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []string
+	for _, item := range backups {
+		current := types.StorageClass(item.StorageClass)
+		if !item.StoppedAt.Before(cutoff) || len(item.Archive) == 0 || current == target {
+			continue
+		}
+
+		// Archived objects cannot be copied without being restored first
+		if isArchiveClass(current) {
+			continue
+		}
+
+		logger.Info("Moving backup to a colder storage class",
+			"name", item.Name, "from", item.StorageClass, "to", target)
+		if err := repo.copyInPlace(ctx, item, target); err != nil {
+			return moved, fmt.Errorf("while moving %s to %s: %w", item.Name, target, err)
+		}
+
+		item.StorageClass = string(target)
+		if err := repo.WriteMetadata(ctx, item); err != nil {
+			return moved, err
+		}
+		moved = append(moved, item.Name)
+	}
+
+	return moved, nil
+}
+
+// copyInPlace rewrites the archive of a backup with a new storage class,
+// keeping its content, metadata, tags and object lock
+func (repo *Repository) copyInPlace(ctx context.Context, metadata *BackupMetadata, class types.StorageClass) error {
+	head, err := repo.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &metadata.Archive,
+	})
+	if err != nil {
+		return err
+	}
+
+	source := url.PathEscape(repo.bucket + "/" + metadata.Archive)
+	size := aws.ToInt64(head.ContentLength)
+	if size > maxCopyObjectSize {
+		err = repo.copyArchiveInParts(ctx, metadata, source, size, class, head.Metadata)
+	} else {
+		err = repo.copyObjectInPlace(ctx, metadata, source, class)
+	}
+	if err != nil {
+		return err
+	}
+
+	return repo.removeReplacedVersion(ctx, metadata, aws.ToString(head.VersionId))
+}
+
+// removeReplacedVersion deletes the version of the archive replaced by a
+// copy in place, which versioned buckets would keep in its former storage
+// class. A version still locked is left to be removed with the backup
+func (repo *Repository) removeReplacedVersion(ctx context.Context, metadata *BackupMetadata, versionID string) error {
+	logger := logging.FromContext(ctx)
+
+	if len(versionID) == 0 || versionID == "null" {
+		return nil
+	}
+
+	locked, err := repo.isLocked(ctx, metadata, time.Now())
+	if err != nil {
+		return err
+	}
+	if locked {
+		logger.Info("Keeping the previous version of the archive until its object lock expires",
+			"name", metadata.Name, "versionId", versionID)
+		return nil
+	}
+
+	_, err = repo.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    &repo.bucket,
+		Key:       &metadata.Archive,
+		VersionId: &versionID,
+	})
+	return err
+}
+
+// copyObjectInPlace copies an archive within the limit of a single
+// copy request over itself
+func (repo *Repository) copyObjectInPlace(
+	ctx context.Context,
+	metadata *BackupMetadata,
+	source string,
+	class types.StorageClass,
+) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            &repo.bucket,
+		Key:               &metadata.Archive,
+		CopySource:        &source,
+		MetadataDirective: types.MetadataDirectiveCopy,
+		StorageClass:      class,
+	}
+
+	// The lock of the source is not copied, the new version needs its own
+	if metadata.RetainUntil != nil && metadata.RetainUntil.After(time.Now()) {
+		input.ObjectLockMode = types.ObjectLockMode(metadata.LockMode)
+		input.ObjectLockRetainUntilDate = metadata.RetainUntil
+	}
+	if metadata.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+
+	_, err := repo.client.CopyObject(ctx, input)
+	return err
+}
+
+// copyArchiveInParts copies an archive larger than the limit of a
+// single copy request over itself
+func (repo *Repository) copyArchiveInParts(
+	ctx context.Context,
+	metadata *BackupMetadata,
+	source string,
+	size int64,
+	class types.StorageClass,
+	userMetadata map[string]string,
+) error {
+	// Unlike single copies, multipart uploads don't keep the
+	// metadata and the tags of the source
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:       &repo.bucket,
+		Key:          &metadata.Archive,
+		StorageClass: class,
+		Metadata:     userMetadata,
+		Tagging:      objectTagging(metadata),
+	}
+	if metadata.RetainUntil != nil && metadata.RetainUntil.After(time.Now()) {
+		input.ObjectLockMode = types.ObjectLockMode(metadata.LockMode)
+		input.ObjectLockRetainUntilDate = metadata.RetainUntil
+	}
+	if metadata.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+
+	return repo.copyInParts(ctx, input, source, size)
+}
+
+// copyInParts copies the source object into the repository with a
+// multipart upload created with the passed input, aborting the upload
+// when a part cannot be copied
+func (repo *Repository) copyInParts(
+	ctx context.Context,
+	input *s3.CreateMultipartUploadInput,
+	source string,
+	size int64,
+) error {
+	upload, err := repo.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	parts, err := repo.copyParts(ctx, *input.Key, upload.UploadId, source, size)
+	if err != nil {
+		if _, abortErr := repo.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   &repo.bucket,
+			Key:      input.Key,
+			UploadId: upload.UploadId,
+		}); abortErr != nil {
+			logging.FromContext(ctx).Error(abortErr, "while aborting the multipart copy", "key", *input.Key)
+		}
+		return err
+	}
+
+	_, err = repo.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &repo.bucket,
+		Key:             input.Key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+func (repo *Repository) copyParts(
+	ctx context.Context,
+	key string,
+	uploadID *string,
+	source string,
+	size int64,
+) ([]types.CompletedPart, error) {
+	var parts []types.CompletedPart
+	for start, number := int64(0), int32(1); start < size; start, number = start+copyPartSize, number+1 {
+		end := min(start+copyPartSize, size) - 1
+
+		output, err := repo.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &repo.bucket,
+			Key:             &key,
+			UploadId:        uploadID,
+			PartNumber:      aws.Int32(number),
+			CopySource:      &source,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       output.CopyPartResult.ETag,
+			PartNumber: aws.Int32(number),
+		})
+	}
+
+	return parts, nil
+}
+
+// ensureReadable restores the passed objects when they have been moved to
+// an archive storage class, and waits until all of them can be downloaded.
+// The restores are all requested first, so that they run in parallel
+func (repo *Repository) ensureReadable(ctx context.Context, keys ...string) error {
+	logger := logging.FromContext(ctx)
+
+	var pending []string
+	for _, key := range keys {
+		archived, err := repo.requestRestore(ctx, key)
+		if err != nil {
+			return err
+		}
+		if archived {
+			pending = append(pending, key)
+		}
+	}
+
+	for len(pending) > 0 {
+		var stillPending []string
+		for _, key := range pending {
+			restored, err := repo.isRestored(ctx, key)
+			if err != nil {
+				return err
+			}
+			if !restored {
+				stillPending = append(stillPending, key)
+			}
+		}
+
+		pending = stillPending
+		if len(pending) == 0 {
+			break
+		}
+
+		logger.Info("Waiting for archived objects to be restored", "objects", pending)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(archiveRestorePollInterval):
+		}
+	}
+
+	return nil
+}
+
+// requestRestore asks for an archived object to be restored, returning
+// false when the object can already be read
+func (repo *Repository) requestRestore(ctx context.Context, key string) (bool, error) {
+	head, err := repo.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Objects in the archive tiers of intelligent tiering report
+	// their status, and are restored without an expiration
+	intelligentTiering := len(head.ArchiveStatus) > 0
+	if !isArchiveClass(head.StorageClass) && !intelligentTiering {
+		return false, nil
+	}
+	if head.Restore != nil {
+		// A restore has already been requested
+		return true, nil
+	}
+
+	tier := repo.tiering.RestoreTier
+	if len(tier) == 0 {
+		tier = types.TierStandard
+	}
+	request := &types.RestoreRequest{
+		GlacierJobParameters: &types.GlacierJobParameters{Tier: tier},
+	}
+	if !intelligentTiering {
+		days := repo.tiering.RestoreDays
+		if days <= 0 {
+			days = 1
+		}
+		request.Days = aws.Int32(days)
+	}
+
+	logging.FromContext(ctx).Info("Restoring archived object",
+		"key", key, "storageClass", head.StorageClass, "tier", tier)
+	_, err = repo.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         &repo.bucket,
+		Key:            &key,
+		RestoreRequest: request,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("while restoring %s: %w", key, err)
+	}
+
+	return true, nil
+}
+
+// isRestored is true when the restore of an archived object is complete
+func (repo *Repository) isRestored(ctx context.Context, key string) (bool, error) {
+	head, err := repo.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &repo.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Objects moved back to the frequent access tier have no restore status
+	if head.Restore == nil {
+		return len(head.ArchiveStatus) == 0 && !isArchiveClass(head.StorageClass), nil
+	}
+
+	return strings.Contains(*head.Restore, `ongoing-request="false"`), nil
+}
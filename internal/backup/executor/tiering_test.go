@@ -0,0 +1,79 @@
+package executor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestTransitionVersionedBucket(t *testing.T) {
+	tests := []struct {
+		name      string
+		legalHold bool
+
+		// wantClasses are the storage classes of the versions
+		// of the archive, from the oldest to the newest
+		wantClasses []string
+	}{
+		{
+			name:        "previous version removed",
+			wantClasses: []string{"STANDARD_IA"},
+		},
+		{
+			name:        "previous version under legal hold",
+			legalHold:   true,
+			wantClasses: []string{"", "STANDARD_IA"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHarness(t)
+			h.S3.EnableObjectLock(h.Bucket)
+
+			options := h.Options("default", "cluster-example")
+			options.ObjectLock = backup.ObjectLockOptions{LegalHold: tt.legalHold}
+			name, err := h.Backup(ctx, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rep, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rep.SetTiering(executor.Tiering{TransitionStorageClass: types.StorageClassStandardIa})
+
+			moved, err := rep.Transition(ctx, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(moved) != 1 || moved[0] != name {
+				t.Fatalf("moved %v, want %s", moved, name)
+			}
+
+			metadata, err := rep.ReadMetadata(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var classes []string
+			for _, version := range h.S3.Versions(h.Bucket, metadata.Archive) {
+				classes = append(classes, version.StorageClass)
+			}
+			if len(classes) != len(tt.wantClasses) {
+				t.Fatalf("archive versions in %q, want %q", classes, tt.wantClasses)
+			}
+			for i := range classes {
+				if classes[i] != tt.wantClasses[i] {
+					t.Errorf("archive versions in %q, want %q", classes, tt.wantClasses)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package backup_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestOptionsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name: "valid settings",
+			env: map[string]string{
+				"LOCK_TIMEOUT":         "5m",
+				"HOOK_TIMEOUT":         "30s",
+				"OBJECT_TAGS":          "team=payments",
+				"MAX_UPLOAD_BANDWIDTH": "10Mi",
+			},
+		},
+		{name: "invalid lock timeout", env: map[string]string{"LOCK_TIMEOUT": "5 minutes"}, wantErr: "LOCK_TIMEOUT"},
+		{name: "invalid hook timeout", env: map[string]string{"HOOK_TIMEOUT": "soon"}, wantErr: "HOOK_TIMEOUT"},
+		{
+			name:    "invalid replica destinations",
+			env:     map[string]string{"REPLICA_DESTINATIONS": "bucket=replica,unknown=value"},
+			wantErr: "REPLICA_DESTINATIONS",
+		},
+		{name: "invalid object tags", env: map[string]string{"OBJECT_TAGS": "team"}, wantErr: "OBJECT_TAGS"},
+		{name: "invalid bandwidth", env: map[string]string{"MAX_UPLOAD_BANDWIDTH": "fast"}, wantErr: "MAX_UPLOAD_BANDWIDTH"},
+		{name: "invalid dump rate", env: map[string]string{"MAX_DUMP_RATE": "-1"}, wantErr: "MAX_DUMP_RATE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			options, err := backup.OptionsFromEnv(executor.LineageOwner{Namespace: "default", Name: "cluster-example"})
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if options.LockTimeout != 5*time.Minute || options.Hooks.Timeout != 30*time.Second {
+				t.Errorf("unexpected timeouts %s and %s", options.LockTimeout, options.Hooks.Timeout)
+			}
+			if options.ObjectTags["team"] != "payments" || options.Throttling.MaxUploadBandwidth != 10<<20 {
+				t.Errorf("unexpected options %+v", options)
+			}
+		})
+	}
+}
@@ -16,18 +16,217 @@ const (
 	AwsSecretKeyParam    = "aws_secret_key"
 	BucketParam          = "bucket"
 	PrefixParam          = "prefix"
+	CompressionParam     = "compression"
+	RetentionPolicyParam = "retentionPolicy"
+
+	// AllowSharedPrefixParam allows the backups of different clusters
+	// to be stored under the same prefix
+	AllowSharedPrefixParam = "allowSharedPrefix"
+
+	SidecarCPURequestParam    = "sidecarCPURequest"
+	SidecarCPULimitParam      = "sidecarCPULimit"
+	SidecarMemoryRequestParam = "sidecarMemoryRequest"
+	SidecarMemoryLimitParam   = "sidecarMemoryLimit"
+
+	// SidecarEnvParam is a comma separated list of NAME=value
+	// environment variables passed to the sidecar
+	SidecarEnvParam = "sidecarEnv"
+
+	// SidecarEnvFromParam is a comma separated list of secret/<name>
+	// and configMap/<name> environment sources passed to the sidecar
+	SidecarEnvFromParam = "sidecarEnvFrom"
+
+	// ScratchVolumeSizeParam is the size of the volume where backups
+	// are written before being uploaded
+	ScratchVolumeSizeParam = "scratchVolumeSize"
+
+	// ScratchStorageClassParam, when set, backs the scratch volume with a
+	// generic ephemeral volume of this storage class instead of an emptyDir
+	ScratchStorageClassParam = "scratchStorageClass"
+
+	// ScheduleParam is the cron expression of the backups taken by the plugin
+	ScheduleParam = "schedule"
+
+	// ImmediateParam takes a backup as soon as the schedule starts
+	ImmediateParam = "immediate"
+
+	// ScheduleJitterParam is the maximum random delay added to each run
+	ScheduleJitterParam = "scheduleJitter"
+
+	// ConcurrencyPolicyParam decides what happens when a scheduled
+	// backup is due while the previous one is still running
+	ConcurrencyPolicyParam = "concurrencyPolicy"
+
+	// StartingDeadlineParam is how late a missed scheduled backup
+	// can still be taken, for example after the sidecar restarted
+	StartingDeadlineParam = "startingDeadline"
+
+	// ScheduleBackupTypeParam is the kind of the scheduled backups
+	ScheduleBackupTypeParam = "scheduleBackupType"
+
+	// LockTimeoutParam is how long a backup waits for the running one
+	// to complete before failing, by default it fails right away
+	LockTimeoutParam = "lockTimeout"
+
+	// PreBackupSQLParam are SQL statements run before the snapshot
+	PreBackupSQLParam = "preBackupSQL"
+
+	// PostBackupSQLParam are SQL statements run after the snapshot,
+	// even when the backup failed
+	PostBackupSQLParam = "postBackupSQL"
+
+	// PreBackupCommandParam is a shell command run in the sidecar
+	// before the snapshot
+	PreBackupCommandParam = "preBackupCommand"
+
+	// PostBackupCommandParam is a shell command run in the sidecar
+	// after the snapshot, even when the backup failed
+	PostBackupCommandParam = "postBackupCommand"
+
+	// HookTimeoutParam is how long each backup hook can run
+	HookTimeoutParam = "hookTimeout"
+
+	// MaxUploadBandwidthParam limits the bytes per second transferred
+	// from and to the object storage
+	MaxUploadBandwidthParam = "maxUploadBandwidth"
+
+	// MaxDumpRateParam limits the bytes per second PostgreSQL is dumped at
+	MaxDumpRateParam = "maxDumpRate"
+
+	// LowPriorityDumpParam runs the dump with a low CPU and I/O priority
+	LowPriorityDumpParam = "lowPriorityDump"
+
+	// NoRolePasswordsParam leaves the role passwords out of logical backups
+	NoRolePasswordsParam = "noRolePasswords"
+
+	// SkipManagedRolesParam leaves the roles managed by CloudNativePG
+	// and the tablespaces out of logical backups
+	SkipManagedRolesParam = "skipManagedRoles"
+
+	// DumpContentParam selects whether logical backups contain the
+	// schema, the data or both
+	DumpContentParam = "dumpContent"
+
+	// PerDatabaseDumpsParam stores logical backups as the global objects
+	// and a custom format dump of every database, so that a single
+	// database, schema or table can be restored
+	PerDatabaseDumpsParam = "perDatabaseDumps"
+
+	// ObjectLockModeParam uploads the backups with an object lock
+	// retention, in governance or compliance mode
+	ObjectLockModeParam = "objectLockMode"
+
+	// LockRetentionParam is how long the objects of a backup stay
+	// locked, in the same format as the retention policy
+	LockRetentionParam = "lockRetention"
+
+	// LegalHoldParam uploads the backups under legal hold
+	LegalHoldParam = "legalHold"
+
+	// StorageClassParam is the storage class the backup archives are
+	// uploaded with
+	StorageClassParam = "storageClass"
+
+	// TransitionAfterParam is the age, in the same format as the retention
+	// policy, after which archives are moved to the transition storage class
+	TransitionAfterParam = "transitionAfter"
+
+	// TransitionStorageClassParam is the colder storage class old
+	// archives are moved to
+	TransitionStorageClassParam = "transitionStorageClass"
+
+	// ArchiveRestoreTierParam is the retrieval tier used to restore
+	// archives which have been moved to an archive storage class
+	ArchiveRestoreTierParam = "archiveRestoreTier"
+
+	// ReplicaDestinationsParam are the buckets the backups are copied
+	// to after being taken, see ParseReplicaDestinations
+	ReplicaDestinationsParam = "replicaDestinations"
+
+	// ObjectTagsParam is a comma separated list of key=value tags added
+	// to the objects of the backups, beside the ones set by the plugin
+	ObjectTagsParam = "objectTags"
+)
+
+// SummarizeWalSetting is the PostgreSQL setting enabling the WAL
+// summarizer, which incremental backups depend on
+const SummarizeWalSetting = "summarize_wal"
+
+const (
+	// ObjectLockModeGovernance allows the retention to be bypassed by
+	// users with the s3:BypassGovernanceRetention permission
+	ObjectLockModeGovernance = "governance"
+
+	// ObjectLockModeCompliance prevents anyone from deleting the objects
+	// before their retention expires
+	ObjectLockModeCompliance = "compliance"
+)
+
+const (
+	// ConcurrencyPolicyForbid skips a scheduled backup while another one is running
+	ConcurrencyPolicyForbid = "forbid"
+
+	// ConcurrencyPolicyReplace cancels the running backup to start the scheduled one
+	ConcurrencyPolicyReplace = "replace"
+)
+
+const (
+	// CompressionGzip compresses the backup archives with gzip
+	CompressionGzip = "gzip"
+
+	// CompressionNone stores the backup archives uncompressed
+	CompressionNone = "none"
 )
 
 // Configuration represents the plugin configuration parameters
 type Configuration struct {
-	Image           string
-	ImagePullPolicy string
-	Region          string
-	Endpoint        string
-	AwsKey          string
-	AwsSecretKey    string
-	Bucket          string
-	Prefix          string
+	Image                  string
+	ImagePullPolicy        string
+	Region                 string
+	Endpoint               string
+	AwsKey                 string
+	AwsSecretKey           string
+	Bucket                 string
+	Prefix                 string
+	Compression            string
+	RetentionPolicy        string
+	AllowSharedPrefix      string
+	SidecarCPURequest      string
+	SidecarCPULimit        string
+	SidecarMemoryRequest   string
+	SidecarMemoryLimit     string
+	SidecarEnv             string
+	SidecarEnvFrom         string
+	ScratchVolumeSize      string
+	ScratchStorageClass    string
+	Schedule               string
+	Immediate              string
+	ScheduleJitter         string
+	ConcurrencyPolicy      string
+	StartingDeadline       string
+	ScheduleBackupType     string
+	LockTimeout            string
+	PreBackupSQL           string
+	PostBackupSQL          string
+	PreBackupCommand       string
+	PostBackupCommand      string
+	HookTimeout            string
+	MaxUploadBandwidth     string
+	MaxDumpRate            string
+	LowPriorityDump        string
+	NoRolePasswords        string
+	SkipManagedRoles       string
+	DumpContent            string
+	PerDatabaseDumps       string
+	ObjectLockMode         string
+	LockRetention          string
+	LegalHold              string
+	StorageClass           string
+	TransitionAfter        string
+	TransitionStorageClass string
+	ArchiveRestoreTier     string
+	ReplicaDestinations    string
+	ObjectTags             string
 }
 
 // FromParameters builds a plugin configuration from the configuration parameters
@@ -35,24 +234,56 @@ func FromParameters(
 	_ context.Context,
 	helper *pluginhelper.Data,
 ) (*Configuration, []*operator.ValidationError) {
-	validationErrors := make([]*operator.ValidationError, 0)
-
-	if len(helper.Parameters[ImageNameParam]) == 0 {
-		validationErrors = append(
-			validationErrors,
-			helper.ValidationErrorForParameter(ImageNameParam, "image cannot be empty"),
-		)
-	}
+	validationErrors := validateParameters(helper)
 
 	configuration := &Configuration{
-		Image:           helper.Parameters[ImageNameParam],
-		ImagePullPolicy: helper.Parameters[ImagePullPolicyParam],
-		Region:          helper.Parameters[RegionParam],
-		Endpoint:        helper.Parameters[EndpointParam],
-		AwsKey:          helper.Parameters[AwsKeyParam],
-		AwsSecretKey:    helper.Parameters[AwsSecretKeyParam],
-		Bucket:          helper.Parameters[BucketParam],
-		Prefix:          helper.Parameters[PrefixParam],
+		Image:                  helper.Parameters[ImageNameParam],
+		ImagePullPolicy:        helper.Parameters[ImagePullPolicyParam],
+		Region:                 helper.Parameters[RegionParam],
+		Endpoint:               helper.Parameters[EndpointParam],
+		AwsKey:                 helper.Parameters[AwsKeyParam],
+		AwsSecretKey:           helper.Parameters[AwsSecretKeyParam],
+		Bucket:                 helper.Parameters[BucketParam],
+		Prefix:                 helper.Parameters[PrefixParam],
+		Compression:            helper.Parameters[CompressionParam],
+		RetentionPolicy:        helper.Parameters[RetentionPolicyParam],
+		AllowSharedPrefix:      helper.Parameters[AllowSharedPrefixParam],
+		SidecarCPURequest:      helper.Parameters[SidecarCPURequestParam],
+		SidecarCPULimit:        helper.Parameters[SidecarCPULimitParam],
+		SidecarMemoryRequest:   helper.Parameters[SidecarMemoryRequestParam],
+		SidecarMemoryLimit:     helper.Parameters[SidecarMemoryLimitParam],
+		SidecarEnv:             helper.Parameters[SidecarEnvParam],
+		SidecarEnvFrom:         helper.Parameters[SidecarEnvFromParam],
+		ScratchVolumeSize:      helper.Parameters[ScratchVolumeSizeParam],
+		ScratchStorageClass:    helper.Parameters[ScratchStorageClassParam],
+		Schedule:               helper.Parameters[ScheduleParam],
+		Immediate:              helper.Parameters[ImmediateParam],
+		ScheduleJitter:         helper.Parameters[ScheduleJitterParam],
+		ConcurrencyPolicy:      helper.Parameters[ConcurrencyPolicyParam],
+		StartingDeadline:       helper.Parameters[StartingDeadlineParam],
+		ScheduleBackupType:     helper.Parameters[ScheduleBackupTypeParam],
+		LockTimeout:            helper.Parameters[LockTimeoutParam],
+		PreBackupSQL:           helper.Parameters[PreBackupSQLParam],
+		PostBackupSQL:          helper.Parameters[PostBackupSQLParam],
+		PreBackupCommand:       helper.Parameters[PreBackupCommandParam],
+		PostBackupCommand:      helper.Parameters[PostBackupCommandParam],
+		HookTimeout:            helper.Parameters[HookTimeoutParam],
+		MaxUploadBandwidth:     helper.Parameters[MaxUploadBandwidthParam],
+		MaxDumpRate:            helper.Parameters[MaxDumpRateParam],
+		LowPriorityDump:        helper.Parameters[LowPriorityDumpParam],
+		NoRolePasswords:        helper.Parameters[NoRolePasswordsParam],
+		SkipManagedRoles:       helper.Parameters[SkipManagedRolesParam],
+		DumpContent:            helper.Parameters[DumpContentParam],
+		PerDatabaseDumps:       helper.Parameters[PerDatabaseDumpsParam],
+		ObjectLockMode:         helper.Parameters[ObjectLockModeParam],
+		LockRetention:          helper.Parameters[LockRetentionParam],
+		LegalHold:              helper.Parameters[LegalHoldParam],
+		StorageClass:           helper.Parameters[StorageClassParam],
+		TransitionAfter:        helper.Parameters[TransitionAfterParam],
+		TransitionStorageClass: helper.Parameters[TransitionStorageClassParam],
+		ArchiveRestoreTier:     helper.Parameters[ArchiveRestoreTierParam],
+		ReplicaDestinations:    helper.Parameters[ReplicaDestinationsParam],
+		ObjectTags:             helper.Parameters[ObjectTagsParam],
 	}
 
 	return configuration, validationErrors
@@ -61,14 +292,53 @@ func FromParameters(
 // ToParameters serialize the configuration to a map of plugin parameters
 func (config *Configuration) ToParameters() (map[string]string, error) {
 	result := map[string]string{
-		ImageNameParam:       config.Image,
-		ImagePullPolicyParam: config.ImagePullPolicy,
-		RegionParam:          config.Region,
-		EndpointParam:        config.Endpoint,
-		AwsKeyParam:          config.AwsKey,
-		AwsSecretKeyParam:    config.AwsSecretKey,
-		BucketParam:          config.Bucket,
-		PrefixParam:          config.Prefix,
+		ImageNameParam:              config.Image,
+		ImagePullPolicyParam:        config.ImagePullPolicy,
+		RegionParam:                 config.Region,
+		EndpointParam:               config.Endpoint,
+		AwsKeyParam:                 config.AwsKey,
+		AwsSecretKeyParam:           config.AwsSecretKey,
+		BucketParam:                 config.Bucket,
+		PrefixParam:                 config.Prefix,
+		CompressionParam:            config.Compression,
+		RetentionPolicyParam:        config.RetentionPolicy,
+		AllowSharedPrefixParam:      config.AllowSharedPrefix,
+		SidecarCPURequestParam:      config.SidecarCPURequest,
+		SidecarCPULimitParam:        config.SidecarCPULimit,
+		SidecarMemoryRequestParam:   config.SidecarMemoryRequest,
+		SidecarMemoryLimitParam:     config.SidecarMemoryLimit,
+		SidecarEnvParam:             config.SidecarEnv,
+		SidecarEnvFromParam:         config.SidecarEnvFrom,
+		ScratchVolumeSizeParam:      config.ScratchVolumeSize,
+		ScratchStorageClassParam:    config.ScratchStorageClass,
+		ScheduleParam:               config.Schedule,
+		ImmediateParam:              config.Immediate,
+		ScheduleJitterParam:         config.ScheduleJitter,
+		ConcurrencyPolicyParam:      config.ConcurrencyPolicy,
+		StartingDeadlineParam:       config.StartingDeadline,
+		ScheduleBackupTypeParam:     config.ScheduleBackupType,
+		LockTimeoutParam:            config.LockTimeout,
+		PreBackupSQLParam:           config.PreBackupSQL,
+		PostBackupSQLParam:          config.PostBackupSQL,
+		PreBackupCommandParam:       config.PreBackupCommand,
+		PostBackupCommandParam:      config.PostBackupCommand,
+		HookTimeoutParam:            config.HookTimeout,
+		MaxUploadBandwidthParam:     config.MaxUploadBandwidth,
+		MaxDumpRateParam:            config.MaxDumpRate,
+		LowPriorityDumpParam:        config.LowPriorityDump,
+		NoRolePasswordsParam:        config.NoRolePasswords,
+		SkipManagedRolesParam:       config.SkipManagedRoles,
+		DumpContentParam:            config.DumpContent,
+		PerDatabaseDumpsParam:       config.PerDatabaseDumps,
+		ObjectLockModeParam:         config.ObjectLockMode,
+		LockRetentionParam:          config.LockRetention,
+		LegalHoldParam:              config.LegalHold,
+		StorageClassParam:           config.StorageClass,
+		TransitionAfterParam:        config.TransitionAfter,
+		TransitionStorageClassParam: config.TransitionStorageClass,
+		ArchiveRestoreTierParam:     config.ArchiveRestoreTier,
+		ReplicaDestinationsParam:    config.ReplicaDestinations,
+		ObjectTagsParam:             config.ObjectTags,
 	}
 
 	return result, nil
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	apiv1 "github.com/cloudnative-pg/cloudnative-pg/api/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
+)
+
+// DefaultRetentionPolicy is the retention policy of the clusters created
+// without one
+const DefaultRetentionPolicy = "30d"
+
+var retentionPolicyRegex = regexp.MustCompile(`^([1-9][0-9]*)([dwm])$`)
+
+// SetDefaults fills the empty plugin parameters of a cluster with their
+// default values. Every other parameter, including the ones this
+// version of the plugin doesn't know about, is left untouched.
+//
+// The prefix and the retention policy are only defaulted for clusters
+// being created: existing clusters without a prefix keep storing their
+// backups at the root of the bucket, where their previous backups are,
+// and existing clusters without a retention policy keep every backup
+// they took rather than having them pruned by an upgrade of the plugin
+func SetDefaults(cluster *apiv1.Cluster, parameters map[string]string) map[string]string {
+	result := make(map[string]string, len(parameters))
+	for name, value := range parameters {
+		result[name] = value
+	}
+
+	defaults := map[string]string{
+		ImageNameParam:       metadata.DefaultImage(),
+		ImagePullPolicyParam: string(corev1.PullIfNotPresent),
+		CompressionParam:     CompressionGzip,
+	}
+	if cluster.CreationTimestamp.IsZero() {
+		defaults[PrefixParam] = path.Join(cluster.Namespace, cluster.Name)
+		defaults[RetentionPolicyParam] = DefaultRetentionPolicy
+	}
+	for name, value := range defaults {
+		if len(result[name]) == 0 {
+			result[name] = value
+		}
+	}
+
+	return result
+}
+
+// RetentionCutoff computes the time before which backups are expired
+// according to a retention policy such as "30d", "4w" or "6m"
+func RetentionCutoff(policy string, now time.Time) (time.Time, error) {
+	months, days, err := parseRetentionPeriod(policy)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid retention policy: %w", err)
+	}
+
+	return now.AddDate(0, -months, -days), nil
+}
+
+// RetainUntil computes the time until which an object written now must be
+// kept, according to a retention period such as "30d", "4w" or "6m"
+func RetainUntil(period string, now time.Time) (time.Time, error) {
+	months, days, err := parseRetentionPeriod(period)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid retention period: %w", err)
+	}
+
+	return now.AddDate(0, months, days), nil
+}
+
+// parseRetentionPeriod splits a retention period into months and days
+func parseRetentionPeriod(period string) (int, int, error) {
+	matches := retentionPolicyRegex.FindStringSubmatch(period)
+	if matches == nil {
+		return 0, 0, fmt.Errorf(
+			"invalid period %q, expected a number followed by d (days), w (weeks) or m (months)", period)
+	}
+
+	value, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch matches[2] {
+	case "w":
+		return 0, 7 * value, nil
+	case "m":
+		return value, 0, nil
+	default:
+		return 0, value, nil
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReplicaDestination is a bucket the backups are copied to after being
+// taken, possibly in another region or on another provider
+type ReplicaDestination struct {
+	Bucket   string
+	Prefix   string
+	Region   string
+	Endpoint string
+
+	// AccessKey and SecretKey are the static credentials of the
+	// replica, the default AWS credential chain is used when empty
+	AccessKey string
+	SecretKey string
+
+	// ForcePathStyle addresses the bucket in the path of the URL,
+	// as needed by most S3 compatible object storages
+	ForcePathStyle bool
+}
+
+// Name identifies the replica in the logs and in the catalog
+func (destination ReplicaDestination) Name() string {
+	if len(destination.Endpoint) > 0 {
+		return destination.Endpoint + "/" + destination.Bucket
+	}
+	return destination.Bucket
+}
+
+// ParseReplicaDestinations parses a semicolon separated list of replicas,
+// each being a comma separated list of key=value settings, such as
+// "bucket=dr,region=us-west-2;bucket=offsite,endpoint=https://minio:9000"
+func ParseReplicaDestinations(value string) ([]ReplicaDestination, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	items := strings.Split(value, ";")
+	result := make([]ReplicaDestination, 0, len(items))
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		// The destinations hold credentials, so they are
+		// referenced by position rather than quoted
+		destination, err := parseReplicaDestination(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replica destination #%d: %w", i+1, err)
+		}
+		result = append(result, destination)
+	}
+
+	return result, nil
+}
+
+func parseReplicaDestination(value string) (ReplicaDestination, error) {
+	var destination ReplicaDestination
+	for _, setting := range strings.Split(value, ",") {
+		key, content, found := strings.Cut(strings.TrimSpace(setting), "=")
+		if !found {
+			return destination, fmt.Errorf("invalid setting %q, expected key=value", key)
+		}
+
+		var message string
+		switch key {
+		case "bucket":
+			destination.Bucket = content
+			message = validateBucket(content)
+		case "prefix":
+			destination.Prefix = content
+			message = validatePrefix(content)
+		case "region":
+			destination.Region = content
+			message = validateRegion(content)
+		case "endpoint":
+			destination.Endpoint = content
+			message = validateEndpoint(content)
+		case "accessKey":
+			destination.AccessKey = content
+		case "secretKey":
+			destination.SecretKey = content
+		case "forcePathStyle":
+			if content != "true" && content != "false" {
+				message = fmt.Sprintf("invalid value %q for forcePathStyle, expected true or false", content)
+			}
+			destination.ForcePathStyle = content == "true"
+		default:
+			message = fmt.Sprintf("unknown setting %q, expected one of: "+
+				"bucket, prefix, region, endpoint, accessKey, secretKey, forcePathStyle", key)
+		}
+		if len(message) > 0 {
+			return destination, errors.New(message)
+		}
+	}
+
+	if len(destination.Bucket) == 0 {
+		return destination, errors.New("the bucket is required")
+	}
+	if (len(destination.AccessKey) > 0) != (len(destination.SecretKey) > 0) {
+		return destination, errors.New("accessKey and secretKey must be set together")
+	}
+
+	return destination, nil
+}
+
+func validateReplicaDestinations(value string) string {
+	if _, err := ParseReplicaDestinations(value); err != nil {
+		return err.Error()
+	}
+	return ""
+}
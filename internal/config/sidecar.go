@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ParseEnv parses a comma separated list of NAME=value pairs into
+// the environment variables to be added to the sidecar
+func ParseEnv(value string) ([]corev1.EnvVar, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	items := strings.Split(value, ",")
+	result := make([]corev1.EnvVar, 0, len(items))
+	for _, item := range items {
+		name, content, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid environment variable %q, expected NAME=value", item)
+		}
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid environment variable name %q: %s", name, strings.Join(errs, ", "))
+		}
+
+		result = append(result, corev1.EnvVar{Name: name, Value: content})
+	}
+
+	return result, nil
+}
+
+// ParseEnvFrom parses a comma separated list of secret/<name> and
+// configMap/<name> references into the sources of the sidecar environment
+func ParseEnvFrom(value string) ([]corev1.EnvFromSource, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	items := strings.Split(value, ",")
+	result := make([]corev1.EnvFromSource, 0, len(items))
+	for _, item := range items {
+		kind, name, found := strings.Cut(strings.TrimSpace(item), "/")
+		if !found || len(name) == 0 {
+			return nil, fmt.Errorf("invalid environment source %q, expected secret/<name> or configMap/<name>", item)
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid environment source name %q: %s", name, strings.Join(errs, ", "))
+		}
+
+		reference := corev1.LocalObjectReference{Name: name}
+		switch kind {
+		case "secret":
+			result = append(result, corev1.EnvFromSource{
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: reference},
+			})
+		case "configMap":
+			result = append(result, corev1.EnvFromSource{
+				ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: reference},
+			})
+		default:
+			return nil, fmt.Errorf("invalid environment source kind %q, expected secret or configMap", kind)
+		}
+	}
+
+	return result, nil
+}
+
+// ParseResources builds the sidecar resource requirements from the
+// CPU and memory requests and limits parameters
+func ParseResources(parameters map[string]string) (corev1.ResourceRequirements, error) {
+	var result corev1.ResourceRequirements
+
+	quantities := []struct {
+		param string
+		name  corev1.ResourceName
+		limit bool
+	}{
+		{SidecarCPURequestParam, corev1.ResourceCPU, false},
+		{SidecarMemoryRequestParam, corev1.ResourceMemory, false},
+		{SidecarCPULimitParam, corev1.ResourceCPU, true},
+		{SidecarMemoryLimitParam, corev1.ResourceMemory, true},
+	}
+
+	for _, item := range quantities {
+		if len(parameters[item.param]) == 0 {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(parameters[item.param])
+		if err != nil {
+			return result, fmt.Errorf("invalid %s %q: %w", item.param, parameters[item.param], err)
+		}
+
+		if item.limit {
+			if result.Limits == nil {
+				result.Limits = corev1.ResourceList{}
+			}
+			result.Limits[item.name] = quantity
+		} else {
+			if result.Requests == nil {
+				result.Requests = corev1.ResourceList{}
+			}
+			result.Requests[item.name] = quantity
+		}
+	}
+
+	return result, nil
+}
+
+// checkResourceBounds ensures that no request is greater than its limit,
+// returning the parameter holding the offending request
+func checkResourceBounds(requirements corev1.ResourceRequirements) (string, error) {
+	params := map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    SidecarCPURequestParam,
+		corev1.ResourceMemory: SidecarMemoryRequestParam,
+	}
+
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		request, hasRequest := requirements.Requests[name]
+		limit, hasLimit := requirements.Limits[name]
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			return params[name], fmt.Errorf("the %s request %s is greater than its limit %s",
+				name, request.String(), limit.String())
+		}
+	}
+
+	return "", nil
+}
+
+// ParseRate parses a rate in bytes per second expressed as a
+// quantity, like 10Mi. An empty value means no limit
+func ParseRate(value string) (int64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", value, err)
+	}
+	if quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, it must be positive", value)
+	}
+
+	return quantity.Value(), nil
+}
+
+func validateQuantity(value string) string {
+	if _, err := resource.ParseQuantity(value); err != nil {
+		return fmt.Sprintf("invalid quantity %q: %s", value, err.Error())
+	}
+	return ""
+}
+
+func validateEnv(value string) string {
+	if _, err := ParseEnv(value); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func validateEnvFrom(value string) string {
+	if _, err := ParseEnvFrom(value); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func validateStorageClass(value string) string {
+	if errs := validation.IsDNS1123Subdomain(value); len(errs) > 0 {
+		return fmt.Sprintf("invalid storage class name %q: %s", value, strings.Join(errs, ", "))
+	}
+	return ""
+}
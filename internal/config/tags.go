@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxObjectTags is the number of tags which can be set by the user.
+// S3 allows ten tags per object, and the plugin uses six of them
+const MaxObjectTags = 4
+
+// ReservedTagPrefix is the prefix of the tags set by the plugin
+const ReservedTagPrefix = "cnpg.io/"
+
+var tagRegex = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// ParseObjectTags parses a comma separated list of key=value pairs into
+// the tags of the objects of the backups
+func ParseObjectTags(value string) (map[string]string, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		key, content, found := strings.Cut(strings.TrimSpace(item), "=")
+		switch {
+		case !found || len(key) == 0:
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", item)
+		case len(key) > 128:
+			return nil, fmt.Errorf("invalid tag key %q, it cannot be longer than 128 characters", key)
+		case len(content) > 256:
+			return nil, fmt.Errorf("invalid value of tag %q, it cannot be longer than 256 characters", key)
+		case !tagRegex.MatchString(key) || !tagRegex.MatchString(content):
+			return nil, fmt.Errorf("invalid tag %q, only letters, numbers, spaces and _ . : / = + - @ are allowed", item)
+		case strings.HasPrefix(key, "aws:") || strings.HasPrefix(key, ReservedTagPrefix):
+			return nil, fmt.Errorf("invalid tag key %q, the aws: and %s prefixes are reserved", key, ReservedTagPrefix)
+		}
+		if _, duplicate := result[key]; duplicate {
+			return nil, fmt.Errorf("duplicate tag %q", key)
+		}
+
+		result[key] = content
+	}
+
+	if len(result) > MaxObjectTags {
+		return nil, fmt.Errorf("too many tags, at most %d can be set", MaxObjectTags)
+	}
+
+	return result, nil
+}
+
+func validateObjectTags(value string) string {
+	if _, err := ParseObjectTags(value); err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,384 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/pluginhelper"
+	"github.com/cloudnative-pg/cnpg-i/pkg/operator"
+	"github.com/robfig/cron"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	bucketRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	regionRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// parameterSpec describes how a plugin parameter is validated
+type parameterSpec struct {
+	// required is true when the parameter cannot be empty
+	required bool
+
+	// enum, when set, is the list of accepted values
+	enum []string
+
+	// validate checks a non-empty value, returning an error
+	// message when the value is not acceptable
+	validate func(value string) string
+}
+
+// parameterSchema is the set of parameters accepted by the plugin
+var parameterSchema = map[string]parameterSpec{
+	ImageNameParam: {required: true},
+	ImagePullPolicyParam: {
+		enum: []string{
+			string(corev1.PullAlways),
+			string(corev1.PullIfNotPresent),
+			string(corev1.PullNever),
+		},
+	},
+	RegionParam:       {validate: validateRegion},
+	EndpointParam:     {validate: validateEndpoint},
+	AwsKeyParam:       {},
+	AwsSecretKeyParam: {},
+	BucketParam:       {required: true, validate: validateBucket},
+	PrefixParam:       {validate: validatePrefix},
+	CompressionParam: {
+		enum: []string{CompressionGzip, CompressionNone},
+	},
+	RetentionPolicyParam: {validate: validatePeriod(RetentionPolicyParam)},
+	AllowSharedPrefixParam: {
+		enum: []string{"true", "false"},
+	},
+	SidecarCPURequestParam:    {validate: validateQuantity},
+	SidecarCPULimitParam:      {validate: validateQuantity},
+	SidecarMemoryRequestParam: {validate: validateQuantity},
+	SidecarMemoryLimitParam:   {validate: validateQuantity},
+	SidecarEnvParam:           {validate: validateEnv},
+	SidecarEnvFromParam:       {validate: validateEnvFrom},
+	ScratchVolumeSizeParam:    {validate: validateQuantity},
+	ScratchStorageClassParam:  {validate: validateStorageClass},
+	ScheduleParam:             {validate: validateSchedule},
+	ImmediateParam: {
+		enum: []string{"true", "false"},
+	},
+	ScheduleJitterParam: {validate: validateDuration},
+	ConcurrencyPolicyParam: {
+		enum: []string{ConcurrencyPolicyForbid, ConcurrencyPolicyReplace},
+	},
+	StartingDeadlineParam: {validate: validateDuration},
+	ScheduleBackupTypeParam: {
+		enum: []string{"logical", "full", "incremental"},
+	},
+	LockTimeoutParam:        {validate: validateDuration},
+	PreBackupSQLParam:       {},
+	PostBackupSQLParam:      {},
+	PreBackupCommandParam:   {},
+	PostBackupCommandParam:  {},
+	HookTimeoutParam:        {validate: validateDuration},
+	MaxUploadBandwidthParam: {validate: validateRate},
+	MaxDumpRateParam:        {validate: validateRate},
+	LowPriorityDumpParam: {
+		enum: []string{"true", "false"},
+	},
+	NoRolePasswordsParam: {
+		enum: []string{"true", "false"},
+	},
+	SkipManagedRolesParam: {
+		enum: []string{"true", "false"},
+	},
+	DumpContentParam: {
+		enum: []string{"all", "schema", "data"},
+	},
+	PerDatabaseDumpsParam: {
+		enum: []string{"true", "false"},
+	},
+	ObjectLockModeParam: {
+		enum: []string{ObjectLockModeGovernance, ObjectLockModeCompliance},
+	},
+	LockRetentionParam: {validate: validatePeriod(LockRetentionParam)},
+	LegalHoldParam: {
+		enum: []string{"true", "false"},
+	},
+	StorageClassParam:           {enum: storageClasses},
+	TransitionAfterParam:        {validate: validatePeriod(TransitionAfterParam)},
+	TransitionStorageClassParam: {enum: storageClasses},
+	ArchiveRestoreTierParam: {
+		enum: []string{"Expedited", "Standard", "Bulk"},
+	},
+	ReplicaDestinationsParam: {validate: validateReplicaDestinations},
+	ObjectTagsParam:          {validate: validateObjectTags},
+}
+
+// storageClasses are the S3 storage classes backup archives can be stored in
+var storageClasses = []string{
+	"STANDARD",
+	"REDUCED_REDUNDANCY",
+	"STANDARD_IA",
+	"ONEZONE_IA",
+	"INTELLIGENT_TIERING",
+	"GLACIER_IR",
+	"GLACIER",
+	"DEEP_ARCHIVE",
+}
+
+// validateParameters validates the plugin parameters against the schema,
+// returning every error found
+func validateParameters(helper *pluginhelper.Data) []*operator.ValidationError {
+	validationErrors := make([]*operator.ValidationError, 0)
+
+	names := make([]string, 0, len(helper.Parameters))
+	for name := range helper.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, known := parameterSchema[name]; known {
+			continue
+		}
+
+		message := fmt.Sprintf("unknown parameter %q", name)
+		if suggestion := suggestParameter(name); len(suggestion) > 0 {
+			message = fmt.Sprintf("%s, did you mean %q?", message, suggestion)
+		}
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(name, message))
+	}
+
+	for _, name := range knownParameters() {
+		spec := parameterSchema[name]
+		value := helper.Parameters[name]
+
+		switch {
+		case len(value) == 0 && spec.required:
+			validationErrors = append(
+				validationErrors,
+				helper.ValidationErrorForParameter(name, fmt.Sprintf("%s cannot be empty", name)),
+			)
+		case len(value) == 0:
+		case len(spec.enum) > 0 && !contains(spec.enum, value):
+			validationErrors = append(
+				validationErrors,
+				helper.ValidationErrorForParameter(name, fmt.Sprintf(
+					"invalid value %q for %s, expected one of: %s", value, name, strings.Join(spec.enum, ", "))),
+			)
+		case spec.validate != nil:
+			if message := spec.validate(value); len(message) > 0 {
+				validationErrors = append(validationErrors, helper.ValidationErrorForParameter(name, message))
+			}
+		}
+	}
+
+	// Static credentials need both halves of the key pair, otherwise
+	// the default AWS credential chain is used
+	hasKey := len(helper.Parameters[AwsKeyParam]) > 0
+	hasSecret := len(helper.Parameters[AwsSecretKeyParam]) > 0
+	if hasKey && !hasSecret {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			AwsSecretKeyParam, fmt.Sprintf("%s is required when %s is set", AwsSecretKeyParam, AwsKeyParam)))
+	}
+	if hasSecret && !hasKey {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			AwsKeyParam, fmt.Sprintf("%s is required when %s is set", AwsKeyParam, AwsSecretKeyParam)))
+	}
+
+	// An ephemeral volume needs to know how much storage to request
+	if len(helper.Parameters[ScratchStorageClassParam]) > 0 && len(helper.Parameters[ScratchVolumeSizeParam]) == 0 {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			ScratchVolumeSizeParam,
+			fmt.Sprintf("%s is required when %s is set", ScratchVolumeSizeParam, ScratchStorageClassParam)))
+	}
+
+	// The retention of the object lock needs both its mode and its period
+	hasLockMode := len(helper.Parameters[ObjectLockModeParam]) > 0
+	hasLockRetention := len(helper.Parameters[LockRetentionParam]) > 0
+	if hasLockMode && !hasLockRetention {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			LockRetentionParam, fmt.Sprintf("%s is required when %s is set", LockRetentionParam, ObjectLockModeParam)))
+	}
+	if hasLockRetention && !hasLockMode {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			ObjectLockModeParam, fmt.Sprintf("%s is required when %s is set", ObjectLockModeParam, LockRetentionParam)))
+	}
+
+	// Archives are moved to a colder storage class after a given age
+	hasTransitionAfter := len(helper.Parameters[TransitionAfterParam]) > 0
+	hasTransitionClass := len(helper.Parameters[TransitionStorageClassParam]) > 0
+	if hasTransitionAfter && !hasTransitionClass {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			TransitionStorageClassParam,
+			fmt.Sprintf("%s is required when %s is set", TransitionStorageClassParam, TransitionAfterParam)))
+	}
+	if hasTransitionClass && !hasTransitionAfter {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			TransitionAfterParam,
+			fmt.Sprintf("%s is required when %s is set", TransitionAfterParam, TransitionStorageClassParam)))
+	}
+
+	// pg_basebackup --incremental relies on the WAL summaries, which
+	// PostgreSQL only writes when summarize_wal is enabled
+	if helper.Parameters[ScheduleBackupTypeParam] == "incremental" &&
+		!isEnabled(helper.GetCluster().Spec.PostgresConfiguration.Parameters[SummarizeWalSetting]) {
+		validationErrors = append(validationErrors, helper.ValidationErrorForParameter(
+			ScheduleBackupTypeParam,
+			fmt.Sprintf("incremental backups need WAL summarization, set %s to \"on\" "+
+				"in the postgresql parameters of the cluster", SummarizeWalSetting)))
+	}
+
+	// Quantities which cannot be parsed have already been reported
+	if resources, err := ParseResources(helper.Parameters); err == nil {
+		if name, err := checkResourceBounds(resources); err != nil {
+			validationErrors = append(validationErrors, helper.ValidationErrorForParameter(name, err.Error()))
+		}
+	}
+
+	return validationErrors
+}
+
+// knownParameters returns the names of the parameters in the schema, sorted
+func knownParameters() []string {
+	names := make([]string, 0, len(parameterSchema))
+	for name := range parameterSchema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateRegion(value string) string {
+	if !regionRegex.MatchString(value) {
+		return fmt.Sprintf("invalid region %q, expected something like us-east-1", value)
+	}
+	return ""
+}
+
+func validateEndpoint(value string) string {
+	endpoint, err := url.Parse(value)
+	if err != nil {
+		return fmt.Sprintf("invalid endpoint URL %q: %s", value, err.Error())
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return fmt.Sprintf("invalid endpoint URL %q, the scheme must be http or https", value)
+	}
+	if len(endpoint.Host) == 0 {
+		return fmt.Sprintf("invalid endpoint URL %q, the host is missing", value)
+	}
+	return ""
+}
+
+func validateBucket(value string) string {
+	if !bucketRegex.MatchString(value) || strings.Contains(value, "..") {
+		return fmt.Sprintf("invalid bucket name %q, it must be 3 to 63 lowercase letters, "+
+			"numbers, dots and hyphens, starting and ending with a letter or a number", value)
+	}
+	return ""
+}
+
+func validatePrefix(value string) string {
+	if strings.HasPrefix(value, "/") {
+		return fmt.Sprintf("invalid prefix %q, it must not start with a slash", value)
+	}
+	if strings.Contains(value, "//") {
+		return fmt.Sprintf("invalid prefix %q, it must not contain empty path segments", value)
+	}
+	return ""
+}
+
+// validatePeriod validates the periods such as "30d", "4w" or "6m" of
+// the passed parameter
+func validatePeriod(name string) func(value string) string {
+	return func(value string) string {
+		if _, _, err := parseRetentionPeriod(value); err != nil {
+			return fmt.Sprintf("invalid %s: %s", name, err.Error())
+		}
+		return ""
+	}
+}
+
+func validateSchedule(value string) string {
+	if _, err := cron.ParseStandard(value); err != nil {
+		return fmt.Sprintf("invalid cron schedule %q: %s", value, err.Error())
+	}
+	return ""
+}
+
+func validateDuration(value string) string {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Sprintf("invalid duration %q: %s", value, err.Error())
+	}
+	if duration < 0 {
+		return fmt.Sprintf("invalid duration %q, it cannot be negative", value)
+	}
+	return ""
+}
+
+func validateRate(value string) string {
+	if _, err := ParseRate(value); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// suggestParameter finds the known parameter closest to an unknown one
+func suggestParameter(name string) string {
+	const maxDistance = 3
+
+	best := ""
+	bestDistance := maxDistance + 1
+	for _, candidate := range knownParameters() {
+		distance := levenshtein(strings.ToLower(name), strings.ToLower(candidate))
+		if distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+
+	return best
+}
+
+// levenshtein computes the edit distance between two strings
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isEnabled is true when a PostgreSQL boolean setting is turned on
+func isEnabled(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "on", "true", "yes", "1":
+		return true
+	}
+	return false
+}
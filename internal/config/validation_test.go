@@ -0,0 +1,215 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/cloudnative-pg/cloudnative-pg/api/v1"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/pluginhelper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
+)
+
+// newHelper decodes a cluster using the plugin with the passed
+// parameters and PostgreSQL settings
+func newHelper(t *testing.T, parameters map[string]string, postgresParameters map[string]string) *pluginhelper.Data {
+	t.Helper()
+
+	cluster := apiv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cluster-example"},
+		Spec: apiv1.ClusterSpec{
+			PostgresConfiguration: apiv1.PostgresConfiguration{Parameters: postgresParameters},
+			Plugins: apiv1.PluginConfigurationList{
+				{Name: metadata.PluginName, Parameters: parameters},
+			},
+		},
+	}
+	definition, err := json.Marshal(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper, err := pluginhelper.NewDataBuilder(metadata.PluginName, definition).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return helper
+}
+
+func TestValidateParameters(t *testing.T) {
+	tests := []struct {
+		name               string
+		parameters         map[string]string
+		postgresParameters map[string]string
+
+		// want maps the parameters expected to be reported to
+		// a part of their message
+		want map[string]string
+	}{
+		{
+			name: "valid parameters",
+			parameters: map[string]string{
+				CompressionParam:            CompressionNone,
+				RetentionPolicyParam:        "30d",
+				ScheduleParam:               "0 0 * * *",
+				LockTimeoutParam:            "10m",
+				ObjectLockModeParam:         ObjectLockModeGovernance,
+				LockRetentionParam:          "4w",
+				TransitionAfterParam:        "6m",
+				StorageClassParam:           "STANDARD",
+				EndpointParam:               "https://s3.example.com",
+				RegionParam:                 "eu-west-1",
+				MaxUploadBandwidthParam:     "10Mi",
+				TransitionStorageClassParam: "GLACIER",
+			},
+		},
+		{
+			name:       "missing required parameter",
+			parameters: map[string]string{BucketParam: ""},
+			want:       map[string]string{BucketParam: "bucket cannot be empty"},
+		},
+		{
+			name:       "unknown parameter close to a known one",
+			parameters: map[string]string{"retentionPolcy": "30d"},
+			want:       map[string]string{"retentionPolcy": `unknown parameter "retentionPolcy", did you mean "retentionPolicy"?`},
+		},
+		{
+			name:       "unknown parameter far from the known ones",
+			parameters: map[string]string{"somethingElse": "value"},
+			want:       map[string]string{"somethingElse": `unknown parameter "somethingElse"`},
+		},
+		{
+			name:       "value outside of the enum",
+			parameters: map[string]string{CompressionParam: "zstd"},
+			want:       map[string]string{CompressionParam: `invalid value "zstd" for compression, expected one of: gzip, none`},
+		},
+		{
+			name: "invalid values",
+			parameters: map[string]string{
+				BucketParam:      "Invalid_Bucket",
+				RegionParam:      "EU West",
+				EndpointParam:    "s3.example.com",
+				PrefixParam:      "/backups",
+				ScheduleParam:    "every day",
+				HookTimeoutParam: "-1s",
+			},
+			want: map[string]string{
+				BucketParam:      "invalid bucket name",
+				RegionParam:      "invalid region",
+				EndpointParam:    "the scheme must be http or https",
+				PrefixParam:      "must not start with a slash",
+				ScheduleParam:    "invalid cron schedule",
+				HookTimeoutParam: "cannot be negative",
+			},
+		},
+		{
+			name: "periods are reported with their parameter",
+			parameters: map[string]string{
+				RetentionPolicyParam:        "30 days",
+				ObjectLockModeParam:         ObjectLockModeGovernance,
+				LockRetentionParam:          "1y",
+				TransitionAfterParam:        "forever",
+				TransitionStorageClassParam: "GLACIER",
+			},
+			want: map[string]string{
+				RetentionPolicyParam: `invalid retentionPolicy: invalid period "30 days"`,
+				LockRetentionParam:   `invalid lockRetention: invalid period "1y"`,
+				TransitionAfterParam: `invalid transitionAfter: invalid period "forever"`,
+			},
+		},
+		{
+			name:       "access key without its secret",
+			parameters: map[string]string{AwsKeyParam: "key"},
+			want:       map[string]string{AwsSecretKeyParam: "aws_secret_key is required when aws_key is set"},
+		},
+		{
+			name:       "secret without its access key",
+			parameters: map[string]string{AwsSecretKeyParam: "secret"},
+			want:       map[string]string{AwsKeyParam: "aws_key is required when aws_secret_key is set"},
+		},
+		{
+			name:       "scratch storage class without a size",
+			parameters: map[string]string{ScratchStorageClassParam: "standard"},
+			want:       map[string]string{ScratchVolumeSizeParam: "scratchVolumeSize is required when scratchStorageClass is set"},
+		},
+		{
+			name:       "object lock mode without a retention",
+			parameters: map[string]string{ObjectLockModeParam: ObjectLockModeCompliance},
+			want:       map[string]string{LockRetentionParam: "lockRetention is required when objectLockMode is set"},
+		},
+		{
+			name:       "object lock retention without a mode",
+			parameters: map[string]string{LockRetentionParam: "30d"},
+			want:       map[string]string{ObjectLockModeParam: "objectLockMode is required when lockRetention is set"},
+		},
+		{
+			name:       "transition age without a storage class",
+			parameters: map[string]string{TransitionAfterParam: "30d"},
+			want: map[string]string{
+				TransitionStorageClassParam: "transitionStorageClass is required when transitionAfter is set",
+			},
+		},
+		{
+			name:       "transition storage class without an age",
+			parameters: map[string]string{TransitionStorageClassParam: "GLACIER"},
+			want:       map[string]string{TransitionAfterParam: "transitionAfter is required when transitionStorageClass is set"},
+		},
+		{
+			name:       "incremental backups without WAL summarization",
+			parameters: map[string]string{ScheduleBackupTypeParam: "incremental"},
+			want:       map[string]string{ScheduleBackupTypeParam: "incremental backups need WAL summarization"},
+		},
+		{
+			name:               "incremental backups with WAL summarization",
+			parameters:         map[string]string{ScheduleBackupTypeParam: "incremental"},
+			postgresParameters: map[string]string{SummarizeWalSetting: "on"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parameters := map[string]string{
+				ImageNameParam: "plugin:latest",
+				BucketParam:    "backups",
+			}
+			for name, value := range tt.parameters {
+				parameters[name] = value
+			}
+
+			got := map[string]string{}
+			for _, validationError := range validateParameters(newHelper(t, parameters, tt.postgresParameters)) {
+				name := validationError.PathComponents[len(validationError.PathComponents)-1]
+				got[name] = validationError.Message
+			}
+
+			if len(got) != len(tt.want) {
+				t.Errorf("got errors %v, want %v", got, tt.want)
+			}
+			for name, message := range tt.want {
+				if !strings.Contains(got[name], message) {
+					t.Errorf("error of %s is %q, want %q", name, got[name], message)
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestParameter(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "bukcet", want: BucketParam},
+		{name: "Compression", want: CompressionParam},
+		{name: "imagePullPolcy", want: ImagePullPolicyParam},
+		{name: "somethingElse", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := suggestParameter(tt.name); got != tt.want {
+			t.Errorf("suggestParameter(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
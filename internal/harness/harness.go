@@ -0,0 +1,206 @@
+// Package harness runs the backups and the restores of the plugin with no
+// network and no PostgreSQL: the object storage is kept in memory, the
+// CloudNativePG instance manager is faked and the PostgreSQL client
+// binaries are replaced by shell scripts.
+//
+// The working directory of the backups and the fake binaries are passed
+// to the executor through the dependencies, so every harness is isolated
+package harness
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+// Harness is a fake environment for the sidecar
+type Harness struct {
+	// Bucket is the bucket created in the object storage
+	Bucket string
+
+	S3       *S3Server
+	Instance *InstanceServer
+
+	// Dir holds the working directory of the backups and the fake binaries
+	Dir string
+}
+
+// New starts the fake object storage, holding the passed bucket, and the
+// fake instance manager, and installs the fake PostgreSQL binaries
+func New(bucket string) (*Harness, error) {
+	dir, err := os.MkdirTemp("", "s3-backup-harness-")
+	if err != nil {
+		return nil, err
+	}
+
+	binDir := filepath.Join(dir, "bin")
+	workingDir := filepath.Join(dir, "backup")
+	for _, path := range []string{binDir, workingDir} {
+		if err := os.Mkdir(path, 0o750); err != nil {
+			return nil, err
+		}
+	}
+	if err := installBinaries(binDir); err != nil {
+		return nil, err
+	}
+
+	return &Harness{
+		Bucket:   bucket,
+		S3:       NewS3Server(bucket),
+		Instance: NewInstanceServer(),
+		Dir:      dir,
+	}, nil
+}
+
+// Close stops the fake servers and removes the working directory
+func (h *Harness) Close() {
+	h.S3.Close()
+	h.Instance.Close()
+
+	_ = os.RemoveAll(h.Dir)
+}
+
+// WorkingDir is the working directory of the backups of the harness
+func (h *Harness) WorkingDir() string {
+	return filepath.Join(h.Dir, "backup")
+}
+
+// Dependencies are the clients reaching the fake servers, together with
+// the working directory and the fake binaries of the harness
+func (h *Harness) Dependencies() executor.Dependencies {
+	cfg := aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("harness", "harness", ""),
+		BaseEndpoint: aws.String(h.S3.URL()),
+	}
+
+	return executor.Dependencies{
+		AWSConfig: &cfg,
+		S3Options: []func(*s3.Options){
+			func(o *s3.Options) {
+				o.UsePathStyle = true
+			},
+		},
+		Instance: executor.NewInstanceClient(h.Instance.URL()),
+		Environment: executor.Environment{
+			WorkingDir: h.WorkingDir(),
+			BinDir:     filepath.Join(h.Dir, "bin"),
+		},
+	}
+}
+
+// Options are the options of a backup of the passed cluster into the
+// bucket of the harness
+func (h *Harness) Options(namespace string, name string) backup.Options {
+	return backup.Options{
+		Bucket:      h.Bucket,
+		Endpoint:    h.S3.URL(),
+		Prefix:      namespace + "/" + name,
+		Compression: "gzip",
+		BackupType:  executor.BackupTypeLogical,
+		Cluster: executor.LineageOwner{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Dependencies: h.Dependencies(),
+	}
+}
+
+// Repository opens the repository of the passed cluster
+func (h *Harness) Repository(namespace string, name string) (*executor.Repository, error) {
+	return executor.NewRepositoryWithDependencies(h.Bucket, namespace+"/"+name, "gzip", h.Dependencies())
+}
+
+// Backup takes a backup with the passed options
+func (h *Harness) Backup(ctx context.Context, options backup.Options) (string, error) {
+	result, err := backup.PerformBackup(ctx, options)
+	if err != nil {
+		return "", err
+	}
+
+	return result.BackupId, nil
+}
+
+// SetDump sets the output of the fake pg_dumpall
+func (h *Harness) SetDump(content string) error {
+	return h.writeState("dump.sql", content)
+}
+
+// SetDatabaseSize sets the size of the databases reported by the fake psql
+func (h *Harness) SetDatabaseSize(size string) error {
+	return h.writeState("database_size", size)
+}
+
+// SetWalSince sets the WAL written since the parent of an incremental
+// backup reported by the fake psql
+func (h *Harness) SetWalSince(size string) error {
+	return h.writeState("wal_since", size)
+}
+
+// SetInRecovery makes the local instance a replica
+func (h *Harness) SetInRecovery(inRecovery bool) error {
+	if inRecovery {
+		return h.writeState("in_recovery", "t")
+	}
+	return h.writeState("in_recovery", "f")
+}
+
+// SetWalSummarization turns summarize_wal on or off
+func (h *Harness) SetWalSummarization(enabled bool) error {
+	if enabled {
+		return h.writeState("summarize_wal", "on")
+	}
+	return h.writeState("summarize_wal", "off")
+}
+
+// Replayed returns everything the fake psql has been asked to run
+func (h *Harness) Replayed() (string, error) {
+	content, err := os.ReadFile(filepath.Join(h.Dir, "bin", "psql.log"))
+	return strings.TrimSpace(string(content)), err
+}
+
+// SetDatabases sets the databases dumped by the fake pg_dump
+func (h *Harness) SetDatabases(databases ...string) error {
+	return h.writeState("databases", strings.Join(databases, "\n")+"\n")
+}
+
+// SetSchemas sets the schemas found in the databases restored into
+func (h *Harness) SetSchemas(schemas ...string) error {
+	return h.writeState("schemas", strings.Join(schemas, "\n")+"\n")
+}
+
+// SetTableOfContents sets the list printed by the fake pg_restore -l
+func (h *Harness) SetTableOfContents(toc string) error {
+	return h.writeState("toc.list", toc)
+}
+
+// SetRestoreErrors sets the errors printed by the restores of the fake
+// psql, and makes pg_restore fail as well
+func (h *Harness) SetRestoreErrors(stderr string) error {
+	return h.writeState("restore_errors", stderr)
+}
+
+// Restored returns everything the fake pg_restore has been asked to restore
+func (h *Harness) Restored() (string, error) {
+	content, err := os.ReadFile(filepath.Join(h.Dir, "bin", "pg_restore.log"))
+	return strings.TrimSpace(string(content)), err
+}
+
+// Combined returns the data of the backups the fake pg_combinebackup
+// has been asked to combine, in order
+func (h *Harness) Combined() (string, error) {
+	content, err := os.ReadFile(filepath.Join(h.Dir, "bin", "pg_combinebackup.log"))
+	return strings.TrimSpace(string(content)), err
+}
+
+func (h *Harness) writeState(name string, content string) error {
+	return os.WriteFile(filepath.Join(h.Dir, "bin", name), []byte(content), 0o600)
+}
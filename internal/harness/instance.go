@@ -0,0 +1,139 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/cloudnative-pg/cloudnative-pg/pkg/management/postgres/webserver"
+	"github.com/cloudnative-pg/cloudnative-pg/pkg/management/url"
+	postgresUtils "github.com/cloudnative-pg/cloudnative-pg/pkg/postgres"
+)
+
+// InstanceServer is a fake CloudNativePG instance manager, serving the
+// backup mode and the pg_controldata endpoints of the status webserver
+type InstanceServer struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	current *webserver.BackupResultData
+	walFile int
+
+	// Starts counts the backups started, including the forced ones
+	Starts int
+}
+
+// NewInstanceServer starts a fake instance manager
+func NewInstanceServer() *InstanceServer {
+	s := &InstanceServer{walFile: 1}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(url.PathPgModeBackup, s.backup)
+	mux.HandleFunc(url.PathPGControlData, s.controlData)
+	s.server = httptest.NewServer(mux)
+
+	return s
+}
+
+// URL is the base URL of the status webserver
+func (s *InstanceServer) URL() string {
+	return s.server.URL
+}
+
+// Close stops the server
+func (s *InstanceServer) Close() {
+	s.server.Close()
+}
+
+// InBackupMode is true while a backup started on the instance
+// has not been stopped
+func (s *InstanceServer) InBackupMode() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current != nil && s.current.Phase != webserver.Completed
+}
+
+func (s *InstanceServer) backup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if s.current == nil {
+			writeResponse[webserver.BackupResultData](w, nil, &webserver.Error{
+				Code:    "NO_BACKUP",
+				Message: "no backup in progress",
+			})
+			return
+		}
+		writeResponse(w, s.current, nil)
+
+	case http.MethodPost:
+		var request webserver.StartBackupRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.current != nil && s.current.Phase != webserver.Completed && !request.Force {
+			writeResponse[struct{}](w, nil, &webserver.Error{
+				Code:    "PROCESS_ALREADY_RUNNING",
+				Message: fmt.Sprintf("backup %s is already running", s.current.BackupName),
+			})
+			return
+		}
+
+		s.Starts++
+		s.walFile++
+		s.current = &webserver.BackupResultData{
+			BackupName: request.BackupName,
+			BeginLSN:   postgresUtils.LSN(fmt.Sprintf("0/%X000028", s.walFile)),
+			Phase:      webserver.Started,
+		}
+		writeResponse(w, &struct{}{}, nil)
+
+	case http.MethodPut:
+		var request webserver.StopBackupRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.current == nil || s.current.BackupName != request.BackupName {
+			writeResponse[webserver.BackupResultData](w, nil, &webserver.Error{
+				Code:    "BACKUP_NOT_RUNNING",
+				Message: fmt.Sprintf("backup %s is not running", request.BackupName),
+			})
+			return
+		}
+
+		s.walFile++
+		s.current.EndLSN = postgresUtils.LSN(fmt.Sprintf("0/%X000100", s.walFile))
+		s.current.LabelFile = []byte("START WAL LOCATION: " + string(s.current.BeginLSN))
+		s.current.Phase = webserver.Completed
+		writeResponse(w, s.current, nil)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *InstanceServer) controlData(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	output := fmt.Sprintf("Database cluster state:               in production\n"+
+		"Latest checkpoint's TimeLineID:       1\n"+
+		"Latest checkpoint's REDO WAL file:    %08X%08X%08X\n", 1, 0, s.walFile)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Data string `json:"data"`
+	}{Data: output})
+}
+
+func writeResponse[T any](w http.ResponseWriter, data *T, apiError *webserver.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(webserver.Response[T]{Data: data, Error: apiError})
+}
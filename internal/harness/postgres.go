@@ -0,0 +1,215 @@
+package harness
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The fake PostgreSQL client binaries are shell scripts keeping their
+// state in the directory they are installed into:
+//
+//   - dump.sql is printed by pg_dumpall
+//   - database_size is the answer to the database size query
+//   - in_recovery is the answer to pg_is_in_recovery()
+//   - summarize_wal is the answer to SHOW summarize_wal
+//   - wal_since is the WAL written since the parent of an incremental backup
+//   - psql.log collects every script and statement run by psql
+//   - databases lists the databases dumped by pg_dump
+//   - toc.list is the list printed by pg_restore -l -v
+//   - schemas lists the schemas of the databases restored into
+//   - pg_restore.log collects the database, the list and the dump
+//     of every run of pg_restore
+//   - restore_errors is printed on stderr by the restores of psql, which
+//     then fail when stopping on errors, and makes pg_restore fail too
+//
+// pg_basebackup writes a data directory with a backup manifest and
+// pg_combinebackup copies the most recent of its inputs, after logging
+// the data of every input, in order, into pg_combinebackup.log
+var fakeBinaries = map[string]string{
+	"pg_dumpall": `#!/bin/sh
+state="$(dirname "$0")"
+cat "$state/dump.sql"
+`,
+
+	"psql": `#!/bin/sh
+state="$(dirname "$0")"
+query=""
+statements=""
+stop=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-XAtc) query="$2"; shift ;;
+	-c) statements="$2"; shift ;;
+	-f) statements="$(cat "$2")"; shift ;;
+	-v) [ "$2" = "ON_ERROR_STOP=1" ] && stop=1; shift ;;
+	-h|-d|-p|-U) shift ;;
+	esac
+	shift
+done
+
+case "$query" in
+*pg_is_in_recovery*) cat "$state/in_recovery"; exit 0 ;;
+*pg_database_size*) cat "$state/database_size"; exit 0 ;;
+*summarize_wal*) cat "$state/summarize_wal"; exit 0 ;;
+*pg_wal_lsn_diff*) cat "$state/wal_since"; exit 0 ;;
+*server_version*) echo "16.4 (Debian 16.4-1.pgdg120+1)"; exit 0 ;;
+*datallowconn*) cat "$state/databases"; exit 0 ;;
+*"FROM pg_database WHERE datname"*) echo 0; exit 0 ;;
+*"FROM pg_namespace WHERE nspname"*)
+	schema="$(printf '%s' "$query" | sed "s/.*nspname = '\\(.*\\)'.*/\\1/")"
+	grep -cxF "$schema" "$state/schemas"
+	exit 0 ;;
+"CREATE DATABASE"*) printf '%s\n' "$query" >> "$state/psql.log"; exit 0 ;;
+?*) exit 0 ;;
+esac
+
+if [ -n "$statements" ]; then
+	printf '%s\n' "$statements" >> "$state/psql.log"
+else
+	cat >> "$state/psql.log"
+	if [ -s "$state/restore_errors" ]; then
+		cat "$state/restore_errors" >&2
+		[ -n "$stop" ] && exit 3
+	fi
+fi
+`,
+
+	"pg_dump": `#!/bin/sh
+state="$(dirname "$0")"
+database=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-d) database="$2"; shift ;;
+	esac
+	shift
+done
+
+printf -- '-- database %s\n' "$database"
+cat "$state/dump.sql"
+`,
+
+	"pg_restore": `#!/bin/sh
+state="$(dirname "$0")"
+database=""
+list=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-l) cat "$state/toc.list"; exit 0 ;;
+	-d) database="$2"; shift ;;
+	-L) list="$2"; shift ;;
+	-h|-p) shift ;;
+	-*) ;;
+	*) dump="$1" ;;
+	esac
+	shift
+done
+
+{
+	printf 'database %s\n' "$database"
+	if [ -n "$list" ]; then cat "$list"; fi
+	cat "$dump"
+} >> "$state/pg_restore.log"
+
+if [ -s "$state/restore_errors" ]; then
+	printf 'pg_restore: from TOC entry 215; 1259 16386 TABLE public orders app\n' >&2
+	printf 'pg_restore: error: could not execute query: ERROR:  relation "orders" already exists\n' >&2
+	printf 'Command was: CREATE TABLE public.orders (\n    id integer\n);\n' >&2
+	printf 'pg_restore: warning: errors ignored on restore: 1\n' >&2
+	exit 1
+fi
+`,
+
+	"pg_basebackup": `#!/bin/sh
+state="$(dirname "$0")"
+target=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-D) target="$2"; shift ;;
+	esac
+	shift
+done
+
+mkdir -p "$target/base"
+cp "$state/dump.sql" "$target/base/data"
+echo '{"PostgreSQL-Backup-Manifest-Version": 1}' > "$target/backup_manifest"
+`,
+
+	"pg_combinebackup": `#!/bin/sh
+state="$(dirname "$0")"
+output=""
+last=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-o) output="$2"; shift ;;
+	*) last="$1"; cat "$1/base/data" >> "$state/pg_combinebackup.log" ;;
+	esac
+	shift
+done
+
+mkdir -p "$output"
+cp -R "$last"/. "$output"
+`,
+}
+
+// defaultTableOfContents is the list printed by the fake pg_restore -l
+const defaultTableOfContents = `;
+; Archive created at 2024-09-01 10:00:00 UTC
+;
+6; 2615 2200 SCHEMA - public pg_database_owner
+7; 2615 16390 SCHEMA - sales app
+214; 1259 16385 SEQUENCE public orders_id_seq app
+;	depends on: 6
+215; 1259 16386 TABLE public orders app
+;	depends on: 6
+216; 1259 16391 TABLE sales invoices app
+;	depends on: 7
+3379; 0 0 SEQUENCE OWNED BY public orders_id_seq app
+;	depends on: 214 215
+3378; 2604 16387 DEFAULT public orders id app
+;	depends on: 214 215
+3380; 0 16386 TABLE DATA public orders app
+;	depends on: 215
+3381; 0 16391 TABLE DATA sales invoices app
+;	depends on: 216
+3383; 0 0 SEQUENCE SET public orders_id_seq app
+;	depends on: 214
+3230; 2606 16400 CONSTRAINT public orders orders_pkey app
+;	depends on: 215
+3233; 1259 16403 INDEX public orders_created_idx app
+;	depends on: 215
+3231; 2606 16401 FK CONSTRAINT sales invoices invoices_order_fkey app
+;	depends on: 216 3230
+3382; 0 0 COMMENT public TABLE orders app
+;	depends on: 215
+`
+
+// installBinaries writes the fake binaries into the passed directory,
+// together with their default state
+func installBinaries(dir string) error {
+	for name, script := range fakeBinaries {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			return fmt.Errorf("while installing %s: %w", name, err)
+		}
+	}
+
+	defaults := map[string]string{
+		"dump.sql":       "CREATE ROLE app;\n\\connect postgres\nSELECT 1;\n",
+		"database_size":  "1024",
+		"in_recovery":    "f",
+		"summarize_wal":  "on",
+		"wal_since":      "0",
+		"psql.log":       "",
+		"databases":      "app\npostgres\n",
+		"toc.list":       defaultTableOfContents,
+		"schemas":        "public\n",
+		"pg_restore.log": "",
+	}
+	for name, content := range defaults {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
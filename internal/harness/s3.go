@@ -0,0 +1,990 @@
+package harness
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listPageSize is the number of keys returned by a single list request,
+// small enough for the pagination to be exercised
+const listPageSize = 100
+
+// Object is an object stored in the fake object storage
+type Object struct {
+	Data         []byte
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+	Metadata     map[string]string
+	Tags         map[string]string
+
+	// LockMode, RetainUntil and LegalHold are the object lock of the
+	// object, which cannot be deleted while locked
+	LockMode    string
+	RetainUntil time.Time
+	LegalHold   bool
+
+	// StorageClass is the storage class of the object, STANDARD when
+	// empty. Objects in the archive classes are read once Restored
+	StorageClass string
+	Restored     bool
+
+	// Parts is the number of parts of an object uploaded
+	// with a multipart upload, zero otherwise
+	Parts int
+
+	// VersionID identifies the version of the object in versioned
+	// buckets, where deleting a key without a version only adds a
+	// DeleteMarker version hiding the previous ones
+	VersionID    string
+	DeleteMarker bool
+}
+
+// archived is true when the object cannot be read before being restored
+func (object *Object) archived() bool {
+	return (object.StorageClass == "GLACIER" || object.StorageClass == "DEEP_ARCHIVE") && !object.Restored
+}
+
+// locked is true when the object cannot be deleted yet
+func (object *Object) locked(now time.Time) bool {
+	return object.LegalHold || (len(object.LockMode) > 0 && object.RetainUntil.After(now))
+}
+
+// S3Server is an in-memory object storage speaking the subset of the S3
+// API used by the plugin, with path-style addressing
+type S3Server struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	buckets    map[string]map[string]*Object
+	objectLock map[string]bool
+
+	// versions are every version of the keys of the versioned
+	// buckets, from the oldest to the newest
+	versions    map[string]map[string][]*Object
+	lastVersion int
+
+	// uploads are the multipart uploads in progress
+	uploads    map[string]*multipartUpload
+	lastUpload int
+}
+
+// multipartUpload is a multipart upload in progress, keeping the headers
+// of its creation to be applied to the object once complete
+type multipartUpload struct {
+	bucket string
+	key    string
+	header http.Header
+	parts  map[int][]byte
+}
+
+// NewS3Server starts an in-memory object storage holding the passed buckets
+func NewS3Server(buckets ...string) *S3Server {
+	s := &S3Server{
+		buckets:    make(map[string]map[string]*Object),
+		objectLock: make(map[string]bool),
+		versions:   make(map[string]map[string][]*Object),
+		uploads:    make(map[string]*multipartUpload),
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string]*Object)
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL is the endpoint of the object storage
+func (s *S3Server) URL() string {
+	return s.server.URL
+}
+
+// Close stops the server
+func (s *S3Server) Close() {
+	s.server.Close()
+}
+
+// CreateBucket creates an empty bucket
+func (s *S3Server) CreateBucket(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = make(map[string]*Object)
+	}
+}
+
+// EnableObjectLock enables the object lock on a bucket, which
+// makes it versioned too
+func (s *S3Server) EnableObjectLock(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objectLock[bucket] = true
+	s.enableVersioning(bucket)
+}
+
+// EnableVersioning keeps every version of the objects of a bucket
+func (s *S3Server) EnableVersioning(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enableVersioning(bucket)
+}
+
+func (s *S3Server) enableVersioning(bucket string) {
+	if _, ok := s.versions[bucket]; !ok {
+		s.versions[bucket] = make(map[string][]*Object)
+	}
+}
+
+// Versions returns every version of a key, delete markers
+// included, from the oldest to the newest
+func (s *S3Server) Versions(bucket string, key string) []*Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.versions[bucket][key])
+}
+
+// SetLegalHold places or removes the legal hold of an object
+func (s *S3Server) SetLegalHold(bucket string, key string, hold bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if object := s.buckets[bucket][key]; object != nil {
+		object.LegalHold = hold
+	}
+}
+
+// Keys returns the keys stored in a bucket, sorted
+func (s *S3Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Get returns an object, nil if it doesn't exist
+func (s *S3Server) Get(bucket string, key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buckets[bucket][key]
+}
+
+// Put stores an object, as if it was uploaded
+func (s *S3Server) Put(bucket string, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.putLocked(bucket, key, data, "", nil)
+}
+
+func (s *S3Server) putLocked(
+	bucket string,
+	key string,
+	data []byte,
+	contentType string,
+	metadata map[string]string,
+) *Object {
+	sum := md5.Sum(data)
+	object := &Object{
+		Data:         data,
+		ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		ContentType:  contentType,
+		LastModified: time.Now().UTC(),
+		Metadata:     metadata,
+	}
+	s.buckets[bucket][key] = object
+	s.addVersion(bucket, key, object)
+
+	return object
+}
+
+// addVersion records a new version of a key of a versioned bucket
+func (s *S3Server) addVersion(bucket string, key string, object *Object) {
+	if _, versioned := s.versions[bucket]; !versioned {
+		return
+	}
+
+	s.lastVersion++
+	object.VersionID = fmt.Sprintf("v%06d", s.lastVersion)
+	s.versions[bucket][key] = append(s.versions[bucket][key], object)
+}
+
+// serveHTTP writes the content of the objects once the server is
+// unlocked, so that a client streaming an object into another one
+// doesn't block its own upload
+func (s *S3Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if body := s.handle(w, r); len(body) > 0 {
+		_, _ = w.Write(body)
+	}
+}
+
+// handle serves a request, returning the content of the object to write
+func (s *S3Server) handle(w http.ResponseWriter, r *http.Request) []byte {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket]; !ok {
+		if r.Method == http.MethodPut && len(key) == 0 {
+			s.buckets[bucket] = make(map[string]*Object)
+			return nil
+		}
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return nil
+	}
+
+	switch {
+	case len(key) == 0 && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case len(key) == 0 && r.Method == http.MethodGet && r.URL.Query().Has("object-lock"):
+		s.getObjectLockConfiguration(w, bucket)
+	case len(key) == 0 && r.Method == http.MethodGet && r.URL.Query().Has("versioning"):
+		s.getBucketVersioning(w, bucket)
+	case len(key) == 0 && r.Method == http.MethodGet && r.URL.Query().Has("versions"):
+		s.listObjectVersions(w, r, bucket)
+	case r.Method == http.MethodGet && r.URL.Query().Has("legal-hold"):
+		s.getLegalHold(w, bucket, key)
+	case len(key) == 0 && r.Method == http.MethodGet:
+		s.listObjects(w, r, bucket)
+	case len(key) == 0 && r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		s.deleteObjects(w, r, bucket)
+	case r.Method == http.MethodPost && r.URL.Query().Has("restore"):
+		s.restoreObject(w, bucket, key)
+	case r.Method == http.MethodPost && r.URL.Query().Has("uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && r.URL.Query().Has("uploadId"):
+		s.uploadPart(w, r)
+	case r.Method == http.MethodPost && r.URL.Query().Has("uploadId"):
+		s.completeMultipartUpload(w, r)
+	case r.Method == http.MethodDelete && r.URL.Query().Has("uploadId"):
+		delete(s.uploads, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && len(r.Header.Get("X-Amz-Copy-Source")) > 0:
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, bucket, key)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "Not implemented by the fake object storage")
+	}
+
+	return nil
+}
+
+// checkConditions implements the conditional requests used by the
+// backup lock, returning false when the request is rejected
+func checkConditions(w http.ResponseWriter, r *http.Request, current *Object) bool {
+	if r.Header.Get("If-None-Match") == "*" && current != nil {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "The object already exists")
+		return false
+	}
+
+	if etag := r.Header.Get("If-Match"); len(etag) > 0 && (current == nil || current.ETag != etag) {
+		if current == nil {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		} else {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "The object has changed")
+		}
+		return false
+	}
+
+	return true
+}
+
+func (s *S3Server) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	if !checkConditions(w, r, s.buckets[bucket][key]) {
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	metadata := headerMetadata(r.Header)
+
+	lockMode := r.Header.Get("X-Amz-Object-Lock-Mode")
+	legalHold := r.Header.Get("X-Amz-Object-Lock-Legal-Hold") == "ON"
+	if (len(lockMode) > 0 || legalHold) && !s.objectLock[bucket] {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "Bucket is missing Object Lock Configuration")
+		return
+	}
+	var retainUntil time.Time
+	if len(lockMode) > 0 {
+		if retainUntil, err = time.Parse(time.RFC3339, r.Header.Get("X-Amz-Object-Lock-Retain-Until-Date")); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+			return
+		}
+	}
+
+	tags, err := url.ParseQuery(r.Header.Get("X-Amz-Tagging"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidTag", err.Error())
+		return
+	}
+
+	object := s.putLocked(bucket, key, data, r.Header.Get("Content-Type"), metadata)
+	object.Tags = flattenTags(tags)
+	object.StorageClass = r.Header.Get("X-Amz-Storage-Class")
+	object.LockMode = lockMode
+	object.RetainUntil = retainUntil
+	object.LegalHold = legalHold
+	w.Header().Set("ETag", object.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *S3Server) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string) []byte {
+	object := s.buckets[bucket][key]
+	if versionID := r.URL.Query().Get("versionId"); len(versionID) > 0 {
+		object = s.findVersion(bucket, key, versionID)
+	}
+	if object == nil || object.DeleteMarker {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return nil
+	}
+
+	if r.Method == http.MethodGet && object.archived() {
+		writeError(w, http.StatusForbidden, "InvalidObjectState",
+			"The operation is not valid for the object's storage class")
+		return nil
+	}
+
+	w.Header().Set("ETag", object.ETag)
+	if len(object.VersionID) > 0 {
+		w.Header().Set("X-Amz-Version-Id", object.VersionID)
+	}
+	if len(object.StorageClass) > 0 && object.StorageClass != "STANDARD" {
+		w.Header().Set("X-Amz-Storage-Class", object.StorageClass)
+	}
+	if object.Restored {
+		w.Header().Set("X-Amz-Restore", `ongoing-request="false"`)
+	}
+	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.Data)))
+	if len(object.ContentType) > 0 {
+		w.Header().Set("Content-Type", object.ContentType)
+	}
+	for name, value := range object.Metadata {
+		w.Header().Set("X-Amz-Meta-"+name, value)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	return object.Data
+}
+
+func (s *S3Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	if versionID := r.URL.Query().Get("versionId"); len(versionID) > 0 && versionID != "null" {
+		s.deleteVersion(w, bucket, key, versionID)
+		return
+	}
+
+	current := s.buckets[bucket][key]
+	if current == nil && len(r.Header.Get("If-Match")) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !checkConditions(w, r, current) {
+		return
+	}
+
+	// Versioned buckets keep the object, hiding it behind a delete
+	// marker, which the object lock doesn't prevent
+	if _, versioned := s.versions[bucket]; versioned {
+		marker := &Object{DeleteMarker: true, LastModified: time.Now().UTC()}
+		s.addVersion(bucket, key, marker)
+		delete(s.buckets[bucket], key)
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+		w.Header().Set("X-Amz-Version-Id", marker.VersionID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if current != nil && current.locked(time.Now()) {
+		writeError(w, http.StatusForbidden, "AccessDenied", "Access Denied because object protected by object lock")
+		return
+	}
+
+	delete(s.buckets[bucket], key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findVersion returns a version of a key, nil if it doesn't exist
+func (s *S3Server) findVersion(bucket string, key string, versionID string) *Object {
+	for _, version := range s.versions[bucket][key] {
+		if version.VersionID == versionID {
+			return version
+		}
+	}
+	return nil
+}
+
+// deleteVersion removes a version of a key for good, unless it is
+// locked. The newest remaining version becomes the current object
+func (s *S3Server) deleteVersion(w http.ResponseWriter, bucket string, key string, versionID string) {
+	version := s.findVersion(bucket, key, versionID)
+	if version == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if version.locked(time.Now()) {
+		writeError(w, http.StatusForbidden, "AccessDenied", "Access Denied because object protected by object lock")
+		return
+	}
+
+	versions := slices.DeleteFunc(s.versions[bucket][key], func(candidate *Object) bool {
+		return candidate == version
+	})
+	s.versions[bucket][key] = versions
+
+	delete(s.buckets[bucket], key)
+	if len(versions) == 0 {
+		delete(s.versions[bucket], key)
+	} else if latest := versions[len(versions)-1]; !latest.DeleteMarker {
+		s.buckets[bucket][key] = latest
+	}
+
+	if version.DeleteMarker {
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+	}
+	w.Header().Set("X-Amz-Version-Id", versionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// headerMetadata returns the user metadata sent in the headers of a request
+func headerMetadata(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for name, values := range header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-meta-") {
+			metadata[strings.TrimPrefix(lower, "x-amz-meta-")] = values[0]
+		}
+	}
+	return metadata
+}
+
+func flattenTags(values url.Values) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	tags := make(map[string]string, len(values))
+	for key := range values {
+		tags[key] = values.Get(key)
+	}
+	return tags
+}
+
+func storageClass(object *Object) string {
+	if len(object.StorageClass) == 0 {
+		return "STANDARD"
+	}
+	return object.StorageClass
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// copySource returns the object a copy request reads from, writing
+// the error response when it cannot be read
+func (s *S3Server) copySource(w http.ResponseWriter, r *http.Request) *Object {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return nil
+	}
+	sourceBucket, sourceKey, _ := strings.Cut(source, "/")
+
+	original := s.buckets[sourceBucket][sourceKey]
+	if original == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return nil
+	}
+	if original.archived() {
+		writeError(w, http.StatusForbidden, "InvalidObjectState",
+			"The operation is not valid for the object's storage class")
+		return nil
+	}
+
+	return original
+}
+
+// copyObject copies an object, keeping its metadata. Copies in place are
+// how the storage class of an object is changed
+func (s *S3Server) copyObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	original := s.copySource(w, r)
+	if original == nil {
+		return
+	}
+
+	object := s.putLocked(bucket, key, original.Data, original.ContentType, original.Metadata)
+	object.Tags = original.Tags
+	object.StorageClass = r.Header.Get("X-Amz-Storage-Class")
+	object.LockMode = r.Header.Get("X-Amz-Object-Lock-Mode")
+	object.LegalHold = r.Header.Get("X-Amz-Object-Lock-Legal-Hold") == "ON"
+	if len(object.LockMode) > 0 {
+		object.RetainUntil, _ = time.Parse(time.RFC3339, r.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	}
+
+	writeXML(w, http.StatusOK, copyObjectResult{
+		ETag:         object.ETag,
+		LastModified: object.LastModified.Format(time.RFC3339),
+	})
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (s *S3Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	s.lastUpload++
+	uploadID := fmt.Sprintf("upload-%06d", s.lastUpload)
+	s.uploads[uploadID] = &multipartUpload{
+		bucket: bucket,
+		key:    key,
+		header: r.Header.Clone(),
+		parts:  make(map[int][]byte),
+	}
+
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+type copyPartResult struct {
+	XMLName      xml.Name `xml:"CopyPartResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// uploadPart stores a part of a multipart upload, read from the body or,
+// for UploadPartCopy, from a range of another object
+func (s *S3Server) uploadPart(w http.ResponseWriter, r *http.Request) {
+	upload := s.uploads[r.URL.Query().Get("uploadId")]
+	if upload == nil {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	var data []byte
+	copied := len(r.Header.Get("X-Amz-Copy-Source")) > 0
+	if copied {
+		original := s.copySource(w, r)
+		if original == nil {
+			return
+		}
+		data = original.Data
+		if byteRange := r.Header.Get("X-Amz-Copy-Source-Range"); len(byteRange) > 0 {
+			var start, end int
+			if _, err := fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end); err != nil ||
+				start > end || end >= len(data) {
+				writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid copy source range "+byteRange)
+				return
+			}
+			data = data[start : end+1]
+		}
+	} else if data, err = io.ReadAll(r.Body); err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	upload.parts[number] = data
+	sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if copied {
+		writeXML(w, http.StatusOK, copyPartResult{ETag: etag, LastModified: time.Now().UTC().Format(time.RFC3339)})
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int `xml:"PartNumber"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+// completeMultipartUpload joins the listed parts into the object
+func (s *S3Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	uploadID := r.URL.Query().Get("uploadId")
+	upload := s.uploads[uploadID]
+	if upload == nil {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+
+	var request completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	var data []byte
+	for _, part := range request.Parts {
+		content, ok := upload.parts[part.PartNumber]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d has not been uploaded", part.PartNumber))
+			return
+		}
+		data = append(data, content...)
+	}
+
+	tags, _ := url.ParseQuery(upload.header.Get("X-Amz-Tagging"))
+	object := s.putLocked(upload.bucket, upload.key, data,
+		upload.header.Get("Content-Type"), headerMetadata(upload.header))
+	object.Parts = len(request.Parts)
+	object.Tags = flattenTags(tags)
+	object.StorageClass = upload.header.Get("X-Amz-Storage-Class")
+	object.LockMode = upload.header.Get("X-Amz-Object-Lock-Mode")
+	object.LegalHold = upload.header.Get("X-Amz-Object-Lock-Legal-Hold") == "ON"
+	if len(object.LockMode) > 0 {
+		object.RetainUntil, _ = time.Parse(time.RFC3339, upload.header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	}
+	delete(s.uploads, uploadID)
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{Bucket: upload.bucket, Key: upload.key, ETag: object.ETag})
+}
+
+// restoreObject restores an archived object, right away
+func (s *S3Server) restoreObject(w http.ResponseWriter, bucket string, key string) {
+	object := s.buckets[bucket][key]
+	if object == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+	if !object.archived() {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	object.Restored = true
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type objectLockConfiguration struct {
+	XMLName           xml.Name `xml:"ObjectLockConfiguration"`
+	ObjectLockEnabled string   `xml:"ObjectLockEnabled"`
+}
+
+func (s *S3Server) getObjectLockConfiguration(w http.ResponseWriter, bucket string) {
+	if !s.objectLock[bucket] {
+		writeError(w, http.StatusNotFound, "ObjectLockConfigurationNotFoundError",
+			"Object Lock configuration does not exist for this bucket")
+		return
+	}
+
+	writeXML(w, http.StatusOK, objectLockConfiguration{ObjectLockEnabled: "Enabled"})
+}
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+func (s *S3Server) getBucketVersioning(w http.ResponseWriter, bucket string) {
+	var configuration versioningConfiguration
+	if _, versioned := s.versions[bucket]; versioned {
+		configuration.Status = "Enabled"
+	}
+
+	writeXML(w, http.StatusOK, configuration)
+}
+
+type legalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"`
+}
+
+func (s *S3Server) getLegalHold(w http.ResponseWriter, bucket string, key string) {
+	object := s.buckets[bucket][key]
+	if object == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+
+	status := "OFF"
+	if object.LegalHold {
+		status = "ON"
+	}
+	writeXML(w, http.StatusOK, legalHold{Status: status})
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContent  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (s *S3Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	after := query.Get("continuation-token")
+	if len(after) == 0 {
+		after = query.Get("start-after")
+	}
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{
+		Name:      bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   listPageSize,
+	}
+
+	seenPrefixes := make(map[string]bool)
+	for _, key := range keys {
+		if len(after) > 0 && key <= after {
+			continue
+		}
+
+		entry := key
+		if len(delimiter) > 0 {
+			if index := strings.Index(key[len(prefix):], delimiter); index >= 0 {
+				entry = key[:len(prefix)+index+len(delimiter)]
+				if seenPrefixes[entry] || (len(after) > 0 && entry <= after) {
+					continue
+				}
+			}
+		}
+
+		if result.KeyCount == listPageSize {
+			result.IsTruncated = true
+			break
+		}
+
+		if entry != key {
+			seenPrefixes[entry] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+		} else {
+			object := s.buckets[bucket][key]
+			result.Contents = append(result.Contents, listContent{
+				Key:          key,
+				LastModified: object.LastModified.Format(time.RFC3339),
+				ETag:         object.ETag,
+				Size:         len(object.Data),
+				StorageClass: storageClass(object),
+			})
+		}
+		result.KeyCount++
+		result.NextContinuationToken = entry
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+type listVersionsResult struct {
+	XMLName       xml.Name       `xml:"ListVersionsResult"`
+	Name          string         `xml:"Name"`
+	Prefix        string         `xml:"Prefix"`
+	IsTruncated   bool           `xml:"IsTruncated"`
+	Versions      []listVersion  `xml:"Version"`
+	DeleteMarkers []deleteMarker `xml:"DeleteMarker"`
+}
+
+type listVersion struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type deleteMarker struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+}
+
+// listObjectVersions lists the versions of the keys with the requested
+// prefix, newest first for each key, in a single page. Unversioned
+// buckets report their objects with the null version
+func (s *S3Server) listObjectVersions(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	result := listVersionsResult{Name: bucket, Prefix: prefix}
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range s.versions[bucket] {
+		if strings.HasPrefix(key, prefix) && s.buckets[bucket][key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		versions, versioned := s.versions[bucket][key]
+		if !versioned {
+			versions = []*Object{s.buckets[bucket][key]}
+		}
+
+		for i := len(versions) - 1; i >= 0; i-- {
+			version := versions[i]
+			versionID := version.VersionID
+			if len(versionID) == 0 {
+				versionID = "null"
+			}
+			lastModified := version.LastModified.Format(time.RFC3339)
+
+			if version.DeleteMarker {
+				result.DeleteMarkers = append(result.DeleteMarkers, deleteMarker{
+					Key:          key,
+					VersionID:    versionID,
+					IsLatest:     i == len(versions)-1,
+					LastModified: lastModified,
+				})
+				continue
+			}
+			result.Versions = append(result.Versions, listVersion{
+				Key:          key,
+				VersionID:    versionID,
+				IsLatest:     i == len(versions)-1,
+				LastModified: lastModified,
+				ETag:         version.ETag,
+				Size:         len(version.Data),
+				StorageClass: storageClass(version),
+			})
+		}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+type deleteRequest struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Deleted []struct {
+		Key string `xml:"Key"`
+	} `xml:"Deleted"`
+}
+
+func (s *S3Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	var request deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	var result deleteResult
+	for _, object := range request.Objects {
+		delete(s.buckets[bucket], object.Key)
+		result.Deleted = append(result.Deleted, struct {
+			Key string `xml:"Key"`
+		}{Key: object.Key})
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeXML(w, status, errorResponse{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, status int, value any) {
+	content, err := xml.Marshal(value)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("while encoding the response: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(content)
+}
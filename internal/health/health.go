@@ -0,0 +1,226 @@
+// Package health implements the health checks of the sidecar
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+const (
+	// DefaultPort is the port the health server listens on in the sidecar
+	DefaultPort = 8090
+
+	// LivenessPath is the path of the liveness endpoint
+	LivenessPath = "/healthz"
+
+	// ReadinessPath is the path of the readiness endpoint
+	ReadinessPath = "/readyz"
+
+	// MetricsPath is the path of the Prometheus metrics endpoint
+	MetricsPath = "/metrics"
+
+	// ProgressPath is the path of the endpoint reporting the
+	// progress of the running backup
+	ProgressPath = "/progress"
+
+	checkTimeout = 5 * time.Second
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Checker verifies that the sidecar is able to take backups. A zero
+// Checker is disabled and reports every check as passed, which is what
+// the plugin running beside the operator needs
+type Checker struct {
+	mu         sync.Mutex
+	enabled    bool
+	bucket     string
+	workingDir string
+	binaries   []string
+	progress   *executor.ProgressTracker
+	client     *s3.Client
+}
+
+// Enable turns on the checks for a sidecar storing backups in the
+// passed bucket and writing them into the working directory. The
+// progress endpoint reports the backups of the passed tracker
+func (c *Checker) Enable(
+	bucket string,
+	workingDir string,
+	progress *executor.ProgressTracker,
+	binaries ...string,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.enabled = true
+	c.bucket = bucket
+	c.workingDir = workingDir
+	c.binaries = binaries
+	c.progress = progress
+}
+
+// Live runs the checks which only depend on the sidecar itself. A failure
+// of the object storage must not restart the container, so it is left out
+func (c *Checker) Live(ctx context.Context) ([]Result, bool) {
+	return c.run(ctx, false)
+}
+
+// Ready runs every check, including the reachability of the bucket. It
+// backs the plugin probe and the readiness endpoint, and not the
+// readiness probe of the container, which would make the whole instance
+// pod not ready during an outage of the object storage
+func (c *Checker) Ready(ctx context.Context) ([]Result, bool) {
+	return c.run(ctx, true)
+}
+
+func (c *Checker) run(ctx context.Context, includeBucket bool) ([]Result, bool) {
+	if c == nil {
+		return nil, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.enabled {
+		return nil, true
+	}
+
+	results := make([]Result, 0, len(c.binaries)+2)
+	for _, binary := range c.binaries {
+		_, err := exec.LookPath(binary)
+		results = append(results, newResult("binary:"+binary, err))
+	}
+
+	results = append(results, newResult("workingDir", checkWritable(c.workingDir)))
+
+	if includeBucket {
+		results = append(results, newResult("bucket", c.checkBucket(ctx)))
+	}
+
+	healthy := true
+	for _, result := range results {
+		healthy = healthy && result.OK
+	}
+
+	return results, healthy
+}
+
+// checkBucket verifies the bucket is reachable with the current credentials
+func (c *Checker) checkBucket(ctx context.Context) error {
+	if len(c.bucket) == 0 {
+		return errors.New("no bucket configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	if c.client == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return err
+		}
+		c.client = s3.NewFromConfig(cfg)
+	}
+
+	_, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &c.bucket})
+	return err
+}
+
+// checkWritable verifies a file can be created into a directory
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return err
+	}
+
+	name := file.Name()
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(filepath.Clean(name))
+}
+
+func newResult(name string, err error) Result {
+	if err != nil {
+		return Result{Name: name, Error: err.Error()}
+	}
+	return Result{Name: name, OK: true}
+}
+
+// ListenAndServe exposes the liveness, readiness and metrics endpoints
+// until the context is canceled
+func (c *Checker) ListenAndServe(ctx context.Context, address string) error {
+	logger := logging.FromContext(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, c.handler(c.Live))
+	mux.HandleFunc(ReadinessPath, c.handler(c.Ready))
+	mux.Handle(MetricsPath, promhttp.Handler())
+	mux.HandleFunc(ProgressPath, c.progressHandler)
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: checkTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := server.Close(); err != nil {
+			logger.Error(err, "while closing the health server")
+		}
+	}()
+
+	logger.Info("Starting health server", "address", address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("while serving health checks: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Checker) handler(
+	check func(context.Context) ([]Result, bool),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results, healthy := check(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(results)
+	}
+}
+
+// progressHandler reports the progress of the running backup
+func (c *Checker) progressHandler(w http.ResponseWriter, _ *http.Request) {
+	c.mu.Lock()
+	progress := c.progress
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(progress.Progress())
+}
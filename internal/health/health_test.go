@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+)
+
+func TestChecker(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed []string
+		ready     bool
+		failed    []string
+	}{
+		{
+			name:      "every binary installed",
+			installed: executor.RequiredBinaries(),
+		},
+		{
+			name:      "physical backup binaries missing",
+			installed: executor.BackupTypeLogical.Binaries(),
+			failed:    []string{"binary:pg_basebackup", "binary:pg_combinebackup"},
+		},
+		{
+			name:      "logical restore binary missing",
+			installed: []string{"pg_dumpall", "pg_dump", "psql", "pg_basebackup", "pg_combinebackup"},
+			failed:    []string{"binary:pg_restore"},
+		},
+		{
+			name:      "bucket not configured",
+			installed: executor.RequiredBinaries(),
+			ready:     true,
+			failed:    []string{"bucket"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := t.TempDir()
+			for _, binary := range tt.installed {
+				if err := os.WriteFile(filepath.Join(bin, binary), []byte("#!/bin/sh\n"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PATH", bin)
+
+			var checker Checker
+			checker.Enable("", t.TempDir(), executor.NewProgressTracker(), executor.RequiredBinaries()...)
+
+			check := checker.Live
+			if tt.ready {
+				check = checker.Ready
+			}
+			results, healthy := check(context.Background())
+			if healthy != (len(tt.failed) == 0) {
+				t.Errorf("healthy = %v, want %v: %+v", healthy, len(tt.failed) == 0, results)
+			}
+
+			failed := map[string]bool{}
+			for _, result := range results {
+				if !result.OK {
+					failed[result.Name] = true
+				}
+				if result.Name == "bucket" && !tt.ready {
+					t.Error("the liveness checks the bucket")
+				}
+			}
+			if len(failed) != len(tt.failed) {
+				t.Errorf("failed checks %v, want %v", failed, tt.failed)
+			}
+			for _, name := range tt.failed {
+				if !failed[name] {
+					t.Errorf("check %s has not failed: %+v", name, results)
+				}
+			}
+		})
+	}
+}
+
+func TestDisabledChecker(t *testing.T) {
+	var checker Checker
+	if _, ready := checker.Ready(context.Background()); !ready {
+		t.Error("a disabled checker is not ready")
+	}
+}
@@ -5,12 +5,17 @@ import (
 
 	"github.com/cloudnative-pg/cnpg-i/pkg/identity"
 
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/health"
 	"github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
 )
 
 // Identity is the implementation of the identity service
 type Identity struct {
 	identity.IdentityServer
+
+	// Checker, when enabled, makes the probe fail if the
+	// sidecar is not able to take backups
+	Checker *health.Checker
 }
 
 // GetPluginMetadata implements the IdentityServer interface
@@ -54,8 +59,9 @@ func (Identity) GetPluginCapabilities(
 }
 
 // Probe implements the IdentityServer interface
-func (Identity) Probe(context.Context, *identity.ProbeRequest) (*identity.ProbeResponse, error) {
+func (i Identity) Probe(ctx context.Context, _ *identity.ProbeRequest) (*identity.ProbeResponse, error) {
+	_, ready := i.Checker.Ready(ctx)
 	return &identity.ProbeResponse{
-		Ready: true,
+		Ready: ready,
 	}, nil
 }
@@ -8,6 +8,8 @@ import (
 	"github.com/cloudnative-pg/cloudnative-pg/pkg/management/log"
 	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/pluginhelper"
 	"github.com/cloudnative-pg/cnpg-i/pkg/lifecycle"
+	corev1 "k8s.io/api/core/v1"
+
 	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
 	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/utils"
 
@@ -36,6 +38,9 @@ func (l Lifecycle) GetCapabilities(
 					{
 						Type: lifecycle.OperatorOperationType_TYPE_PATCH,
 					},
+					{
+						Type: lifecycle.OperatorOperationType_TYPE_UPDATE,
+					},
 				},
 			},
 		},
@@ -59,9 +64,9 @@ func (l Lifecycle) LifecycleHook(
 	switch kind {
 	case "Pod":
 		switch *operation {
-		case lifecycle.OperatorOperationType_TYPE_CREATE:
-			return l.reconcilePod(ctx, request)
-		case lifecycle.OperatorOperationType_TYPE_UPDATE:
+		case lifecycle.OperatorOperationType_TYPE_CREATE,
+			lifecycle.OperatorOperationType_TYPE_PATCH,
+			lifecycle.OperatorOperationType_TYPE_UPDATE:
 			return l.reconcilePod(ctx, request)
 		}
 	}
@@ -92,9 +97,18 @@ func (l Lifecycle) reconcilePod(
 
 	// Inject sidecar
 	if len(mutatedPod.Spec.Containers) > 0 {
-		mutatedPod.Spec.Containers = append(
-			mutatedPod.Spec.Containers,
-			getSidecarContainer(mutatedPod, helper.Parameters))
+		sidecar, err := getSidecarContainer(mutatedPod, helper.Parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		scratchVolume, err := getScratchVolume(helper.Parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		mutatedPod.Spec.Volumes = upsertVolume(mutatedPod.Spec.Volumes, scratchVolume)
+		mutatedPod.Spec.Containers = upsertContainer(mutatedPod.Spec.Containers, sidecar)
 	}
 
 	patch, err := helper.CreatePodJSONPatch(*mutatedPod)
@@ -108,3 +122,30 @@ func (l Lifecycle) reconcilePod(
 		JsonPatch: patch,
 	}, nil
 }
+
+// upsertContainer replaces the container having the same name of the passed
+// one, or appends it when missing. This keeps the reconciliation idempotent
+// when the hook is called again on a pod which already has the sidecar
+func upsertContainer(containers []corev1.Container, container corev1.Container) []corev1.Container {
+	for i := range containers {
+		if containers[i].Name == container.Name {
+			containers[i] = container
+			return containers
+		}
+	}
+
+	return append(containers, container)
+}
+
+// upsertVolume replaces the volume having the same name of the passed
+// one, or appends it when missing
+func upsertVolume(volumes []corev1.Volume, volume corev1.Volume) []corev1.Volume {
+	for i := range volumes {
+		if volumes[i].Name == volume.Name {
+			volumes[i] = volume
+			return volumes
+		}
+	}
+
+	return append(volumes, volume)
+}
@@ -0,0 +1,178 @@
+package lifecycle
+
+import (
+	"testing"
+
+	"github.com/cloudnative-pg/cnpg-i/pkg/lifecycle"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+)
+
+// injectedPod is an instance pod already reconciled with the passed parameters
+func injectedPod(t *testing.T, parameters map[string]string) corev1.Pod {
+	t.Helper()
+
+	_, pod := reconcile(t, lifecycle.OperatorOperationType_TYPE_CREATE, newCluster(parameters), newInstancePod())
+	return pod
+}
+
+func TestReconcilePod(t *testing.T) {
+	operations := []lifecycle.OperatorOperationType_Type{
+		lifecycle.OperatorOperationType_TYPE_CREATE,
+		lifecycle.OperatorOperationType_TYPE_PATCH,
+		lifecycle.OperatorOperationType_TYPE_UPDATE,
+	}
+
+	changedImage := baseParameters()
+	changedImage[config.ImageNameParam] = "ghcr.io/dougkirkley/cnpg-plugin-s3-backup:v2"
+
+	changedEnv := baseParameters()
+	changedEnv[config.SidecarEnvParam] = "HTTPS_PROXY=http://proxy:3128"
+
+	tests := []struct {
+		name       string
+		pod        func(t *testing.T) corev1.Pod
+		parameters map[string]string
+
+		// wantUnchanged is true when the pod already matches the parameters
+		wantUnchanged bool
+		wantImage     string
+		wantEnv       string
+	}{
+		{
+			name:       "new pod",
+			pod:        func(*testing.T) corev1.Pod { return newInstancePod() },
+			parameters: baseParameters(),
+			wantImage:  baseParameters()[config.ImageNameParam],
+		},
+		{
+			name:          "pod with the sidecar already injected",
+			pod:           func(t *testing.T) corev1.Pod { return injectedPod(t, baseParameters()) },
+			parameters:    baseParameters(),
+			wantUnchanged: true,
+			wantImage:     baseParameters()[config.ImageNameParam],
+		},
+		{
+			name:       "injected sidecar with a changed image",
+			pod:        func(t *testing.T) corev1.Pod { return injectedPod(t, baseParameters()) },
+			parameters: changedImage,
+			wantImage:  changedImage[config.ImageNameParam],
+		},
+		{
+			name:       "injected sidecar with a changed env",
+			pod:        func(t *testing.T) corev1.Pod { return injectedPod(t, baseParameters()) },
+			parameters: changedEnv,
+			wantImage:  baseParameters()[config.ImageNameParam],
+			wantEnv:    "HTTPS_PROXY",
+		},
+	}
+
+	for _, operation := range operations {
+		for _, tt := range tests {
+			t.Run(operation.String()+"/"+tt.name, func(t *testing.T) {
+				patch, pod := reconcile(t, operation, newCluster(tt.parameters), tt.pod(t))
+
+				if tt.wantUnchanged && patchTouches(t, patch, "/spec") {
+					t.Errorf("the pod already has the sidecar, but the patch changes it: %s", patch)
+				}
+
+				sidecars := findSidecars(pod)
+				if len(sidecars) != 1 {
+					t.Fatalf("found %d sidecars, want 1, patch: %s", len(sidecars), patch)
+				}
+				if sidecars[0].Image != tt.wantImage {
+					t.Errorf("image = %q, want %q", sidecars[0].Image, tt.wantImage)
+				}
+				if len(tt.wantEnv) > 0 && !hasEnv(sidecars[0], tt.wantEnv) {
+					t.Errorf("the sidecar has no %s variable: %+v", tt.wantEnv, sidecars[0].Env)
+				}
+
+				if pod.Spec.Containers[0].Name != "postgres" {
+					t.Errorf("the postgres container moved: %+v", pod.Spec.Containers)
+				}
+
+				scratchVolumes := 0
+				for _, volume := range pod.Spec.Volumes {
+					if volume.Name == scratchVolumeName {
+						scratchVolumes++
+					}
+				}
+				if scratchVolumes != 1 {
+					t.Errorf("found %d scratch volumes, want 1", scratchVolumes)
+				}
+			})
+		}
+	}
+}
+
+func TestReconcilePodTwice(t *testing.T) {
+	cluster := newCluster(baseParameters())
+
+	_, once := reconcile(t, lifecycle.OperatorOperationType_TYPE_CREATE, cluster, newInstancePod())
+	patch, twice := reconcile(t, lifecycle.OperatorOperationType_TYPE_UPDATE, cluster, once)
+
+	if patchTouches(t, patch, "/spec") {
+		t.Errorf("reconciling the pod again changes it: %s", patch)
+	}
+	if len(twice.Spec.Containers) != len(once.Spec.Containers) || len(twice.Spec.Volumes) != len(once.Spec.Volumes) {
+		t.Errorf("reconciling the pod again added %d containers and %d volumes",
+			len(twice.Spec.Containers)-len(once.Spec.Containers), len(twice.Spec.Volumes)-len(once.Spec.Volumes))
+	}
+}
+
+func TestUpsertContainer(t *testing.T) {
+	postgres := corev1.Container{Name: "postgres"}
+	sidecar := corev1.Container{Name: sidecarName, Image: "v1"}
+	updated := corev1.Container{Name: sidecarName, Image: "v2"}
+
+	tests := []struct {
+		name       string
+		containers []corev1.Container
+		container  corev1.Container
+		want       []corev1.Container
+	}{
+		{
+			name:       "missing container",
+			containers: []corev1.Container{postgres},
+			container:  sidecar,
+			want:       []corev1.Container{postgres, sidecar},
+		},
+		{
+			name:       "same container",
+			containers: []corev1.Container{postgres, sidecar},
+			container:  sidecar,
+			want:       []corev1.Container{postgres, sidecar},
+		},
+		{
+			name:       "changed container",
+			containers: []corev1.Container{postgres, sidecar},
+			container:  updated,
+			want:       []corev1.Container{postgres, updated},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := upsertContainer(tt.containers, tt.container)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d containers, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i].Name != tt.want[i].Name || got[i].Image != tt.want[i].Image {
+					t.Errorf("container %d = %s %s, want %s %s",
+						i, got[i].Name, got[i].Image, tt.want[i].Name, tt.want[i].Image)
+				}
+			}
+		})
+	}
+}
+
+func hasEnv(container corev1.Container, name string) bool {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -1,17 +1,96 @@
 package lifecycle
 
 import (
-	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"fmt"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+
+	"github.com/cloudnative-pg/cloudnative-pg/pkg/utils"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/health"
+)
+
+const (
+	pgPath = "/var/lib/postgresql"
+
+	// sidecarName is the name of the container injected into the instance pods
+	sidecarName = "plugin-s3-backup"
+
+	// scratchVolumeName is the volume where the sidecar writes the backups
+	// before uploading them, as its root filesystem is read-only
+	scratchVolumeName = "plugin-s3-backup-scratch"
+	scratchMountPath  = "/backup"
 )
 
-const pgPath = "/var/lib/postgresql"
+// parameterEnv are the environment variables passing the plugin parameters
+// to the sidecar, which are only set when the parameter is
+var parameterEnv = []struct {
+	name      string
+	parameter string
+}{
+	{"AWS_BUCKET", config.BucketParam},
+	{"BACKUP_PREFIX", config.PrefixParam},
+	{"COMPRESSION", config.CompressionParam},
+	{"RETENTION_POLICY", config.RetentionPolicyParam},
+	{"ALLOW_SHARED_PREFIX", config.AllowSharedPrefixParam},
+	{"SCHEDULE", config.ScheduleParam},
+	{"IMMEDIATE", config.ImmediateParam},
+	{"SCHEDULE_JITTER", config.ScheduleJitterParam},
+	{"CONCURRENCY_POLICY", config.ConcurrencyPolicyParam},
+	{"STARTING_DEADLINE", config.StartingDeadlineParam},
+	{"SCHEDULE_BACKUP_TYPE", config.ScheduleBackupTypeParam},
+	{"LOCK_TIMEOUT", config.LockTimeoutParam},
+	{"PRE_BACKUP_SQL", config.PreBackupSQLParam},
+	{"POST_BACKUP_SQL", config.PostBackupSQLParam},
+	{"PRE_BACKUP_COMMAND", config.PreBackupCommandParam},
+	{"POST_BACKUP_COMMAND", config.PostBackupCommandParam},
+	{"HOOK_TIMEOUT", config.HookTimeoutParam},
+	{"MAX_UPLOAD_BANDWIDTH", config.MaxUploadBandwidthParam},
+	{"MAX_DUMP_RATE", config.MaxDumpRateParam},
+	{"LOW_PRIORITY_DUMP", config.LowPriorityDumpParam},
+	{"SCRATCH_VOLUME_SIZE", config.ScratchVolumeSizeParam},
+	{"NO_ROLE_PASSWORDS", config.NoRolePasswordsParam},
+	{"SKIP_MANAGED_ROLES", config.SkipManagedRolesParam},
+	{"DUMP_CONTENT", config.DumpContentParam},
+	{"PER_DATABASE_DUMPS", config.PerDatabaseDumpsParam},
+	{"OBJECT_LOCK_MODE", config.ObjectLockModeParam},
+	{"LOCK_RETENTION", config.LockRetentionParam},
+	{"LEGAL_HOLD", config.LegalHoldParam},
+	{"STORAGE_CLASS", config.StorageClassParam},
+	{"TRANSITION_AFTER", config.TransitionAfterParam},
+	{"TRANSITION_STORAGE_CLASS", config.TransitionStorageClassParam},
+	{"ARCHIVE_RESTORE_TIER", config.ArchiveRestoreTierParam},
+	{"REPLICA_DESTINATIONS", config.ReplicaDestinationsParam},
+	{"OBJECT_TAGS", config.ObjectTagsParam},
+	{"AWS_REGION", config.RegionParam},
+	{"AWS_ENDPOINT_URL", config.EndpointParam},
+	{"AWS_ACCESS_KEY_ID", config.AwsKeyParam},
+	{"AWS_SECRET_ACCESS_KEY", config.AwsSecretKeyParam},
+}
+
+func getSidecarContainer(pgPod *corev1.Pod, parameters map[string]string) (corev1.Container, error) {
+	resources, err := config.ParseResources(parameters)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	extraEnv, err := config.ParseEnv(parameters[config.SidecarEnvParam])
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	envFrom, err := config.ParseEnvFrom(parameters[config.SidecarEnvFromParam])
+	if err != nil {
+		return corev1.Container{}, err
+	}
 
-func getSidecarContainer(pgPod *corev1.Pod, parameters map[string]string) corev1.Container {
 	result := corev1.Container{
-		Name: "plugin-s3-backup",
+		Name: sidecarName,
 		VolumeMounts: []corev1.VolumeMount{
 			{
 				Name:      "scratch-data",
@@ -21,13 +100,41 @@ func getSidecarContainer(pgPod *corev1.Pod, parameters map[string]string) corev1
 				Name:      "plugins",
 				MountPath: "/plugins",
 			},
+			{
+				Name:      scratchVolumeName,
+				MountPath: scratchMountPath,
+			},
 		},
 		Image:           parameters[config.ImageNameParam],
 		ImagePullPolicy: corev1.PullPolicy(parameters[config.ImagePullPolicyParam]),
+		Args: []string{
+			"plugin",
+			fmt.Sprintf("--health-address=:%d", health.DefaultPort),
+		},
+		Resources:       resources,
+		SecurityContext: getSidecarSecurityContext(),
+		EnvFrom:         envFrom,
+		// A sidecar which is not ready makes the whole instance pod
+		// not ready, which would take the primary out of its service
+		// when the object storage is unreachable. The readiness of the
+		// container leaves the bucket out, which is only checked by the
+		// plugin probe and the readiness endpoint
+		ReadinessProbe: getSidecarProbe(health.LivenessPath),
+		LivenessProbe:  getSidecarProbe(health.LivenessPath),
 		Env: []corev1.EnvVar{
 			{
-				Name:  "",
-				Value: parameters[config.PrefixParam],
+				Name: "NAMESPACE",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+				},
+			},
+			{
+				Name: "CLUSTER_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						FieldPath: fmt.Sprintf("metadata.labels['%s']", utils.ClusterLabelName),
+					},
+				},
 			},
 		},
 	}
@@ -39,47 +146,100 @@ func getSidecarContainer(pgPod *corev1.Pod, parameters map[string]string) corev1
 		}
 	}
 
-	if len(parameters[config.BucketParam]) > 0 {
-		result.Env = append(result.Env, corev1.EnvVar{
-			Name:  "AWS_BUCKET",
-			Value: parameters[config.BucketParam],
-		})
+	for _, env := range parameterEnv {
+		if len(parameters[env.parameter]) > 0 {
+			result.Env = append(result.Env, corev1.EnvVar{
+				Name:  env.name,
+				Value: parameters[env.parameter],
+			})
+		}
 	}
 
-	if len(parameters[config.BucketParam]) > 0 {
-		result.Env = append(result.Env, corev1.EnvVar{
-			Name:  "BACKUP_PREFIX",
-			Value: parameters[config.PrefixParam],
-		})
+	// User supplied variables come last, so that they can
+	// override the ones generated by the plugin
+	result.Env = append(result.Env, extraEnv...)
+
+	return result, nil
+}
+
+// getSidecarProbe is a probe querying the sidecar health server
+func getSidecarProbe(path string) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt32(health.DefaultPort),
+			},
+		},
+		PeriodSeconds:    10,
+		TimeoutSeconds:   6,
+		FailureThreshold: 3,
 	}
+}
 
-	if len(parameters[config.RegionParam]) > 0 {
-		result.Env = append(result.Env, corev1.EnvVar{
-			Name:  "AWS_REGION",
-			Value: parameters[config.RegionParam],
-		})
+// getSidecarSecurityContext is the restricted security context of the sidecar
+func getSidecarSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr.To(true),
+		ReadOnlyRootFilesystem:   ptr.To(true),
+		AllowPrivilegeEscalation: ptr.To(false),
+		Privileged:               ptr.To(false),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
 	}
+}
 
-	if len(parameters[config.EndpointParam]) > 0 {
-		result.Env = append(result.Env, corev1.EnvVar{
-			Name:  "AWS_ENDPOINT_URL",
-			Value: parameters[config.EndpointParam],
-		})
+// getScratchVolume is the volume backing the sidecar working directory.
+// It is an emptyDir, optionally size limited, unless a storage class is
+// set, in which case a generic ephemeral volume is requested
+func getScratchVolume(parameters map[string]string) (corev1.Volume, error) {
+	result := corev1.Volume{
+		Name: scratchVolumeName,
 	}
 
-	if len(parameters[config.AwsKeyParam]) > 0 {
-		result.Env = append(result.Env, corev1.EnvVar{
-			Name:  "AWS_ACCESS_KEY_ID",
-			Value: parameters[config.AwsKeyParam],
-		})
+	var size *resource.Quantity
+	if value := parameters[config.ScratchVolumeSizeParam]; len(value) > 0 {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return result, fmt.Errorf("invalid %s %q: %w", config.ScratchVolumeSizeParam, value, err)
+		}
+		size = &quantity
+	}
+
+	storageClass := parameters[config.ScratchStorageClassParam]
+	if len(storageClass) == 0 {
+		result.VolumeSource = corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{
+				SizeLimit: size,
+			},
+		}
+		return result, nil
 	}
 
-	if len(parameters[config.AwsSecretKeyParam]) > 0 {
-		result.Env = append(result.Env, corev1.EnvVar{
-			Name:  "AWS_SECRET_ACCESS_KEY",
-			Value: parameters[config.AwsSecretKeyParam],
-		})
+	if size == nil {
+		return result, fmt.Errorf("%s is required when %s is set",
+			config.ScratchVolumeSizeParam, config.ScratchStorageClassParam)
+	}
+
+	result.VolumeSource = corev1.VolumeSource{
+		Ephemeral: &corev1.EphemeralVolumeSource{
+			VolumeClaimTemplate: &corev1.PersistentVolumeClaimTemplate{
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					StorageClassName: &storageClass,
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceStorage: *size,
+						},
+					},
+				},
+			},
+		},
 	}
 
-	return result
+	return result, nil
 }
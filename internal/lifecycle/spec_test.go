@@ -0,0 +1,425 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/cloudnative-pg/cloudnative-pg/api/v1"
+	"github.com/cloudnative-pg/cnpg-i/pkg/lifecycle"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/health"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
+)
+
+// baseParameters are the parameters every test cluster needs
+func baseParameters() map[string]string {
+	return map[string]string{
+		config.ImageNameParam: "ghcr.io/dougkirkley/cnpg-plugin-s3-backup:v1",
+		config.BucketParam:    "backups",
+	}
+}
+
+func newCluster(parameters map[string]string) apiv1.Cluster {
+	return apiv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cluster-example"},
+		Spec: apiv1.ClusterSpec{
+			Plugins: apiv1.PluginConfigurationList{
+				{Name: metadata.PluginName, Parameters: parameters},
+			},
+		},
+	}
+}
+
+// newInstancePod is an instance pod as created by CloudNativePG,
+// before the plugin injects its sidecar
+func newInstancePod() corev1.Pod {
+	return corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cluster-example-1"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "postgres",
+					Image: "ghcr.io/cloudnative-pg/postgresql:17",
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "pgdata", MountPath: "/var/lib/postgresql/data"},
+						{Name: "scratch-data", MountPath: "/controller"},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{Name: "pgdata"},
+				{Name: "scratch-data"},
+			},
+		},
+	}
+}
+
+// reconcile runs the lifecycle hook on the pod, returning the raw patch
+// and the pod it produces
+func reconcile(
+	t *testing.T,
+	operation lifecycle.OperatorOperationType_Type,
+	cluster apiv1.Cluster,
+	pod corev1.Pod,
+) ([]byte, corev1.Pod) {
+	t.Helper()
+
+	podJSON, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterJSON, err := json.Marshal(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := Lifecycle{}.LifecycleHook(context.Background(), &lifecycle.OperatorLifecycleRequest{
+		OperationType:     &lifecycle.OperatorOperationType{Type: operation},
+		ClusterDefinition: clusterJSON,
+		ObjectDefinition:  podJSON,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pod which needs no change gets an empty patch
+	if len(response.JsonPatch) == 0 {
+		return response.JsonPatch, pod
+	}
+
+	patch, err := jsonpatch.DecodePatch(response.JsonPatch)
+	if err != nil {
+		t.Fatalf("invalid JSON patch %s: %v", response.JsonPatch, err)
+	}
+	patchedJSON, err := patch.Apply(podJSON)
+	if err != nil {
+		t.Fatalf("cannot apply JSON patch %s: %v", response.JsonPatch, err)
+	}
+
+	var patched corev1.Pod
+	if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+		t.Fatal(err)
+	}
+
+	return response.JsonPatch, patched
+}
+
+// patchTouches is true when an operation of the patch changes the path
+// or one of its children
+func patchTouches(t *testing.T, patch []byte, path string) bool {
+	t.Helper()
+
+	if len(patch) == 0 {
+		return false
+	}
+
+	var operations []struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		t.Fatalf("invalid JSON patch %s: %v", patch, err)
+	}
+
+	for _, operation := range operations {
+		if operation.Path == path || strings.HasPrefix(operation.Path, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// findSidecars returns the sidecar containers of a pod
+func findSidecars(pod corev1.Pod) []corev1.Container {
+	var result []corev1.Container
+	for _, container := range pod.Spec.Containers {
+		if container.Name == sidecarName {
+			result = append(result, container)
+		}
+	}
+	return result
+}
+
+// injectedSidecar reconciles a new instance pod, returning its sidecar
+func injectedSidecar(t *testing.T, parameters map[string]string) corev1.Container {
+	t.Helper()
+
+	patch, pod := reconcile(t, lifecycle.OperatorOperationType_TYPE_CREATE, newCluster(parameters), newInstancePod())
+	if !patchTouches(t, patch, "/spec/containers/1") {
+		t.Errorf("the patch doesn't add the sidecar after the postgres container: %s", patch)
+	}
+
+	sidecars := findSidecars(pod)
+	if len(sidecars) != 1 {
+		t.Fatalf("found %d sidecars, want 1", len(sidecars))
+	}
+
+	return sidecars[0]
+}
+
+func TestSidecarResources(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       corev1.ResourceRequirements
+	}{
+		{
+			name: "no resources",
+		},
+		{
+			name: "requests and limits",
+			parameters: map[string]string{
+				config.SidecarCPURequestParam:    "100m",
+				config.SidecarMemoryRequestParam: "128Mi",
+				config.SidecarCPULimitParam:      "1",
+				config.SidecarMemoryLimitParam:   "1Gi",
+			},
+			want: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("128Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+		},
+		{
+			name:       "memory limit only",
+			parameters: map[string]string{config.SidecarMemoryLimitParam: "512Mi"},
+			want: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parameters := baseParameters()
+			for name, value := range tt.parameters {
+				parameters[name] = value
+			}
+
+			got := injectedSidecar(t, parameters).Resources
+			for _, list := range []struct {
+				name      string
+				got, want corev1.ResourceList
+			}{
+				{"requests", got.Requests, tt.want.Requests},
+				{"limits", got.Limits, tt.want.Limits},
+			} {
+				if len(list.got) != len(list.want) {
+					t.Errorf("%s = %v, want %v", list.name, list.got, list.want)
+				}
+				for name, quantity := range list.want {
+					if value, ok := list.got[name]; !ok || value.Cmp(quantity) != 0 {
+						t.Errorf("%s[%s] = %v, want %v", list.name, name, list.got[name], quantity)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSidecarSecurityContext(t *testing.T) {
+	securityContext := injectedSidecar(t, baseParameters()).SecurityContext
+	if securityContext == nil {
+		t.Fatal("the sidecar has no security context")
+	}
+
+	checks := []struct {
+		name string
+		ok   bool
+	}{
+		{"runAsNonRoot", securityContext.RunAsNonRoot != nil && *securityContext.RunAsNonRoot},
+		{"readOnlyRootFilesystem",
+			securityContext.ReadOnlyRootFilesystem != nil && *securityContext.ReadOnlyRootFilesystem},
+		{"allowPrivilegeEscalation",
+			securityContext.AllowPrivilegeEscalation != nil && !*securityContext.AllowPrivilegeEscalation},
+		{"privileged", securityContext.Privileged != nil && !*securityContext.Privileged},
+		{"capabilities", securityContext.Capabilities != nil &&
+			len(securityContext.Capabilities.Drop) == 1 && securityContext.Capabilities.Drop[0] == "ALL" &&
+			len(securityContext.Capabilities.Add) == 0},
+		{"seccompProfile", securityContext.SeccompProfile != nil &&
+			securityContext.SeccompProfile.Type == corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	for _, check := range checks {
+		if !check.ok {
+			t.Errorf("%s is not restricted: %+v", check.name, securityContext)
+		}
+	}
+}
+
+func TestSidecarEnv(t *testing.T) {
+	parameters := baseParameters()
+	parameters[config.PrefixParam] = "default/cluster-example"
+	parameters[config.CompressionParam] = config.CompressionGzip
+	parameters[config.RegionParam] = "eu-west-1"
+	parameters[config.ScheduleParam] = "0 0 * * *"
+	parameters[config.SidecarEnvParam] = "HTTPS_PROXY=http://proxy:3128,AWS_REGION=eu-central-1"
+	parameters[config.SidecarEnvFromParam] = "secret/s3-credentials,configMap/proxy-settings"
+
+	sidecar := injectedSidecar(t, parameters)
+
+	values := make(map[string]string)
+	for i, env := range sidecar.Env {
+		if len(env.Name) == 0 {
+			t.Errorf("environment variable %d has no name: %+v", i, env)
+		}
+		values[env.Name] = env.Value
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"AWS_BUCKET", "backups"},
+		{"BACKUP_PREFIX", "default/cluster-example"},
+		{"COMPRESSION", config.CompressionGzip},
+		{"SCHEDULE", "0 0 * * *"},
+		{"HTTPS_PROXY", "http://proxy:3128"},
+		// User supplied variables override the generated ones
+		{"AWS_REGION", "eu-central-1"},
+	}
+	for _, tt := range tests {
+		if values[tt.name] != tt.value {
+			t.Errorf("%s = %q, want %q", tt.name, values[tt.name], tt.value)
+		}
+	}
+
+	if _, ok := values["RETENTION_POLICY"]; ok {
+		t.Errorf("RETENTION_POLICY is set without a retention policy")
+	}
+
+	// The last definition of a variable wins, so the user supplied
+	// ones must follow the generated ones
+	last := sidecar.Env[len(sidecar.Env)-1]
+	if last.Name != "AWS_REGION" || last.Value != "eu-central-1" {
+		t.Errorf("the last variable is %+v, want the user supplied AWS_REGION", last)
+	}
+
+	if len(sidecar.EnvFrom) != 2 ||
+		sidecar.EnvFrom[0].SecretRef == nil || sidecar.EnvFrom[0].SecretRef.Name != "s3-credentials" ||
+		sidecar.EnvFrom[1].ConfigMapRef == nil || sidecar.EnvFrom[1].ConfigMapRef.Name != "proxy-settings" {
+		t.Errorf("envFrom = %+v, want secret s3-credentials and configMap proxy-settings", sidecar.EnvFrom)
+	}
+}
+
+func TestSidecarEnvHasNoEmptyNames(t *testing.T) {
+	// Every parameter passed to the sidecar through its environment
+	parameters := baseParameters()
+	for name, value := range map[string]string{
+		config.RegionParam:                 "eu-west-1",
+		config.EndpointParam:               "https://s3.example.com",
+		config.AwsKeyParam:                 "key",
+		config.AwsSecretKeyParam:           "secret",
+		config.PrefixParam:                 "default/cluster-example",
+		config.CompressionParam:            config.CompressionNone,
+		config.RetentionPolicyParam:        "30d",
+		config.AllowSharedPrefixParam:      "true",
+		config.ScheduleParam:               "0 0 * * *",
+		config.ImmediateParam:              "true",
+		config.ScheduleJitterParam:         "5m",
+		config.ConcurrencyPolicyParam:      config.ConcurrencyPolicyForbid,
+		config.StartingDeadlineParam:       "1h",
+		config.ScheduleBackupTypeParam:     "logical",
+		config.LockTimeoutParam:            "10m",
+		config.PreBackupSQLParam:           "SELECT 1",
+		config.PostBackupSQLParam:          "SELECT 2",
+		config.PreBackupCommandParam:       "true",
+		config.PostBackupCommandParam:      "true",
+		config.HookTimeoutParam:            "1m",
+		config.MaxUploadBandwidthParam:     "10Mi",
+		config.MaxDumpRateParam:            "10Mi",
+		config.LowPriorityDumpParam:        "true",
+		config.NoRolePasswordsParam:        "true",
+		config.SkipManagedRolesParam:       "true",
+		config.DumpContentParam:            "schema",
+		config.PerDatabaseDumpsParam:       "true",
+		config.ObjectLockModeParam:         config.ObjectLockModeGovernance,
+		config.LockRetentionParam:          "7d",
+		config.LegalHoldParam:              "true",
+		config.StorageClassParam:           "STANDARD_IA",
+		config.TransitionAfterParam:        "30d",
+		config.TransitionStorageClassParam: "GLACIER",
+		config.ArchiveRestoreTierParam:     "Bulk",
+		config.ReplicaDestinationsParam:    "bucket=replica",
+		config.ObjectTagsParam:             "team=data",
+	} {
+		parameters[name] = value
+	}
+
+	sidecar := injectedSidecar(t, parameters)
+	if len(sidecar.Env) < len(parameters) {
+		t.Errorf("the sidecar has %d environment variables for %d parameters", len(sidecar.Env), len(parameters))
+	}
+	for i, env := range sidecar.Env {
+		if len(env.Name) == 0 {
+			t.Errorf("environment variable %d has no name: %+v", i, env)
+		}
+	}
+}
+
+func TestSidecarEnvFromParameters(t *testing.T) {
+	parameters := map[string]string{}
+	for _, env := range parameterEnv {
+		parameters[env.parameter] = "value of " + env.parameter
+	}
+
+	pod := newInstancePod()
+	sidecar, err := getSidecarContainer(&pod, parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{}
+	for _, env := range sidecar.Env {
+		values[env.Name] = env.Value
+	}
+	for _, env := range parameterEnv {
+		if values[env.name] != parameters[env.parameter] {
+			t.Errorf("%s = %q, want the value of %s", env.name, values[env.name], env.parameter)
+		}
+	}
+
+	sidecar, err = getSidecarContainer(&pod, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range sidecar.Env {
+		if env.ValueFrom == nil {
+			t.Errorf("%s is set without its parameter", env.Name)
+		}
+	}
+}
+
+func TestSidecarProbes(t *testing.T) {
+	pod := newInstancePod()
+	sidecar, err := getSidecarContainer(&pod, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket must not be checked by the probes of the container,
+	// or an outage of the object storage would take the primary down
+	for name, probe := range map[string]*corev1.Probe{
+		"readiness": sidecar.ReadinessProbe,
+		"liveness":  sidecar.LivenessProbe,
+	} {
+		if probe == nil || probe.HTTPGet == nil {
+			t.Fatalf("the %s probe is not an HTTP probe", name)
+		}
+		if probe.HTTPGet.Path != health.LivenessPath {
+			t.Errorf("the %s probe queries %s, want %s", name, probe.HTTPGet.Path, health.LivenessPath)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/pluginhelper"
+	"github.com/cloudnative-pg/cnpg-i/pkg/operator"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+)
+
+// lineageCheckTimeout bounds the time the webhook spends reaching the bucket
+const lineageCheckTimeout = 5 * time.Second
+
+// validatePrefixOwner rejects a prefix already holding the backups of
+// another cluster, unless sharing it is allowed. The bucket can only be
+// read when the parameters carry static credentials, otherwise the check
+// is left to the sidecar, which refuses to back up into the prefix. A
+// bucket which cannot be reached doesn't block the change either
+func validatePrefixOwner(
+	ctx context.Context,
+	helper *pluginhelper.Data,
+	configuration *config.Configuration,
+) []*operator.ValidationError {
+	logger := logging.FromContext(ctx)
+
+	if configuration.AllowSharedPrefix == "true" || len(configuration.AwsKey) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lineageCheckTimeout)
+	defer cancel()
+
+	current, err := readLineage(ctx, configuration)
+	if err != nil {
+		logger.Info("Cannot read the owner of the backup prefix, leaving the check to the sidecar",
+			"bucket", configuration.Bucket, "prefix", configuration.Prefix, "error", err.Error())
+		return nil
+	}
+
+	cluster := helper.GetCluster()
+	owner := executor.LineageOwner{Namespace: cluster.Namespace, Name: cluster.Name}
+	if current == nil || *current == owner {
+		return nil
+	}
+
+	return []*operator.ValidationError{
+		helper.ValidationErrorForParameter(
+			config.PrefixParam,
+			fmt.Sprintf("prefix %q of bucket %s holds the backups of cluster %s, "+
+				"set %s to \"true\" to share it",
+				configuration.Prefix, configuration.Bucket, current.String(), config.AllowSharedPrefixParam),
+		),
+	}
+}
+
+// readLineage reads the owner of the prefix with the credentials of
+// the plugin parameters
+func readLineage(ctx context.Context, configuration *config.Configuration) (*executor.LineageOwner, error) {
+	loadOptions := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			configuration.AwsKey, configuration.AwsSecretKey, "")),
+	}
+	if len(configuration.Region) > 0 {
+		loadOptions = append(loadOptions, awsconfig.WithRegion(configuration.Region))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The repository checks the bucket without a deadline, the webhook
+	// cannot wait longer than its own timeout
+	cfg.HTTPClient = awshttp.NewBuildableClient().WithTimeout(lineageCheckTimeout)
+	cfg.RetryMaxAttempts = 1
+
+	// Endpoints other than AWS are S3 compatible services,
+	// which generally address the bucket in the path
+	var s3Options []func(*s3.Options)
+	if len(configuration.Endpoint) > 0 {
+		cfg.BaseEndpoint = aws.String(configuration.Endpoint)
+		s3Options = append(s3Options, func(o *s3.Options) {
+			o.UsePathStyle = true
+		})
+	}
+
+	repo, err := executor.NewRepositoryWithDependencies(
+		configuration.Bucket,
+		configuration.Prefix,
+		configuration.Compression,
+		executor.Dependencies{AWSConfig: &cfg, S3Options: s3Options},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.ReadLineage(ctx)
+}
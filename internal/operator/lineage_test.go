@@ -0,0 +1,79 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudnative-pg/cnpg-i/pkg/operator"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/harness"
+)
+
+func TestValidateClusterCreatePrefixOwner(t *testing.T) {
+	s3Server := harness.NewS3Server("backups")
+	defer s3Server.Close()
+	s3Server.Put("backups", "shared/lineage.json", []byte(`{"namespace":"default","name":"other"}`))
+	s3Server.Put("backups", "mine/lineage.json", []byte(`{"namespace":"default","name":"cluster-example"}`))
+
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		wantError  bool
+	}{
+		{name: "free prefix", parameters: map[string]string{config.PrefixParam: "free"}},
+		{name: "prefix owned by the cluster", parameters: map[string]string{config.PrefixParam: "mine"}},
+		{
+			name:       "prefix owned by another cluster",
+			parameters: map[string]string{config.PrefixParam: "shared"},
+			wantError:  true,
+		},
+		{
+			name: "prefix shared explicitly",
+			parameters: map[string]string{
+				config.PrefixParam:            "shared",
+				config.AllowSharedPrefixParam: "true",
+			},
+		},
+		{
+			name: "no static credentials to check the bucket with",
+			parameters: map[string]string{
+				config.PrefixParam:       "shared",
+				config.AwsKeyParam:       "",
+				config.AwsSecretKeyParam: "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parameters := map[string]string{
+				config.ImageNameParam:    "plugin:latest",
+				config.BucketParam:       "backups",
+				config.EndpointParam:     s3Server.URL(),
+				config.RegionParam:       "us-east-1",
+				config.AwsKeyParam:       "key",
+				config.AwsSecretKeyParam: "secret",
+			}
+			for name, value := range tt.parameters {
+				if len(value) == 0 {
+					delete(parameters, name)
+				} else {
+					parameters[name] = value
+				}
+			}
+
+			result, err := Operator{}.ValidateClusterCreate(context.Background(),
+				&operator.OperatorValidateClusterCreateRequest{
+					Definition: mustMarshal(t, newCluster(false, parameters)),
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := len(result.ValidationErrors) > 0; got != tt.wantError {
+				t.Errorf("validation errors = %v, want errors: %v", result.ValidationErrors, tt.wantError)
+			}
+		})
+	}
+}
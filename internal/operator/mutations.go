@@ -12,9 +12,10 @@ import (
 )
 
 // MutateCluster is called to mutate a cluster with the defaulting webhook.
-// This function is defaulting the "imagePullPolicy" plugin parameter
+// This function fills the empty plugin parameters with their defaults,
+// leaving validation to the validating webhook
 func (Operator) MutateCluster(
-	ctx context.Context,
+	_ context.Context,
 	request *operator.OperatorMutateClusterRequest,
 ) (*operator.OperatorMutateClusterResult, error) {
 	helper, err := pluginhelper.NewDataBuilder(
@@ -25,25 +26,16 @@ func (Operator) MutateCluster(
 		return nil, err
 	}
 
-	cfg, valErrs := config.FromParameters(ctx, helper)
-	if len(valErrs) > 0 {
-		return nil, valErrs[0]
-	}
-
 	mutatedCluster := helper.GetCluster().DeepCopy()
 	for i := range mutatedCluster.Spec.Plugins {
 		if mutatedCluster.Spec.Plugins[i].Name != metadata.PluginName {
 			continue
 		}
 
-		if mutatedCluster.Spec.Plugins[i].Parameters == nil {
-			mutatedCluster.Spec.Plugins[i].Parameters = make(map[string]string)
-		}
-
-		mutatedCluster.Spec.Plugins[i].Parameters, err = cfg.ToParameters()
-		if err != nil {
-			return nil, err
-		}
+		mutatedCluster.Spec.Plugins[i].Parameters = config.SetDefaults(
+			mutatedCluster,
+			mutatedCluster.Spec.Plugins[i].Parameters,
+		)
 	}
 
 	patch, err := helper.CreateClusterJSONPatch(*mutatedCluster)
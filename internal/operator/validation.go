@@ -2,6 +2,8 @@ package operator
 
 import (
 	"context"
+	"fmt"
+
 	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/pluginhelper"
 	"github.com/cloudnative-pg/cnpg-i/pkg/operator"
 
@@ -24,11 +26,28 @@ func (Operator) ValidateClusterCreate(
 		return nil, err
 	}
 
-	_, result.ValidationErrors = config.FromParameters(ctx, helper)
+	configuration, valErrs := config.FromParameters(ctx, helper)
+	result.ValidationErrors = valErrs
+	if len(valErrs) == 0 {
+		result.ValidationErrors = validatePrefixOwner(ctx, helper, configuration)
+	}
 
 	return result, nil
 }
 
+// AllowLineageChangeAnnotation is the cluster annotation allowing the
+// parameters that identify the backup lineage to be changed
+const AllowLineageChangeAnnotation = metadata.PluginName + "/allow-lineage-change"
+
+// immutableParameters are the parameters identifying where the backups of
+// a cluster are stored. Changing them on a live cluster breaks the lineage
+// between the existing backups and the new ones
+var immutableParameters = []string{
+	config.BucketParam,
+	config.PrefixParam,
+	config.EndpointParam,
+}
+
 // ValidateClusterChange validates a cluster that is being changed
 func (Operator) ValidateClusterChange(
 	ctx context.Context,
@@ -36,5 +55,93 @@ func (Operator) ValidateClusterChange(
 ) (*operator.OperatorValidateClusterChangeResult, error) {
 	result := &operator.OperatorValidateClusterChangeResult{}
 
+	oldHelper, err := pluginhelper.NewDataBuilder(
+		metadata.PluginName,
+		request.OldCluster,
+	).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	newHelper, err := pluginhelper.NewDataBuilder(
+		metadata.PluginName,
+		request.NewCluster,
+	).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	newConfiguration, valErrs := config.FromParameters(ctx, newHelper)
+	result.ValidationErrors = append(result.ValidationErrors, valErrs...)
+	if len(valErrs) > 0 {
+		return result, nil
+	}
+
+	// The plugin is being enabled on an existing cluster, there
+	// is no previous configuration to compare with
+	if oldHelper.Parameters == nil {
+		result.ValidationErrors = validatePrefixOwner(ctx, newHelper, newConfiguration)
+		return result, nil
+	}
+
+	oldConfiguration, _ := config.FromParameters(ctx, oldHelper)
+	lineageErrors := validateLineageChanges(newHelper, oldConfiguration, newConfiguration)
+	if len(lineageErrors) > 0 {
+		result.ValidationErrors = append(result.ValidationErrors, lineageErrors...)
+		return result, nil
+	}
+
+	// The backups are moving to another prefix, which must be free
+	if lineageChanged(oldConfiguration, newConfiguration) {
+		result.ValidationErrors = validatePrefixOwner(ctx, newHelper, newConfiguration)
+	}
+
 	return result, nil
 }
+
+// validateLineageChanges rejects changes to the immutable parameters,
+// unless the cluster explicitly allows them with an annotation
+func validateLineageChanges(
+	helper *pluginhelper.Data,
+	oldConfiguration *config.Configuration,
+	newConfiguration *config.Configuration,
+) []*operator.ValidationError {
+	if helper.GetCluster().Annotations[AllowLineageChangeAnnotation] == "true" {
+		return nil
+	}
+
+	oldParameters, _ := oldConfiguration.ToParameters()
+	newParameters, _ := newConfiguration.ToParameters()
+
+	var result []*operator.ValidationError
+	for _, name := range immutableParameters {
+		if oldParameters[name] == newParameters[name] {
+			continue
+		}
+
+		result = append(result, helper.ValidationErrorForParameter(
+			name,
+			fmt.Sprintf(
+				"%s cannot be changed from %q to %q as it would break the backup lineage, "+
+					"set the %s annotation to \"true\" to allow it",
+				name, oldParameters[name], newParameters[name], AllowLineageChangeAnnotation,
+			),
+		))
+	}
+
+	return result
+}
+
+// lineageChanged is true when the backups are stored somewhere else
+func lineageChanged(oldConfiguration *config.Configuration, newConfiguration *config.Configuration) bool {
+	oldParameters, _ := oldConfiguration.ToParameters()
+	newParameters, _ := newConfiguration.ToParameters()
+
+	for _, name := range immutableParameters {
+		if oldParameters[name] != newParameters[name] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,147 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "github.com/cloudnative-pg/cloudnative-pg/api/v1"
+	"github.com/cloudnative-pg/cnpg-i/pkg/operator"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/pkg/metadata"
+)
+
+func newCluster(created bool, parameters map[string]string) *apiv1.Cluster {
+	cluster := &apiv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "cluster-example",
+		},
+		Spec: apiv1.ClusterSpec{
+			Plugins: apiv1.PluginConfigurationList{
+				{Name: metadata.PluginName, Parameters: parameters},
+			},
+		},
+	}
+	if created {
+		cluster.CreationTimestamp = metav1.NewTime(time.Now())
+	}
+
+	return cluster
+}
+
+func mustMarshal(t *testing.T, value any) []byte {
+	t.Helper()
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// mutate applies the defaults MutateCluster would fill in
+func mutate(cluster *apiv1.Cluster) *apiv1.Cluster {
+	result := cluster.DeepCopy()
+	result.Spec.Plugins[0].Parameters = config.SetDefaults(result, result.Spec.Plugins[0].Parameters)
+	return result
+}
+
+func TestMutateClusterDefaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		created       bool
+		wantPrefix    string
+		wantRetention string
+	}{
+		{
+			name:          "new cluster",
+			created:       false,
+			wantPrefix:    "default/cluster-example",
+			wantRetention: config.DefaultRetentionPolicy,
+		},
+		{name: "existing cluster keeps the bucket root and its backups", created: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := newCluster(tt.created, map[string]string{config.BucketParam: "backups"})
+
+			result, err := Operator{}.MutateCluster(context.Background(), &operator.OperatorMutateClusterRequest{
+				Definition: mustMarshal(t, cluster),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			patch := string(result.JsonPatch)
+			if got := strings.Contains(patch, config.RetentionPolicyParam); got != (len(tt.wantRetention) > 0) {
+				t.Errorf("retention policy defaulted = %v, want %q, patch: %s", got, tt.wantRetention, patch)
+			}
+			if got := strings.Contains(patch, config.PrefixParam); got != (len(tt.wantPrefix) > 0) {
+				t.Errorf("prefix defaulted = %v, want %q, patch: %s", got, tt.wantPrefix, patch)
+			}
+
+			parameters := mutate(cluster).Spec.Plugins[0].Parameters
+			if parameters[config.PrefixParam] != tt.wantPrefix {
+				t.Errorf("prefix = %q, want %q", parameters[config.PrefixParam], tt.wantPrefix)
+			}
+			if parameters[config.RetentionPolicyParam] != tt.wantRetention {
+				t.Errorf("retention policy = %q, want %q", parameters[config.RetentionPolicyParam], tt.wantRetention)
+			}
+		})
+	}
+}
+
+func TestValidateClusterChangeLineage(t *testing.T) {
+	existing := newCluster(true, map[string]string{
+		config.ImageNameParam: "plugin:latest",
+		config.BucketParam:    "backups",
+	})
+
+	moved := mutate(existing)
+	moved.Spec.Plugins[0].Parameters[config.PrefixParam] = "elsewhere"
+
+	tests := []struct {
+		name       string
+		newCluster *apiv1.Cluster
+		wantErrors []string
+	}{
+		{
+			name:       "defaulting an existing cluster without a prefix",
+			newCluster: mutate(existing),
+		},
+		{
+			name:       "moving the backups to another prefix",
+			newCluster: moved,
+			wantErrors: []string{config.PrefixParam},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Operator{}.ValidateClusterChange(
+				context.Background(),
+				&operator.OperatorValidateClusterChangeRequest{
+					OldCluster: mustMarshal(t, existing),
+					NewCluster: mustMarshal(t, tt.newCluster),
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, validationError := range result.ValidationErrors {
+				got = append(got, validationError.PathComponents[len(validationError.PathComponents)-1])
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantErrors, ",") {
+				t.Errorf("errors on %v, want %v: %v", got, tt.wantErrors, result.ValidationErrors)
+			}
+		})
+	}
+}
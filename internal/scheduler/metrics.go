@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "cnpg_s3_backup"
+
+	resultSucceeded = "succeeded"
+	resultFailed    = "failed"
+	resultSkipped   = "skipped"
+)
+
+var (
+	nextRunTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "schedule",
+		Name:      "next_run_timestamp_seconds",
+		Help:      "The time the next scheduled backup is due",
+	})
+
+	lastScheduleTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "schedule",
+		Name:      "last_schedule_timestamp_seconds",
+		Help:      "The time the last scheduled backup was due",
+	})
+
+	lastRunTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "schedule",
+		Name:      "last_run_timestamp_seconds",
+		Help:      "The time the last scheduled backup started",
+	})
+
+	lastSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "schedule",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "The time the last successful scheduled backup completed",
+	})
+
+	runsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "schedule",
+		Name:      "runs_total",
+		Help:      "The number of scheduled backups, by result",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(nextRunTime, lastScheduleTime, lastRunTime, lastSuccessTime, runsTotal)
+}
@@ -0,0 +1,294 @@
+// Package scheduler takes the backups scheduled with a cron expression
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudnative-pg/cnpg-i-machinery/pkg/logging"
+	"github.com/robfig/cron"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+)
+
+// errReplaced is the cause of the cancellation of a backup replaced
+// by a newer scheduled one
+var errReplaced = errors.New("replaced by a newer scheduled backup")
+
+// Scheduler takes a backup every time its cron schedule is due. It runs
+// in every instance sidecar, but only the primary takes the backups
+type Scheduler struct {
+	spec              string
+	schedule          cron.Schedule
+	immediate         bool
+	jitter            time.Duration
+	concurrencyPolicy string
+	startingDeadline  time.Duration
+	options           backup.Options
+
+	mu      sync.Mutex
+	cancel  context.CancelCauseFunc
+	running chan struct{}
+}
+
+// FromEnv creates a scheduler from the environment of the sidecar,
+// returning nil when no schedule has been set. The scheduled backups
+// use the passed dependencies
+func FromEnv(dependencies executor.Dependencies) (*Scheduler, error) {
+	spec := os.Getenv("SCHEDULE")
+	if len(spec) == 0 {
+		return nil, nil
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+
+	jitter, err := parseDuration("SCHEDULE_JITTER")
+	if err != nil {
+		return nil, err
+	}
+
+	startingDeadline, err := parseDuration("STARTING_DEADLINE")
+	if err != nil {
+		return nil, err
+	}
+
+	backupType, err := executor.ParseBackupType(os.Getenv("SCHEDULE_BACKUP_TYPE"))
+	if err != nil {
+		return nil, err
+	}
+
+	concurrencyPolicy := os.Getenv("CONCURRENCY_POLICY")
+	if len(concurrencyPolicy) == 0 {
+		concurrencyPolicy = config.ConcurrencyPolicyForbid
+	}
+
+	options, err := backup.OptionsFromEnv(executor.LineageOwner{
+		Namespace: os.Getenv("NAMESPACE"),
+		Name:      os.Getenv("CLUSTER_NAME"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	options.BackupType = backupType
+	options.Dependencies = dependencies
+
+	return &Scheduler{
+		spec:              spec,
+		schedule:          schedule,
+		immediate:         os.Getenv("IMMEDIATE") == "true",
+		jitter:            jitter,
+		concurrencyPolicy: concurrencyPolicy,
+		startingDeadline:  startingDeadline,
+		options:           options,
+	}, nil
+}
+
+func parseDuration(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+
+	return duration, nil
+}
+
+// Run takes the scheduled backups until the context is canceled
+func (s *Scheduler) Run(ctx context.Context) error {
+	logger := logging.FromContext(ctx).WithName("scheduler")
+	ctx = logging.IntoContext(ctx, logger)
+
+	repo, err := executor.NewRepositoryWithDependencies(
+		s.options.Bucket,
+		s.options.Prefix,
+		s.options.Compression,
+		s.options.Dependencies,
+	)
+	if err != nil {
+		return err
+	}
+
+	state, err := repo.ReadScheduleState(ctx)
+	if err != nil {
+		logger.Error(err, "while reading the schedule state, missed backups won't be caught up")
+		state = &executor.ScheduleState{}
+	}
+	if state.LastScheduleTime != nil {
+		lastScheduleTime.Set(float64(state.LastScheduleTime.Unix()))
+	}
+	if state.LastSuccessfulTime != nil {
+		lastSuccessTime.Set(float64(state.LastSuccessfulTime.Unix()))
+	}
+
+	if due, ok := s.missedRun(state, time.Now()); ok {
+		logger.Info("Catching up on a missed scheduled backup", "scheduledAt", due)
+		s.trigger(ctx, repo, due)
+	}
+
+	for {
+		next := s.schedule.Next(time.Now())
+		nextRunTime.Set(float64(next.Unix()))
+
+		delay := time.Until(next) + s.randomJitter()
+		logger.Info("Waiting for the next scheduled backup", "schedule", s.spec, "next", next, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.wait()
+			return nil
+		case <-timer.C:
+		}
+
+		s.trigger(ctx, repo, next)
+	}
+}
+
+// missedRun finds the scheduled backup which should be taken right away:
+// the first one when the schedule is immediate, or the latest one which
+// was missed while the sidecar was not running, if within the deadline
+func (s *Scheduler) missedRun(state *executor.ScheduleState, now time.Time) (time.Time, bool) {
+	if state.LastScheduleTime == nil || state.Schedule != s.spec {
+		return now, s.immediate && state.LastScheduleTime == nil
+	}
+
+	var missed time.Time
+	for due := s.schedule.Next(*state.LastScheduleTime); !due.After(now); due = s.schedule.Next(due) {
+		missed = due
+	}
+	if missed.IsZero() {
+		return missed, false
+	}
+
+	if s.startingDeadline > 0 && now.Sub(missed) > s.startingDeadline {
+		return missed, false
+	}
+
+	return missed, true
+}
+
+func (s *Scheduler) randomJitter() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
+
+// trigger starts a scheduled backup, honoring the concurrency policy
+func (s *Scheduler) trigger(ctx context.Context, repo *executor.Repository, scheduledAt time.Time) {
+	logger := logging.FromContext(ctx)
+
+	primary, err := repo.IsPrimary(ctx)
+	if err != nil {
+		logger.Error(err, "while checking if the instance is the primary, skipping the scheduled backup")
+		runsTotal.WithLabelValues(resultSkipped).Inc()
+		return
+	}
+	if !primary {
+		logger.V(4).Info("Not the primary, skipping the scheduled backup")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running != nil {
+		select {
+		case <-s.running:
+		default:
+			if s.concurrencyPolicy == config.ConcurrencyPolicyForbid {
+				logger.Info("A backup is still running, skipping the scheduled backup", "scheduledAt", scheduledAt)
+				runsTotal.WithLabelValues(resultSkipped).Inc()
+				return
+			}
+
+			logger.Info("A backup is still running, replacing it", "scheduledAt", scheduledAt)
+			s.cancel(errReplaced)
+			<-s.running
+		}
+	}
+
+	backupCtx, cancel := context.WithCancelCause(ctx)
+	running := make(chan struct{})
+	s.cancel = cancel
+	s.running = running
+
+	go func() {
+		defer close(running)
+		defer cancel(nil)
+		s.takeBackup(backupCtx, repo, scheduledAt)
+	}()
+}
+
+// takeBackup takes a scheduled backup and records the outcome in the catalog
+func (s *Scheduler) takeBackup(ctx context.Context, repo *executor.Repository, scheduledAt time.Time) {
+	logger := logging.FromContext(ctx)
+
+	startedAt := time.Now()
+	lastScheduleTime.Set(float64(scheduledAt.Unix()))
+	lastRunTime.Set(float64(startedAt.Unix()))
+
+	state := &executor.ScheduleState{
+		Schedule:         s.spec,
+		LastScheduleTime: &scheduledAt,
+		LastRunTime:      &startedAt,
+	}
+	if previous, err := repo.ReadScheduleState(ctx); err == nil {
+		state.LastSuccessfulTime = previous.LastSuccessfulTime
+		state.LastBackup = previous.LastBackup
+	}
+
+	logger.Info("Taking scheduled backup", "scheduledAt", scheduledAt, "type", s.options.BackupType)
+	result, err := backup.PerformBackup(ctx, s.options)
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			err = fmt.Errorf("%w: %w", err, cause)
+		}
+		logger.Error(err, "Scheduled backup failed", "scheduledAt", scheduledAt)
+		runsTotal.WithLabelValues(resultFailed).Inc()
+		state.LastError = err.Error()
+	} else {
+		stoppedAt := time.Now()
+		logger.Info("Scheduled backup completed", "backupId", result.BackupId)
+		runsTotal.WithLabelValues(resultSucceeded).Inc()
+		lastSuccessTime.Set(float64(stoppedAt.Unix()))
+		state.LastSuccessfulTime = &stoppedAt
+		state.LastBackup = result.BackupId
+	}
+
+	next := s.schedule.Next(time.Now())
+	state.NextRunTime = &next
+
+	// The backup context may have been canceled, the state
+	// needs to be recorded anyway
+	if err := repo.WriteScheduleState(context.WithoutCancel(ctx), state); err != nil {
+		logger.Error(err, "while writing the schedule state")
+	}
+}
+
+// wait waits for the running backup, if any, to complete
+func (s *Scheduler) wait() {
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+
+	if running != nil {
+		<-running
+	}
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron"
+
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/backup/executor"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/config"
+	"github.com/dougkirkley/cnpg-plugin-s3-backup/internal/harness"
+)
+
+func newScheduler(t *testing.T, spec string) *Scheduler {
+	t.Helper()
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Scheduler{spec: spec, schedule: schedule}
+}
+
+func TestMissedRun(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.Local)
+	at := func(hour int, minute int) *time.Time {
+		result := time.Date(2024, 5, 10, hour, minute, 0, 0, time.Local)
+		return &result
+	}
+
+	tests := []struct {
+		name             string
+		immediate        bool
+		startingDeadline time.Duration
+		state            executor.ScheduleState
+
+		want   *time.Time
+		wantOk bool
+	}{
+		{
+			name: "first run",
+		},
+		{
+			name:      "first run of an immediate schedule",
+			immediate: true,
+			want:      &now,
+			wantOk:    true,
+		},
+		{
+			name:  "nothing missed",
+			state: executor.ScheduleState{Schedule: "0 * * * *", LastScheduleTime: at(12, 0)},
+		},
+		{
+			name:   "missed runs",
+			state:  executor.ScheduleState{Schedule: "0 * * * *", LastScheduleTime: at(9, 0)},
+			want:   at(12, 0),
+			wantOk: true,
+		},
+		{
+			name:             "missed run within the starting deadline",
+			startingDeadline: time.Hour,
+			state:            executor.ScheduleState{Schedule: "0 * * * *", LastScheduleTime: at(10, 0)},
+			want:             at(12, 0),
+			wantOk:           true,
+		},
+		{
+			name:             "missed run past the starting deadline",
+			startingDeadline: 10 * time.Minute,
+			state:            executor.ScheduleState{Schedule: "0 * * * *", LastScheduleTime: at(10, 0)},
+		},
+		{
+			name:      "changed schedule",
+			immediate: true,
+			state:     executor.ScheduleState{Schedule: "30 * * * *", LastScheduleTime: at(9, 30)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(t, "0 * * * *")
+			s.immediate = tt.immediate
+			s.startingDeadline = tt.startingDeadline
+
+			got, ok := s.missedRun(&tt.state, now)
+			if ok != tt.wantOk {
+				t.Fatalf("got a missed run %v, want %v", ok, tt.wantOk)
+			}
+			if ok && !got.Equal(*tt.want) {
+				t.Errorf("got the run due at %v, want %v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestTriggerConcurrencyPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+
+		// wantSecond is true when the state records the second
+		// backup, which replaced the first one
+		wantSecond bool
+	}{
+		{policy: config.ConcurrencyPolicyForbid},
+		{policy: config.ConcurrencyPolicyReplace, wantSecond: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			ctx := context.Background()
+			h, err := harness.New("backups")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(h.Close)
+
+			s := newScheduler(t, "0 * * * *")
+			s.concurrencyPolicy = tt.policy
+			s.options = h.Options("default", "cluster-example")
+			// The hook keeps the first backup running while the
+			// second one is triggered
+			s.options.Hooks = executor.Hooks{PreCommand: "sleep 1"}
+
+			repo, err := h.Repository("default", "cluster-example")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+			second := first.Add(time.Hour)
+			s.trigger(ctx, repo, first)
+			s.trigger(ctx, repo, second)
+			s.wait()
+
+			state, err := repo.ReadScheduleState(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := first
+			if tt.wantSecond {
+				want = second
+			}
+			if state.LastScheduleTime == nil || !state.LastScheduleTime.Equal(want) {
+				t.Errorf("the state records the backup due at %v, want %v", state.LastScheduleTime, want)
+			}
+			if len(state.LastError) > 0 || len(state.LastBackup) == 0 {
+				t.Errorf("the last backup %q failed: %s", state.LastBackup, state.LastError)
+			}
+
+			backups, err := repo.ListBackups(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 1 {
+				t.Errorf("got %d backups, want a single one", len(backups))
+			}
+		})
+	}
+}
@@ -6,6 +6,9 @@ import "github.com/cloudnative-pg/cnpg-i/pkg/identity"
 // PluginName is the name of the plugin
 const PluginName = "s3-backup.cloudnative-pg.io"
 
+// ImageRepository is the repository where the plugin images are published
+const ImageRepository = "ghcr.io/dougkirkley/cnpg-plugin-s3-backup"
+
 // Data is the metadata of this plugin
 var Data = identity.GetPluginMetadataResponse{
 	Name:          PluginName,
@@ -16,3 +19,8 @@ var Data = identity.GetPluginMetadataResponse{
 	License:       "Apache 2.0",
 	Maturity:      "alpha",
 }
+
+// DefaultImage is the image of the plugin matching the running version
+func DefaultImage() string {
+	return ImageRepository + ":v" + Data.Version
+}